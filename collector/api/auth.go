@@ -0,0 +1,35 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyAuth authenticates requests carrying an API key and sets the
+// organization_id context value for the key's organization. Keys are read
+// from a Bearer Authorization header or, for browser EventSource and
+// WebSocket clients that cannot set headers, the "token" query parameter.
+// Requests without a known key pass through unauthenticated.
+func KeyAuth(keys map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if orgID, ok := keys[token]; ok && token != "" {
+			c.Set("organization_id", orgID)
+		}
+		c.Next()
+	}
+}
+
+// authenticatedOrg returns the organization set by authentication middleware, if any.
+func authenticatedOrg(c *gin.Context) string {
+	if orgVal, exists := c.Get("organization_id"); exists {
+		if s, ok := orgVal.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
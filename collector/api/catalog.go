@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/hanzoai/analytics/collector/catalog"
+)
+
+// CatalogHandler serves the event and property catalog of the
+// authenticated organization.
+type CatalogHandler struct {
+	catalog *catalog.Catalog
+}
+
+// NewCatalogHandler creates a catalog handler backed by the given catalog.
+func NewCatalogHandler(c *catalog.Catalog) *CatalogHandler {
+	return &CatalogHandler{catalog: c}
+}
+
+// Route sets up catalog routes.
+func (h *CatalogHandler) Route(r *gin.RouterGroup) {
+	r.GET("/catalog/events", h.handleEvents)
+	r.GET("/catalog/events/:event/properties", h.handleProperties)
+	r.GET("/catalog/drift", h.handleDrift)
+}
+
+func (h *CatalogHandler) handleEvents(c *gin.Context) {
+	orgID := authenticatedOrg(c)
+	if orgID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": h.catalog.Events(orgID)})
+}
+
+func (h *CatalogHandler) handleProperties(c *gin.Context) {
+	orgID := authenticatedOrg(c)
+	if orgID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	properties, ok := h.catalog.Properties(orgID, c.Param("event"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": c.Param("event"), "properties": properties})
+}
+
+func (h *CatalogHandler) handleDrift(c *gin.Context) {
+	orgID := authenticatedOrg(c)
+	if orgID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	drift := h.catalog.Drift(orgID)
+	if drift == nil {
+		drift = []catalog.Entry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"properties": drift})
+}
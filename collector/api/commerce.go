@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// OrderRequest is a completed order with its line items.
+type OrderRequest struct {
+	OrderID        string `json:"order_id" binding:"required"`
+	DistinctID     string `json:"distinct_id" binding:"required"`
+	OrganizationID string `json:"organization_id"`
+	ProjectID      string `json:"project_id"`
+	SessionID      string `json:"session_id"`
+	CartID         string `json:"cart_id"`
+	// UUID identifies the request for deduplication of retries.
+	UUID     string              `json:"uuid"`
+	Products []collector.Product `json:"products" binding:"required,min=1"`
+	Currency string              `json:"currency"`
+	// Revenue is the order total before shipping and tax. It defaults to
+	// the sum of the lines less Discount.
+	Revenue    *float64               `json:"revenue"`
+	Discount   float64                `json:"discount"`
+	Shipping   float64                `json:"shipping"`
+	Tax        float64                `json:"tax"`
+	Coupon     string                 `json:"coupon"`
+	URL        string                 `json:"url"`
+	Referrer   string                 `json:"referrer"`
+	Timestamp  ClientTime             `json:"timestamp"`
+	SentAt     ClientTime             `json:"sent_at"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// handleOrder records an order_completed event whose products are
+// expanded into the order items table.
+func (h *Handler) handleOrder(c *gin.Context) {
+	var req OrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	revenue := collector.Subtotal(req.Products) - req.Discount
+	if req.Revenue != nil {
+		revenue = *req.Revenue
+	}
+	props := maps.Clone(req.Properties)
+	if props == nil {
+		props = make(map[string]interface{})
+	}
+	for k, v := range map[string]float64{"discount": req.Discount, "shipping": req.Shipping, "tax": req.Tax} {
+		if v != 0 {
+			props[k] = v
+		}
+	}
+	if req.Coupon != "" {
+		props["coupon"] = req.Coupon
+	}
+
+	event, err := h.buildRawEvent(c, &EventRequest{
+		Event:          collector.StandardEvents.OrderCompleted,
+		DistinctID:     req.DistinctID,
+		UUID:           req.UUID,
+		Timestamp:      req.Timestamp,
+		SentAt:         req.SentAt,
+		OrganizationID: req.OrganizationID,
+		ProjectID:      req.ProjectID,
+		SessionID:      req.SessionID,
+		Properties:     props,
+		URL:            req.URL,
+		Referrer:       req.Referrer,
+		OrderID:        req.OrderID,
+		CartID:         req.CartID,
+		Revenue:        revenue,
+		Currency:       req.Currency,
+		Products:       req.Products,
+	})
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "items": len(req.Products)})
+}
+
+// productsFromProperties reads a "products" property, as sent by clients
+// that predate the products field. Entries that are not objects are
+// skipped.
+func productsFromProperties(props map[string]interface{}) []collector.Product {
+	list, ok := props["products"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var products []collector.Product
+	for _, v := range list {
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		product := collector.Product{
+			ProductID: text(p["product_id"]),
+			SKU:       text(p["sku"]),
+			Name:      text(p["name"]),
+			Brand:     text(p["brand"]),
+			Category:  text(p["category"]),
+			Variant:   text(p["variant"]),
+			Price:     amount(p["price"]),
+			Quantity:  int(amount(p["quantity"])),
+			Discount:  amount(p["discount"]),
+			Coupon:    text(p["coupon"]),
+		}
+		if product.ProductID == "" {
+			product.ProductID = text(p["id"])
+		}
+		products = append(products, product)
+	}
+	return products
+}
+
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func amount(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return 0
+}
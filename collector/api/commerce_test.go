@@ -0,0 +1,29 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+func TestProductsFromProperties(t *testing.T) {
+	props := map[string]interface{}{"products": []interface{}{
+		map[string]interface{}{"id": "p1", "sku": "SKU-1", "name": "Mug", "category": "Kitchen", "price": 12.5, "quantity": float64(2), "coupon": "SAVE"},
+		map[string]interface{}{"product_id": 42, "price": 3},
+		"not a product",
+	}}
+	want := []collector.Product{
+		{ProductID: "p1", SKU: "SKU-1", Name: "Mug", Category: "Kitchen", Price: 12.5, Quantity: 2, Coupon: "SAVE"},
+		{ProductID: "42", Price: 3},
+	}
+	if got := productsFromProperties(props); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := productsFromProperties(map[string]interface{}{"products": "none"}); got != nil {
+		t.Errorf("expected no products, got %+v", got)
+	}
+	if got := collector.Subtotal(want); got != 28 {
+		t.Errorf("expected subtotal 28, got %v", got)
+	}
+}
@@ -2,25 +2,41 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/tracing"
 	"github.com/hanzoai/analytics/collector/writer"
 )
 
 // Handler handles analytics event collection.
 type Handler struct {
-	writer *writer.Writer
+	writer   *writer.Writer
+	logger   *slog.Logger
+	settings atomic.Pointer[Settings]
 }
 
-// NewHandler creates a new analytics handler.
-func NewHandler(w *writer.Writer) *Handler {
-	return &Handler{writer: w}
+// NewHandler creates a new analytics handler. A nil logger uses slog.Default.
+func NewHandler(w *writer.Writer, logger *slog.Logger) *Handler {
+	h := &Handler{
+		writer: w,
+		logger: logging.Or(logger).With("subsystem", "api"),
+	}
+	h.settings.Store(DefaultSettings())
+	return h
 }
 
 // Route sets up analytics routes.
@@ -35,13 +51,19 @@ func (h *Handler) Route(r *gin.RouterGroup) {
 	r.GET("/pixel.gif", h.handlePixel)
 	r.POST("/ai/message", h.handleAIMessage)
 	r.POST("/ai/completion", h.handleAICompletion)
+	r.POST("/commerce/order", h.handleOrder)
 }
 
 // EventRequest is the standard event request format.
 type EventRequest struct {
 	Event           string                 `json:"event" binding:"required"`
 	DistinctID      string                 `json:"distinct_id"`
-	Timestamp       string                 `json:"timestamp"`
+	UUID            string                 `json:"uuid"`
+	InsertID        string                 `json:"$insert_id"`
+	MessageID       string                 `json:"messageId"`
+	Timestamp       ClientTime             `json:"timestamp"`
+	SentAt          ClientTime             `json:"sent_at"`
+	Offset          *float64               `json:"offset"`
 	OrganizationID  string                 `json:"organization_id"`
 	ProjectID       string                 `json:"project_id"`
 	SessionID       string                 `json:"session_id"`
@@ -75,6 +97,8 @@ type EventRequest struct {
 	CartID          string                 `json:"cart_id"`
 	Revenue         float64                `json:"revenue"`
 	Quantity        int                    `json:"quantity"`
+	Currency        string                 `json:"currency"`
+	Products        []collector.Product    `json:"products"`
 }
 
 func (h *Handler) handleEvent(c *gin.Context) {
@@ -84,9 +108,13 @@ func (h *Handler) handleEvent(c *gin.Context) {
 		return
 	}
 
-	event := h.buildRawEvent(c, &req)
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	event, err := h.buildRawEvent(c, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -95,17 +123,44 @@ func (h *Handler) handleEvent(c *gin.Context) {
 func (h *Handler) handleBatch(c *gin.Context) {
 	var req struct {
 		Events []EventRequest `json:"events" binding:"required"`
+		// SentAt applies to events that do not carry their own.
+		SentAt ClientTime `json:"sent_at"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Refuse the whole batch when the writer cannot take it, so clients
+	// can retry it as is. Events refused one by one after that (rejected
+	// for their timestamp, or failed when the queue filled up meanwhile)
+	// are counted in the response.
+	if err := h.writer.Admit(len(req.Events)); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	rejected, failed := 0, 0
 	for _, eventReq := range req.Events {
-		event := h.buildRawEvent(c, &eventReq)
-		h.writer.Write(event)
+		if eventReq.SentAt == "" {
+			eventReq.SentAt = req.SentAt
+		}
+		event, err := h.buildRawEvent(c, &eventReq)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if err := h.write(c, event); err != nil {
+			failed++
+		}
+	}
+	resp := gin.H{"status": "ok", "count": len(req.Events) - rejected - failed}
+	if rejected > 0 {
+		resp["rejected"] = rejected
+	}
+	if failed > 0 {
+		resp["failed"] = failed
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "count": len(req.Events)})
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handler) handlePageView(c *gin.Context) {
@@ -116,9 +171,13 @@ func (h *Handler) handlePageView(c *gin.Context) {
 	}
 
 	req.Event = "$pageview"
-	event := h.buildRawEvent(c, &req)
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	event, err := h.buildRawEvent(c, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -149,8 +208,8 @@ func (h *Handler) handleIdentify(c *gin.Context) {
 		Lib:              "hanzo-analytics",
 	}
 
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -187,6 +246,10 @@ func (h *Handler) handleAST(c *gin.Context) {
 		return
 	}
 
+	if err := h.writer.Admit(1 + len(req.Sections)); err != nil {
+		h.writeError(c, err)
+		return
+	}
 	orgID := h.resolveOrg(c, req.OrganizationID)
 
 	pageEvent := &collector.RawEvent{
@@ -211,7 +274,10 @@ func (h *Handler) handleAST(c *gin.Context) {
 		pageEvent.Hostname = parsedURL.Host
 	}
 
-	h.writer.Write(pageEvent)
+	failed := 0
+	if err := h.write(c, pageEvent); err != nil {
+		failed++
+	}
 
 	for _, section := range req.Sections {
 		sectionEvent := &collector.RawEvent{
@@ -233,10 +299,16 @@ func (h *Handler) handleAST(c *gin.Context) {
 		if contentJSON, err := json.Marshal(section.Content); err == nil {
 			sectionEvent.ComponentData = string(contentJSON)
 		}
-		h.writer.Write(sectionEvent)
+		if err := h.write(c, sectionEvent); err != nil {
+			failed++
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "sections": len(req.Sections)})
+	resp := gin.H{"status": "ok", "sections": len(req.Sections)}
+	if failed > 0 {
+		resp["failed"] = failed
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *Handler) handleElement(c *gin.Context) {
@@ -261,11 +333,15 @@ func (h *Handler) handleElement(c *gin.Context) {
 		}
 	}
 
-	event := h.buildRawEvent(c, &req)
+	event, err := h.buildRawEvent(c, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
 	event.Lib = "astley.js"
 
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -282,11 +358,15 @@ func (h *Handler) handleSection(c *gin.Context) {
 		req.Event = "section_viewed"
 	}
 
-	event := h.buildRawEvent(c, &req)
+	event, err := h.buildRawEvent(c, &req)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
 	event.Lib = "astley.js"
 
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -318,7 +398,7 @@ func (h *Handler) handlePixel(c *gin.Context) {
 		Lib:       "hanzo-pixel",
 	}
 
-	h.writer.Write(event)
+	h.write(c, event)
 
 	c.Header("Content-Type", "image/gif")
 	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -372,8 +452,8 @@ func (h *Handler) handleAIMessage(c *gin.Context) {
 	event.Properties["role"] = req.Role
 	event.Properties["message_id"] = req.MessageID
 
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -424,13 +504,51 @@ func (h *Handler) handleAICompletion(c *gin.Context) {
 		Lib:       "hanzo-cloud",
 	}
 
-	if err := h.writer.Write(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	if err := h.write(c, event); err != nil {
+		h.writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// write hands the event to the writer, logging any failure.
+func (h *Handler) write(c *gin.Context, event *collector.RawEvent) error {
+	applyPrivacy(&h.settings.Load().Privacy, event)
+
+	err := h.writer.WriteContext(c.Request.Context(), event)
+	if err != nil && !errors.Is(err, writer.ErrOverloaded) && !errors.Is(err, writer.ErrUnavailable) {
+		h.logger.Error("write event failed",
+			"error", err,
+			"organization_id", event.OrganizationID,
+			"event", event.Event,
+			"route", c.FullPath(),
+		)
+	}
+	return err
+}
+
+// writeError responds to a failed write. When the writer is over capacity
+// or unavailable, clients are told when to retry; a request too large to
+// ever be accepted is refused without a Retry-After.
+func (h *Handler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, writer.ErrOverloaded):
+		c.Header("Retry-After", strconv.Itoa(int(h.writer.RetryAfter().Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "over capacity, retry later"})
+	case errors.Is(err, writer.ErrUnavailable):
+		c.Header("Retry-After", strconv.Itoa(int(h.writer.RetryAfter().Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily unavailable, retry later"})
+	case errors.Is(err, writer.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("too many events, send at most %d per request", h.writer.Capacity()),
+		})
+	case errors.Is(err, errImplausibleTimestamp):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to emit event"})
+	}
+}
+
 // resolveOrg returns the authenticated org ID if available, otherwise the request org ID.
 func (h *Handler) resolveOrg(c *gin.Context, requestOrgID string) string {
 	if orgVal, exists := c.Get("organization_id"); exists {
@@ -441,8 +559,26 @@ func (h *Handler) resolveOrg(c *gin.Context, requestOrgID string) string {
 	return requestOrgID
 }
 
-func (h *Handler) buildRawEvent(c *gin.Context, req *EventRequest) *collector.RawEvent {
+// buildRawEvent enriches a request into an event. It fails only when the
+// timestamp policy refuses the event's time.
+func (h *Handler) buildRawEvent(c *gin.Context, req *EventRequest) (*collector.RawEvent, error) {
+	ctx, span := tracing.Tracer().Start(c.Request.Context(), "enrich",
+		trace.WithAttributes(attribute.String("collector.event", req.Event)))
+	defer span.End()
+
 	orgID := h.resolveOrg(c, req.OrganizationID)
+	settings := h.settings.Load()
+	enrich := settings.Enrichment
+
+	timestamp, sentAt, original, err := resolveTimes(&settings.Timestamps, clientTimes{
+		Timestamp: req.Timestamp,
+		SentAt:    req.SentAt,
+		Offset:    req.Offset,
+	}, time.Now())
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
 
 	event := &collector.RawEvent{
 		Event:           req.Event,
@@ -480,36 +616,56 @@ func (h *Handler) buildRawEvent(c *gin.Context, req *EventRequest) *collector.Ra
 		CartID:          req.CartID,
 		Revenue:         req.Revenue,
 		Quantity:        req.Quantity,
+		Currency:        req.Currency,
+		Products:        req.Products,
 		IP:              c.ClientIP(),
 		UserAgent:       c.Request.UserAgent(),
-		Timestamp:       time.Now(),
-		SentAt:          time.Now(),
+		Timestamp:       timestamp,
+		SentAt:          sentAt,
 		Lib:             "hanzo-analytics",
 	}
 
-	if req.Timestamp != "" {
-		if t, err := time.Parse(time.RFC3339, req.Timestamp); err == nil {
-			event.Timestamp = t
+	event.EventID = clientEventID(req)
+	if event.Currency == "" {
+		event.Currency, _ = req.Properties["currency"].(string)
+	}
+	if len(event.Products) == 0 {
+		event.Products = productsFromProperties(req.Properties)
+	}
+	if event.Revenue == 0 && event.Event == collector.StandardEvents.OrderCompleted {
+		event.Revenue = collector.Subtotal(event.Products)
+	}
+
+	if original != "" {
+		if event.Properties == nil {
+			event.Properties = make(map[string]interface{})
 		}
+		event.Properties["$original_timestamp"] = original
 	}
 
 	if req.URL != "" {
+		_, urlSpan := tracing.Tracer().Start(ctx, "enrich.url")
 		if parsedURL, err := url.Parse(req.URL); err == nil {
 			event.URLPath = parsedURL.Path
 			event.Hostname = parsedURL.Host
 			query := parsedURL.Query()
-			event.UTMSource = query.Get("utm_source")
-			event.UTMMedium = query.Get("utm_medium")
-			event.UTMCampaign = query.Get("utm_campaign")
-			event.UTMContent = query.Get("utm_content")
-			event.UTMTerm = query.Get("utm_term")
-			event.GCLID = query.Get("gclid")
-			event.FBCLID = query.Get("fbclid")
-			event.MSCLID = query.Get("msclid")
+			if enrich.UTM {
+				event.UTMSource = query.Get("utm_source")
+				event.UTMMedium = query.Get("utm_medium")
+				event.UTMCampaign = query.Get("utm_campaign")
+				event.UTMContent = query.Get("utm_content")
+				event.UTMTerm = query.Get("utm_term")
+			}
+			if enrich.ClickIDs {
+				event.GCLID = query.Get("gclid")
+				event.FBCLID = query.Get("fbclid")
+				event.MSCLID = query.Get("msclid")
+			}
 		}
+		urlSpan.End()
 	}
 
-	if req.Referrer != "" {
+	if req.Referrer != "" && enrich.Referrer {
 		if parsedRef, err := url.Parse(req.Referrer); err == nil {
 			event.ReferrerDomain = parsedRef.Host
 		}
@@ -519,12 +675,32 @@ func (h *Handler) buildRawEvent(c *gin.Context, req *EventRequest) *collector.Ra
 		event.DistinctID = c.ClientIP()
 	}
 
-	ua := c.Request.UserAgent()
-	event.Browser, event.BrowserVersion = parseUserAgentBrowser(ua)
-	event.OS, event.OSVersion = parseUserAgentOS(ua)
-	event.DeviceType = parseDeviceType(ua)
+	if enrich.UserAgent {
+		_, uaSpan := tracing.Tracer().Start(ctx, "enrich.user_agent")
+		ua := c.Request.UserAgent()
+		event.Browser, event.BrowserVersion = parseUserAgentBrowser(ua)
+		event.OS, event.OSVersion = parseUserAgentOS(ua)
+		event.DeviceType = parseDeviceType(ua)
+		uaSpan.End()
+	}
 
-	return event
+	return event, nil
+}
+
+// clientEventID returns the idempotency key a client sent, as a top-level
+// field or a property, in the spellings common SDKs use.
+func clientEventID(req *EventRequest) string {
+	for _, id := range []string{req.UUID, req.InsertID, req.MessageID} {
+		if id != "" {
+			return id
+		}
+	}
+	for _, k := range []string{"$insert_id", "uuid", "messageId", "event_id"} {
+		if id, ok := req.Properties[k].(string); ok && id != "" {
+			return id
+		}
+	}
+	return ""
 }
 
 func parseUserAgentBrowser(ua string) (string, string) {
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/live"
+)
+
+// liveHeartbeat is how often idle live connections are pinged and told about drops.
+const liveHeartbeat = 15 * time.Second
+
+// LiveHandler streams accepted events to authenticated clients.
+type LiveHandler struct {
+	hub      *live.Hub
+	upgrader websocket.Upgrader
+}
+
+// NewLiveHandler creates a live stream handler backed by the given hub.
+func NewLiveHandler(hub *live.Hub) *LiveHandler {
+	return &LiveHandler{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			// Access is gated by the organization credential, not the origin.
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// Route sets up live stream routes.
+func (h *LiveHandler) Route(r *gin.RouterGroup) {
+	r.GET("/live", h.handleLive)
+}
+
+func (h *LiveHandler) handleLive(c *gin.Context) {
+	orgID := authenticatedOrg(c)
+	if orgID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	filter := live.Filter{
+		Events:     c.QueryArray("event"),
+		DistinctID: c.Query("distinct_id"),
+		URL:        c.Query("url"),
+	}
+
+	sub := h.hub.Subscribe(orgID, filter)
+	if sub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "live stream unavailable"})
+		return
+	}
+	defer h.hub.Unsubscribe(sub)
+
+	// Streams outlive the server's write timeout.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.streamWebSocket(c, sub)
+		return
+	}
+	h.streamSSE(c, sub)
+}
+
+func (h *LiveHandler) streamSSE(c *gin.Context, sub *live.Subscription) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(liveHeartbeat)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			body, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "event: event\ndata: %s\n\n", body); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			if dropped := sub.Dropped(); dropped != reported {
+				reported = dropped
+				if _, err := fmt.Fprintf(c.Writer, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped); err != nil {
+					return
+				}
+			} else if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// liveMessage is the WebSocket frame format.
+type liveMessage struct {
+	Type    string              `json:"type"`
+	Event   *collector.RawEvent `json:"event,omitempty"`
+	Dropped uint64              `json:"dropped,omitempty"`
+}
+
+func (h *LiveHandler) streamWebSocket(c *gin.Context, sub *live.Subscription) {
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Drain client frames so control messages are processed and a close is noticed.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(liveHeartbeat)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(liveMessage{Type: "event", Event: event}); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if dropped := sub.Dropped(); dropped != reported {
+				reported = dropped
+				if err := conn.WriteJSON(liveMessage{Type: "dropped", Dropped: dropped}); err != nil {
+					return
+				}
+			} else if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// Enrichment selects which fields are derived from the request.
+type Enrichment struct {
+	UserAgent bool
+	UTM       bool
+	ClickIDs  bool
+	Referrer  bool
+}
+
+// Privacy controls what personal data is kept on events.
+type Privacy struct {
+	AnonymizeIP   bool
+	DropIP        bool
+	DropUserAgent bool
+	// StripQueryParams removes these query parameters from URLs; "*" removes the whole query.
+	StripQueryParams []string
+}
+
+// Settings are the handler options that can be changed at runtime.
+type Settings struct {
+	Enrichment Enrichment
+	Privacy    Privacy
+	Timestamps Timestamps
+}
+
+// DefaultSettings enables all enrichment and no privacy filtering, and
+// clamps event times more than 23 hours ahead or a year behind.
+func DefaultSettings() *Settings {
+	return &Settings{
+		Enrichment: Enrichment{UserAgent: true, UTM: true, ClickIDs: true, Referrer: true},
+		Timestamps: Timestamps{MaxFuture: 23 * time.Hour, MaxPast: 365 * 24 * time.Hour, Policy: TimestampClamp},
+	}
+}
+
+// SetSettings replaces the handler settings; in-flight requests finish with the old ones.
+func (h *Handler) SetSettings(s *Settings) {
+	h.settings.Store(s)
+}
+
+// applyPrivacy scrubs personal data from the event according to p.
+func applyPrivacy(p *Privacy, event *collector.RawEvent) {
+	if p.DropIP || p.AnonymizeIP {
+		anonymized := anonymizeIP(event.IP)
+		// Events without a distinct_id fall back to the client IP.
+		if event.DistinctID == event.IP && event.IP != "" {
+			event.DistinctID = anonymized
+		}
+		if p.DropIP {
+			event.IP = ""
+		} else {
+			event.IP = anonymized
+		}
+	}
+	if p.DropUserAgent {
+		event.UserAgent = ""
+	}
+	if len(p.StripQueryParams) > 0 {
+		event.URL = stripQuery(event.URL, p.StripQueryParams)
+		event.Referrer = stripQuery(event.Referrer, p.StripQueryParams)
+	}
+}
+
+// anonymizeIP zeroes the host part of an address: the last octet of IPv4
+// and the last 80 bits of IPv6.
+func anonymizeIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func stripQuery(raw string, params []string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	query := u.Query()
+	for _, p := range params {
+		if p == "*" {
+			u.RawQuery = ""
+			return u.String()
+		}
+		query.Del(p)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Timestamp policies for events whose time is implausible or unreadable.
+const (
+	// TimestampClamp records the event at the receive time and keeps the
+	// client's value in the $original_timestamp property.
+	TimestampClamp = "clamp"
+	// TimestampReject refuses the event.
+	TimestampReject = "reject"
+)
+
+// Timestamps bounds the event times the collector accepts.
+type Timestamps struct {
+	// MaxFuture and MaxPast bound how far an event time may be from the
+	// receive time; zero disables the bound.
+	MaxFuture time.Duration
+	MaxPast   time.Duration
+	Policy    string
+}
+
+// errImplausibleTimestamp is returned for events refused by TimestampReject.
+var errImplausibleTimestamp = errors.New("implausible timestamp")
+
+// ClientTime is a time as sent by a client, kept raw so a value that cannot
+// be parsed is handled by the timestamp policy instead of failing the whole
+// request. It accepts JSON strings and numbers.
+type ClientTime string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *ClientTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = ClientTime(s)
+		return nil
+	}
+	*t = ClientTime(strings.TrimSpace(string(data)))
+	return nil
+}
+
+// layouts are the string forms accepted besides unix times; values without
+// a zone are UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseTime reads RFC 3339 and similar date-times, or unix seconds,
+// milliseconds, microseconds or nanoseconds told apart by magnitude.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("empty time")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return fromUnix(n, 0), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		whole, frac := math.Modf(f)
+		return fromUnix(int64(whole), frac), nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+// fromUnix converts n (plus a fraction of its unit) to a time, choosing
+// the unit from its magnitude: seconds before the year 5000, then
+// milliseconds, microseconds and nanoseconds.
+func fromUnix(n int64, frac float64) time.Time {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < 1e11:
+		return time.Unix(n, int64(frac*1e9)).UTC()
+	case abs < 1e14:
+		return time.UnixMilli(n).Add(time.Duration(frac * 1e6)).UTC()
+	case abs < 1e17:
+		return time.UnixMicro(n).Add(time.Duration(frac * 1e3)).UTC()
+	default:
+		return time.Unix(0, n).UTC()
+	}
+}
+
+// clientTimes are the time fields of one event.
+type clientTimes struct {
+	Timestamp ClientTime
+	SentAt    ClientTime
+	// Offset is how many milliseconds before sending the event happened.
+	Offset *float64
+}
+
+// resolveTimes returns when an event happened and was sent, correcting the
+// device clock like PostHog does: an offset is counted back from the
+// receive time, and a timestamp with a sent_at is shifted by the
+// difference between the device's and the server's clock. Times outside
+// the policy bounds, or that cannot be read, are clamped to the receive
+// time or refused. note is set to the client's value when it was clamped.
+func resolveTimes(p *Timestamps, ct clientTimes, now time.Time) (timestamp, sentAt time.Time, note string, err error) {
+	sentAt = now
+	sent, sentErr := ParseTime(string(ct.SentAt))
+	if sentErr == nil {
+		sentAt = sent
+	}
+
+	timestamp = now
+	var parseErr error
+	switch {
+	case ct.Offset != nil:
+		timestamp = now.Add(-time.Duration(*ct.Offset * float64(time.Millisecond)))
+		metrics.TimestampsAdjusted.WithLabelValues("offset").Inc()
+	case ct.Timestamp != "":
+		timestamp, parseErr = ParseTime(string(ct.Timestamp))
+		if parseErr == nil && sentErr == nil {
+			timestamp = now.Add(timestamp.Sub(sent))
+			metrics.TimestampsAdjusted.WithLabelValues("skew_corrected").Inc()
+		}
+	}
+
+	if parseErr == nil && (p.MaxFuture <= 0 || timestamp.Sub(now) <= p.MaxFuture) &&
+		(p.MaxPast <= 0 || now.Sub(timestamp) <= p.MaxPast) {
+		return timestamp, sentAt, "", nil
+	}
+	if p.Policy == TimestampReject {
+		metrics.EventsDropped.WithLabelValues("implausible_timestamp").Inc()
+		return time.Time{}, time.Time{}, "", errImplausibleTimestamp
+	}
+	metrics.TimestampsAdjusted.WithLabelValues("clamped").Inc()
+	note = string(ct.Timestamp)
+	if parseErr == nil {
+		note = timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return now, sentAt, note, nil
+}
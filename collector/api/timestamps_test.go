@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	for _, s := range []string{
+		"2024-03-01T12:30:45Z",
+		"2024-03-01T14:30:45+02:00",
+		"2024-03-01T12:30:45.000Z",
+		"2024-03-01T12:30:45",
+		"2024-03-01 12:30:45",
+		"1709296245",
+		"1709296245000",
+		"1709296245000000",
+		"1709296245000000000",
+	} {
+		got, err := ParseTime(s)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", s, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+
+	got, err := ParseTime("1709296245.5")
+	if err != nil || !got.Equal(want.Add(500*time.Millisecond)) {
+		t.Errorf("fractional seconds: got %v, %v", got, err)
+	}
+	for _, s := range []string{"", "yesterday", "2024-13-45"} {
+		if _, err := ParseTime(s); err == nil {
+			t.Errorf("ParseTime(%q): expected error", s)
+		}
+	}
+}
+
+func TestClientTime_UnmarshalJSON(t *testing.T) {
+	var req struct {
+		A ClientTime `json:"a"`
+		B ClientTime `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":"2024-03-01T12:30:45Z","b":1709296245000}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.A != "2024-03-01T12:30:45Z" || req.B != "1709296245000" {
+		t.Errorf("got %q, %q", req.A, req.B)
+	}
+}
+
+func TestResolveTimes(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clamp := &Timestamps{MaxFuture: 23 * time.Hour, MaxPast: 365 * 24 * time.Hour, Policy: TimestampClamp}
+	reject := &Timestamps{MaxFuture: 23 * time.Hour, MaxPast: 365 * 24 * time.Hour, Policy: TimestampReject}
+	offset := 1500.0
+
+	tests := []struct {
+		name   string
+		policy *Timestamps
+		times  clientTimes
+		want   time.Time
+		note   string
+		err    bool
+	}{
+		{name: "none", policy: clamp, want: now},
+		{
+			name:   "plain",
+			policy: clamp,
+			times:  clientTimes{Timestamp: "2024-03-01T11:00:00Z"},
+			want:   now.Add(-time.Hour),
+		},
+		{
+			// The device clock is two days fast; the event happened ten
+			// seconds before it was sent.
+			name:   "skew corrected",
+			policy: reject,
+			times:  clientTimes{Timestamp: "2024-03-03T11:59:50Z", SentAt: "2024-03-03T12:00:00Z"},
+			want:   now.Add(-10 * time.Second),
+		},
+		{
+			name:   "offset",
+			policy: clamp,
+			times:  clientTimes{Timestamp: "2020-01-01T00:00:00Z", Offset: &offset},
+			want:   now.Add(-1500 * time.Millisecond),
+		},
+		{
+			name:   "future clamped",
+			policy: clamp,
+			times:  clientTimes{Timestamp: "2024-03-05T00:00:00Z"},
+			want:   now,
+			note:   "2024-03-05T00:00:00Z",
+		},
+		{
+			name:   "past clamped",
+			policy: clamp,
+			times:  clientTimes{Timestamp: "1709"},
+			want:   now,
+			note:   "1970-01-01T00:28:29Z",
+		},
+		{
+			name:   "unparseable clamped",
+			policy: clamp,
+			times:  clientTimes{Timestamp: "soon"},
+			want:   now,
+			note:   "soon",
+		},
+		{
+			name:   "future rejected",
+			policy: reject,
+			times:  clientTimes{Timestamp: "2030-01-01T00:00:00Z"},
+			err:    true,
+		},
+		{
+			name:   "unbounded",
+			policy: &Timestamps{Policy: TimestampReject},
+			times:  clientTimes{Timestamp: "2030-01-01T00:00:00Z"},
+			want:   time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, note, err := resolveTimes(tt.policy, tt.times, now)
+			if tt.err {
+				if !errors.Is(err, errImplausibleTimestamp) {
+					t.Fatalf("expected errImplausibleTimestamp, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) || note != tt.note {
+				t.Errorf("got %v %q, want %v %q", got, note, tt.want, tt.note)
+			}
+		})
+	}
+}
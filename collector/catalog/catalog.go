@@ -0,0 +1,484 @@
+// Package catalog keeps a per-organization catalog of the events and
+// property keys the collector has seen, with their observed types, first
+// and last seen times and sample values. A property observed with
+// conflicting types is flagged as drifting.
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Property value types, as JSON would describe them.
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeObject = "object"
+	TypeArray  = "array"
+	TypeNull   = "null"
+)
+
+// maxSampleLength truncates long sample values.
+const maxSampleLength = 100
+
+// Entry describes an event, or one property of an event when Property is
+// set.
+type Entry struct {
+	OrganizationID string    `json:"organization_id"`
+	Event          string    `json:"event"`
+	Property       string    `json:"property,omitempty"`
+	Types          []string  `json:"types,omitempty"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Count          uint64    `json:"count"`
+	Samples        []string  `json:"samples,omitempty"`
+	// Drift is set once a property has been seen with more than one
+	// non-null type, e.g. a number that became a string.
+	Drift bool `json:"drift,omitempty"`
+}
+
+// Store persists catalog entries so the catalog survives restarts.
+type Store interface {
+	Load(ctx context.Context) ([]Entry, error)
+	Save(ctx context.Context, entries []Entry) error
+}
+
+// Config configures the catalog.
+type Config struct {
+	// QueueSize bounds events waiting to be cataloged; events arriving
+	// while it is full are skipped by the catalog only.
+	QueueSize int
+	// MaxEvents and MaxProperties cap distinct event names per
+	// organization and property keys per event.
+	MaxEvents     int
+	MaxProperties int
+	// Samples is how many distinct sample values are kept per property.
+	Samples       int
+	FlushInterval time.Duration
+	// Store is optional; without one the catalog lives in memory only.
+	Store  Store
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns sensible defaults.
+func DefaultConfig() *Config {
+	return &Config{
+		QueueSize:     10000,
+		MaxEvents:     1000,
+		MaxProperties: 500,
+		Samples:       5,
+		FlushInterval: time.Minute,
+	}
+}
+
+type key struct {
+	org, event, property string
+}
+
+type eventEntry struct {
+	Entry
+	properties map[string]*Entry
+}
+
+// Catalog records events handed to Forward. It implements the writer's
+// Forwarder interface; updates happen on a background goroutine.
+type Catalog struct {
+	config *Config
+	logger *slog.Logger
+	queue  chan *collector.RawEvent
+
+	orgs  map[string]map[string]*eventEntry
+	dirty map[key]struct{}
+	mu    sync.RWMutex
+
+	wg       sync.WaitGroup
+	closed   bool
+	closedMu sync.RWMutex
+}
+
+// New creates a catalog and starts processing events.
+func New(config *Config) *Catalog {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	defaults := DefaultConfig()
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.MaxEvents <= 0 {
+		config.MaxEvents = defaults.MaxEvents
+	}
+	if config.MaxProperties <= 0 {
+		config.MaxProperties = defaults.MaxProperties
+	}
+	if config.Samples < 0 {
+		config.Samples = 0
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+
+	c := &Catalog{
+		config: config,
+		logger: logging.Or(config.Logger).With("subsystem", "catalog"),
+		queue:  make(chan *collector.RawEvent, config.QueueSize),
+		orgs:   make(map[string]map[string]*eventEntry),
+		dirty:  make(map[key]struct{}),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Load merges previously saved entries into the catalog.
+func (c *Catalog) Load(ctx context.Context) error {
+	if c.config.Store == nil {
+		return nil
+	}
+	entries, err := c.config.Store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("load catalog: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		ev := c.event(e.OrganizationID, e.Event, true)
+		if ev == nil {
+			continue
+		}
+		target := &ev.Entry
+		if e.Property != "" {
+			if target = c.property(ev, e.Property, true); target == nil {
+				continue
+			}
+		}
+		merge(target, e, c.config.Samples)
+	}
+	return nil
+}
+
+// Forward queues the event for cataloging without blocking.
+func (c *Catalog) Forward(event *collector.RawEvent) {
+	c.closedMu.RLock()
+	defer c.closedMu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- event:
+	default:
+		metrics.CatalogDropped.Inc()
+	}
+}
+
+func (c *Catalog) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			c.observe(event)
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.FlushInterval)
+			if err := c.Flush(ctx); err != nil {
+				c.logger.Error("catalog flush failed", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// observe records one event and its properties.
+func (c *Catalog) observe(event *collector.RawEvent) {
+	if event.Event == "" {
+		return
+	}
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ev := c.event(event.OrganizationID, event.Event, false)
+	if ev == nil {
+		return
+	}
+	see(&ev.Entry, at)
+	c.dirty[key{event.OrganizationID, event.Event, ""}] = struct{}{}
+
+	for name, value := range event.Properties {
+		p := c.property(ev, name, false)
+		if p == nil {
+			continue
+		}
+		see(p, at)
+		c.addType(p, typeOf(value))
+		if sample, ok := sampleOf(value); ok {
+			addSample(p, sample, c.config.Samples)
+		}
+		c.dirty[key{event.OrganizationID, event.Event, name}] = struct{}{}
+	}
+}
+
+// event returns the entry for an event, creating it unless the
+// organization is at MaxEvents. Loaded entries are always kept.
+func (c *Catalog) event(org, name string, loading bool) *eventEntry {
+	events, ok := c.orgs[org]
+	if !ok {
+		events = make(map[string]*eventEntry)
+		c.orgs[org] = events
+	}
+	ev, ok := events[name]
+	if !ok {
+		if !loading && len(events) >= c.config.MaxEvents {
+			return nil
+		}
+		ev = &eventEntry{
+			Entry:      Entry{OrganizationID: org, Event: name},
+			properties: make(map[string]*Entry),
+		}
+		events[name] = ev
+	}
+	return ev
+}
+
+func (c *Catalog) property(ev *eventEntry, name string, loading bool) *Entry {
+	p, ok := ev.properties[name]
+	if !ok {
+		if !loading && len(ev.properties) >= c.config.MaxProperties {
+			return nil
+		}
+		p = &Entry{OrganizationID: ev.OrganizationID, Event: ev.Event, Property: name}
+		ev.properties[name] = p
+	}
+	return p
+}
+
+func see(e *Entry, at time.Time) {
+	if e.FirstSeen.IsZero() || at.Before(e.FirstSeen) {
+		e.FirstSeen = at
+	}
+	if at.After(e.LastSeen) {
+		e.LastSeen = at
+	}
+	e.Count++
+}
+
+// addType records a newly observed type and flags drift when it conflicts
+// with an earlier non-null type.
+func (c *Catalog) addType(e *Entry, t string) {
+	if slices.Contains(e.Types, t) {
+		return
+	}
+	e.Types = append(e.Types, t)
+	sort.Strings(e.Types)
+	if e.Drift || t == TypeNull || !drifted(e.Types) {
+		return
+	}
+	e.Drift = true
+	metrics.CatalogDrift.Inc()
+	c.logger.Warn("property type drift",
+		"organization_id", e.OrganizationID, "event", e.Event, "property", e.Property, "types", e.Types)
+}
+
+func drifted(types []string) bool {
+	n := 0
+	for _, t := range types {
+		if t != TypeNull {
+			n++
+		}
+	}
+	return n > 1
+}
+
+func addSample(e *Entry, sample string, max int) {
+	if max == 0 || slices.Contains(e.Samples, sample) {
+		return
+	}
+	if len(e.Samples) >= max {
+		// Keep the most recent distinct values.
+		e.Samples = e.Samples[1:]
+	}
+	e.Samples = append(e.Samples, sample)
+}
+
+// merge folds a saved entry into a live one.
+func merge(e *Entry, saved Entry, samples int) {
+	if e.FirstSeen.IsZero() || (!saved.FirstSeen.IsZero() && saved.FirstSeen.Before(e.FirstSeen)) {
+		e.FirstSeen = saved.FirstSeen
+	}
+	if saved.LastSeen.After(e.LastSeen) {
+		e.LastSeen = saved.LastSeen
+	}
+	e.Count += saved.Count
+	for _, t := range saved.Types {
+		if !slices.Contains(e.Types, t) {
+			e.Types = append(e.Types, t)
+		}
+	}
+	sort.Strings(e.Types)
+	e.Drift = e.Drift || saved.Drift || drifted(e.Types)
+	for _, s := range saved.Samples {
+		addSample(e, s, samples)
+	}
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return TypeNull
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case float64, float32, int, int64, int32, uint64, uint32:
+		return TypeNumber
+	}
+	return TypeString
+}
+
+// sampleOf formats scalar values as samples; objects, arrays and nulls
+// have none.
+func sampleOf(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	case string:
+		if len(v) > maxSampleLength {
+			v = v[:maxSampleLength]
+		}
+		return v, true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// Events lists an organization's events by name.
+func (c *Catalog) Events(org string) []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	events := c.orgs[org]
+	out := make([]Entry, 0, len(events))
+	for _, ev := range events {
+		out = append(out, clone(ev.Entry))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Event < out[j].Event })
+	return out
+}
+
+// Properties lists the properties of an organization's event by key. It
+// reports false when the event has not been seen.
+func (c *Catalog) Properties(org, event string) ([]Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ev, ok := c.orgs[org][event]
+	if !ok {
+		return nil, false
+	}
+	out := make([]Entry, 0, len(ev.properties))
+	for _, p := range ev.properties {
+		out = append(out, clone(*p))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Property < out[j].Property })
+	return out, true
+}
+
+// Drift lists an organization's properties that changed type, by event
+// and key.
+func (c *Catalog) Drift(org string) []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []Entry
+	for _, ev := range c.orgs[org] {
+		for _, p := range ev.properties {
+			if p.Drift {
+				out = append(out, clone(*p))
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Event != out[j].Event {
+			return out[i].Event < out[j].Event
+		}
+		return out[i].Property < out[j].Property
+	})
+	return out
+}
+
+func clone(e Entry) Entry {
+	e.Types = slices.Clone(e.Types)
+	e.Samples = slices.Clone(e.Samples)
+	return e
+}
+
+// Flush saves entries changed since the last flush. Entries that fail to
+// save are retried on the next flush.
+func (c *Catalog) Flush(ctx context.Context) error {
+	if c.config.Store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if len(c.dirty) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	dirty := c.dirty
+	c.dirty = make(map[key]struct{})
+	entries := make([]Entry, 0, len(dirty))
+	for k := range dirty {
+		ev := c.orgs[k.org][k.event]
+		if k.property == "" {
+			entries = append(entries, clone(ev.Entry))
+		} else {
+			entries = append(entries, clone(*ev.properties[k.property]))
+		}
+	}
+	c.mu.Unlock()
+
+	if err := c.config.Store.Save(ctx, entries); err != nil {
+		c.mu.Lock()
+		for k := range dirty {
+			c.dirty[k] = struct{}{}
+		}
+		c.mu.Unlock()
+		return fmt.Errorf("save %d catalog entries: %w", len(entries), err)
+	}
+	return nil
+}
+
+// Close stops cataloging and saves pending changes.
+func (c *Catalog) Close() error {
+	c.closedMu.Lock()
+	if c.closed {
+		c.closedMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.queue)
+	c.closedMu.Unlock()
+
+	// Drain what was already queued before the final flush.
+	c.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.Flush(ctx)
+}
@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+type memStore struct {
+	mu      sync.Mutex
+	saved   []Entry
+	loaded  []Entry
+	failing bool
+}
+
+func (s *memStore) Load(context.Context) ([]Entry, error) { return s.loaded, nil }
+
+func (s *memStore) Save(_ context.Context, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("boom")
+	}
+	s.saved = append(s.saved, entries...)
+	return nil
+}
+
+// newTestCatalog returns a catalog whose queue is drained by Close.
+func newTestCatalog(config *Config) *Catalog {
+	config.FlushInterval = time.Hour
+	return New(config)
+}
+
+func TestCatalog_DiscoversTypesAndDrift(t *testing.T) {
+	c := newTestCatalog(&Config{Samples: 2})
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, props := range []map[string]interface{}{
+		{"plan": "pro", "seats": float64(3), "beta": nil},
+		{"plan": "team", "seats": float64(5), "beta": true},
+		{"plan": "free", "seats": "7"},
+	} {
+		c.Forward(&collector.RawEvent{Event: "signup", OrganizationID: "org-1", Timestamp: t0.Add(time.Duration(i) * time.Hour), Properties: props})
+	}
+	c.Forward(&collector.RawEvent{Event: "signup", OrganizationID: "org-2", Properties: map[string]interface{}{"x": 1}})
+	c.Close()
+
+	events := c.Events("org-1")
+	if len(events) != 1 || events[0].Count != 3 || !events[0].FirstSeen.Equal(t0) || !events[0].LastSeen.Equal(t0.Add(2*time.Hour)) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	props, ok := c.Properties("org-1", "signup")
+	if !ok || len(props) != 3 {
+		t.Fatalf("unexpected properties %+v", props)
+	}
+	byKey := map[string]Entry{}
+	for _, p := range props {
+		byKey[p.Property] = p
+	}
+	if p := byKey["plan"]; !reflect.DeepEqual(p.Types, []string{TypeString}) || p.Drift || !reflect.DeepEqual(p.Samples, []string{"team", "free"}) {
+		t.Errorf("unexpected plan entry %+v", p)
+	}
+	if p := byKey["seats"]; !reflect.DeepEqual(p.Types, []string{TypeNumber, TypeString}) || !p.Drift {
+		t.Errorf("expected seats to drift from number to string, got %+v", p)
+	}
+	if p := byKey["beta"]; p.Drift {
+		t.Errorf("a null value is not drift: %+v", p)
+	}
+
+	drift := c.Drift("org-1")
+	if len(drift) != 1 || drift[0].Property != "seats" {
+		t.Errorf("unexpected drift %+v", drift)
+	}
+	if _, ok := c.Properties("org-2", "missing"); ok {
+		t.Error("expected an unknown event to be reported")
+	}
+}
+
+func TestCatalog_Limits(t *testing.T) {
+	c := newTestCatalog(&Config{MaxEvents: 2, MaxProperties: 1})
+	for _, name := range []string{"a", "b", "c"} {
+		c.Forward(&collector.RawEvent{Event: name, OrganizationID: "org-1", Properties: map[string]interface{}{"k1": 1, "k2": 2}})
+	}
+	c.Close()
+
+	if got := len(c.Events("org-1")); got != 2 {
+		t.Errorf("expected events capped at 2, got %d", got)
+	}
+	if props, _ := c.Properties("org-1", "a"); len(props) != 1 {
+		t.Errorf("expected properties capped at 1, got %+v", props)
+	}
+}
+
+func TestCatalog_FlushAndLoad(t *testing.T) {
+	store := &memStore{failing: true}
+	c := New(&Config{Store: store, FlushInterval: time.Hour})
+	c.Forward(&collector.RawEvent{Event: "a", OrganizationID: "org-1", Properties: map[string]interface{}{"k": "v"}})
+
+	// A failed save keeps the entries for the next flush.
+	deadline := time.Now().Add(time.Second)
+	for len(c.Events("org-1")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Flush(context.Background()); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	store.failing = false
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 2 {
+		t.Fatalf("expected the event and property saved, got %+v", store.saved)
+	}
+
+	// A new catalog resumes from the saved entries.
+	store.loaded = store.saved
+	next := newTestCatalog(&Config{Store: store})
+	if err := next.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	next.Forward(&collector.RawEvent{Event: "a", OrganizationID: "org-1", Properties: map[string]interface{}{"k": 1}})
+	next.Close()
+	props, _ := next.Properties("org-1", "a")
+	if len(props) != 1 || props[0].Count != 2 || !props[0].Drift {
+		t.Errorf("expected the loaded entry to be extended, got %+v", props)
+	}
+}
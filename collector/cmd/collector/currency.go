@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/hanzoai/analytics/collector/config"
+	"github.com/hanzoai/analytics/collector/currency"
+)
+
+// buildCurrency creates the revenue normalizer, loading the exchange rates
+// file when one is configured.
+func buildCurrency(cfg *config.Config) (*currency.Normalizer, error) {
+	n := &currency.Normalizer{
+		Base:          cfg.Currency.Base,
+		Organizations: cfg.Currency.Organizations,
+	}
+	if cfg.Currency.RatesFile != "" {
+		rates, err := currency.Load(cfg.Currency.RatesFile, cfg.Currency.Reference)
+		if err != nil {
+			return nil, err
+		}
+		n.Rates = rates
+	}
+	return n, nil
+}
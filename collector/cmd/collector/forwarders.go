@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/config"
+	"github.com/hanzoai/analytics/collector/forward"
+	"github.com/hanzoai/analytics/collector/writer"
+)
+
+// runningForwarder is a forwarder and the config entry it was built from.
+type runningForwarder struct {
+	config    config.ForwarderConfig
+	forwarder writer.Forwarder
+}
+
+// buildForwarders creates a forwarder for each enabled entry in the config.
+// An entry identical to one in running keeps that forwarder, so its buffer,
+// open files and connections survive a reload.
+func buildForwarders(cfg *config.Config, running []runningForwarder, logger *slog.Logger) ([]runningForwarder, error) {
+	var forwarders, built []runningForwarder
+	for _, fc := range cfg.Enabled() {
+		if i := slices.IndexFunc(running, func(r runningForwarder) bool {
+			return reflect.DeepEqual(r.config, fc)
+		}); i >= 0 {
+			forwarders = append(forwarders, running[i])
+			continue
+		}
+		f, err := buildBufferedForwarder(fc, logger)
+		if err != nil {
+			closeForwarders(forwarderList(built))
+			return nil, fmt.Errorf("forwarder %q: %w", fc.Name, err)
+		}
+		logger.Info("forwarding enabled", "forwarder", fc.Name, "type", fc.Type, "endpoint", fc.Endpoint)
+		built = append(built, runningForwarder{config: fc, forwarder: f})
+		forwarders = append(forwarders, built[len(built)-1])
+	}
+	return forwarders, nil
+}
+
+func buildBufferedForwarder(fc config.ForwarderConfig, logger *slog.Logger) (writer.Forwarder, error) {
+	f, err := buildForwarder(fc, logger)
+	if err != nil {
+		return nil, err
+	}
+	// Forward only enqueues; the buffer's workers call the forwarder.
+	buffered, err := writer.NewBufferedForwarder(fc.Name, f, fc.Buffer, logger)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Routing runs before the buffer, so only selected events use its
+	// capacity.
+	if !fc.Routing.IsZero() {
+		return writer.NewRoutedForwarder(buffered, &fc.Routing), nil
+	}
+	return buffered, nil
+}
+
+// forwarderList returns the forwarders of running, in order.
+func forwarderList(running []runningForwarder) []writer.Forwarder {
+	forwarders := make([]writer.Forwarder, len(running))
+	for i, r := range running {
+		forwarders[i] = r.forwarder
+	}
+	return forwarders
+}
+
+func buildForwarder(fc config.ForwarderConfig, logger *slog.Logger) (writer.Forwarder, error) {
+	switch fc.Type {
+	case config.ForwarderInsights:
+		return writer.NewInsightsForwarder(&forward.InsightsConfig{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			APIKey:        fc.APIKey,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}), nil
+	case config.ForwarderDatastoreAPI:
+		return writer.NewDatastoreAPIForwarder(&forward.DatastoreConfig{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			APIKey:        fc.APIKey,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}), nil
+	case config.ForwarderAnalytics:
+		return writer.NewAnalyticsForwarder(&forward.ForwardConfig{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			WebsiteID:     fc.WebsiteID,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}), nil
+	case config.ForwarderWebhook:
+		return writer.NewWebhookForwarder(&forward.WebhookConfig{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			Secret:        fc.Secret,
+			Format:        fc.Format,
+			Headers:       fc.Headers,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}), nil
+	case config.ForwarderKafka:
+		topics := make([]forward.KafkaTopicRoute, len(fc.Topics))
+		for i, t := range fc.Topics {
+			topics[i] = forward.KafkaTopicRoute{Events: t.Events, Topic: t.Topic}
+		}
+		f, err := writer.NewKafkaForwarder(&forward.KafkaConfig{
+			Name:                  fc.Name,
+			Brokers:               fc.Brokers,
+			Topic:                 fc.Topic,
+			Topics:                topics,
+			Acks:                  fc.Acks,
+			Compression:           fc.Compression,
+			SASLMechanism:         fc.SASL.Mechanism,
+			Username:              fc.SASL.Username,
+			Password:              fc.SASL.Password,
+			TLS:                   fc.TLS.Enabled,
+			TLSCAFile:             fc.TLS.CAFile,
+			TLSInsecureSkipVerify: fc.TLS.InsecureSkipVerify,
+			BatchSize:             fc.BatchSize,
+			FlushInterval:         time.Duration(fc.FlushInterval),
+			Timeout:               time.Duration(fc.Timeout),
+			Logger:                logger,
+		}, fc.PartitionKey)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case config.ForwarderArchive:
+		var store forward.ObjectStore = &forward.LocalStore{Dir: fc.Storage.Dir}
+		if fc.Storage.Bucket != "" {
+			s3, err := forward.NewS3Store(&forward.S3Config{
+				Endpoint:  fc.Storage.Endpoint,
+				Bucket:    fc.Storage.Bucket,
+				Region:    fc.Storage.Region,
+				AccessKey: fc.Storage.AccessKey,
+				SecretKey: fc.Storage.SecretKey,
+				Insecure:  fc.Storage.Insecure,
+				PathStyle: fc.Storage.PathStyle,
+			})
+			if err != nil {
+				return nil, err
+			}
+			store = s3
+		}
+		return writer.NewArchiveForwarder(&forward.ArchiveConfig{
+			Name:           fc.Name,
+			Store:          store,
+			Prefix:         fc.Storage.Prefix,
+			Format:         fc.Format,
+			MaxFileSize:    fc.MaxFileSize,
+			MaxFileAge:     time.Duration(fc.MaxFileAge),
+			MaxPendingSize: fc.MaxPendingSize,
+			FlushInterval:  time.Duration(fc.FlushInterval),
+			Timeout:        time.Duration(fc.Timeout),
+			Logger:         logger,
+		}), nil
+	case config.ForwarderFile:
+		f, err := writer.NewFileForwarder(&forward.FileConfig{
+			Name:          fc.Name,
+			Path:          fc.Path,
+			MaxSize:       fc.MaxFileSize,
+			MaxAge:        time.Duration(fc.MaxFileAge),
+			MaxFiles:      fc.MaxFiles,
+			Compress:      fc.Compress,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Logger:        logger,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case config.ForwarderGA4:
+		return writer.NewGA4Forwarder(&forward.GA4Config{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			Debug:         fc.Debug,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}, fc.AccountSet()), nil
+	case config.ForwarderMeta:
+		return writer.NewMetaForwarder(&forward.MetaConfig{
+			Name:          fc.Name,
+			Endpoint:      fc.Endpoint,
+			TestEventCode: fc.TestEventCode,
+			BatchSize:     fc.BatchSize,
+			FlushInterval: time.Duration(fc.FlushInterval),
+			Timeout:       time.Duration(fc.Timeout),
+			Logger:        logger,
+		}, fc.AccountSet()), nil
+	case config.ForwarderGoogleAds:
+		f, err := writer.NewGoogleAdsForwarder(&forward.GoogleAdsConfig{
+			Name:            fc.Name,
+			Endpoint:        fc.Endpoint,
+			DeveloperToken:  fc.GoogleAds.DeveloperToken,
+			ClientID:        fc.GoogleAds.ClientID,
+			ClientSecret:    fc.GoogleAds.ClientSecret,
+			RefreshToken:    fc.GoogleAds.RefreshToken,
+			LoginCustomerID: fc.GoogleAds.LoginCustomerID,
+			BatchSize:       fc.BatchSize,
+			FlushInterval:   time.Duration(fc.FlushInterval),
+			Timeout:         time.Duration(fc.Timeout),
+			Logger:          logger,
+		}, fc.AccountSet(), fc.GoogleAds.ConversionActions, &forward.FileConfig{
+			Name:   fc.Name + "_results",
+			Path:   fc.GoogleAds.ResultsPath,
+			Logger: logger,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", fc.Type)
+	}
+}
+
+func closeForwarders(forwarders []writer.Forwarder) {
+	for _, f := range forwarders {
+		f.Close()
+	}
+}
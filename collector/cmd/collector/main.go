@@ -2,131 +2,240 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/hanzoai/analytics/collector/api"
-	"github.com/hanzoai/analytics/collector/forward"
+	"github.com/hanzoai/analytics/collector/catalog"
+	"github.com/hanzoai/analytics/collector/config"
+	"github.com/hanzoai/analytics/collector/live"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/metrics"
+	"github.com/hanzoai/analytics/collector/tracing"
 	"github.com/hanzoai/analytics/collector/writer"
 )
 
 func main() {
-	addr := getEnv("COLLECTOR_ADDR", ":8091")
-	dsn := getEnv("DATASTORE_URL", os.Getenv("DATASTORE_DSN"))
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", os.Getenv("COLLECTOR_CONFIG"), "path to a YAML or TOML config file")
+	flag.Parse()
 
-	if dsn == "" {
-		fmt.Fprintln(os.Stderr, "DATASTORE_URL or DATASTORE_DSN required")
-		os.Exit(1)
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatal(logging.New(nil), "invalid configuration", "error", err)
 	}
 
-	// Build forwarders from environment configuration.
-	var forwarders []writer.Forwarder
-
-	// Insights forwarder (behavioral analytics / Insights-compatible).
-	if endpoint := getEnv("INSIGHTS_HOST", os.Getenv("INSIGHTS_ENDPOINT")); endpoint != "" {
-		apiKey := getEnv("INSIGHTS_API_KEY", os.Getenv("INSIGHTS_KEY"))
-		if apiKey != "" {
-			fmt.Printf("Insights forwarding enabled: %s\n", endpoint)
-			forwarders = append(forwarders, writer.NewInsightsForwarder(&forward.InsightsConfig{
-				Endpoint: endpoint,
-				APIKey:   apiKey,
-			}))
-		}
+	level := new(slog.LevelVar)
+	logger := logging.New(&logging.Config{
+		Level:         cfg.Logging.Level,
+		LevelVar:      level,
+		Format:        cfg.Logging.Format,
+		Output:        os.Stdout,
+		ErrorBurst:    10,
+		ErrorInterval: time.Minute,
+	})
+	slog.SetDefault(logger)
+
+	// Tracing: OTLP/HTTP export and/or a local span file.
+	shutdownTracing, err := tracing.Setup(context.Background(), &tracing.Config{
+		ServiceName: cfg.Tracing.ServiceName,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal(logger, "tracing setup failed", "error", err)
 	}
 
-	// Datastore REST API forwarder (Hanzo datastore service).
-	if endpoint := getEnv("DATASTORE_API_URL", os.Getenv("DATASTORE_API_ENDPOINT")); endpoint != "" {
-		apiKey := getEnv("DATASTORE_API_KEY", "")
-		fmt.Printf("Datastore API forwarding enabled: %s\n", endpoint)
-		forwarders = append(forwarders, writer.NewDatastoreAPIForwarder(&forward.DatastoreConfig{
-			Endpoint: endpoint,
-			APIKey:   apiKey,
-		}))
+	// Refuse to run against a schema this binary does not match.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if err := checkSchema(ctx, cfg, logger); err != nil {
+		fatal(logger, "incompatible datastore schema", "error", err)
 	}
+	cancel()
 
-	// Analytics backend forwarder (Umami-compatible).
-	if endpoint := getEnv("ANALYTICS_FORWARD_URL", os.Getenv("ANALYTICS_ENDPOINT")); endpoint != "" {
-		websiteID := getEnv("ANALYTICS_WEBSITE_ID", "")
-		fmt.Printf("Analytics forwarding enabled: %s\n", endpoint)
-		forwarders = append(forwarders, writer.NewAnalyticsForwarder(&forward.ForwardConfig{
-			Endpoint:  endpoint,
-			WebsiteID: websiteID,
-		}))
+	running, err := buildForwarders(cfg, nil, logger)
+	if err != nil {
+		fatal(logger, "invalid forwarder", "error", err)
+	}
+	forwarders := forwarderList(running)
+
+	normalizer, err := buildCurrency(cfg)
+	if err != nil {
+		fatal(logger, "invalid exchange rates", "error", err)
 	}
 
+	// Live event stream; the hub receives every accepted event like a forwarder.
+	hub := live.NewHub(cfg.Live.BufferSize)
+
 	// Initialize datastore writer with forwarders.
 	w, err := writer.New(&writer.Config{
-		DSN:           dsn,
-		BatchSize:     500,
-		FlushInterval: 5 * time.Second,
-		AsyncInsert:   true,
-		BufferSize:    10000,
-		Forwarders:    forwarders,
+		DSN:           cfg.Datastore.DSN,
+		BatchSize:     cfg.Writer.BatchSize,
+		FlushInterval: time.Duration(cfg.Writer.FlushInterval),
+		AsyncInsert:   cfg.Writer.AsyncInsert,
+		BufferSize:    cfg.Writer.BufferSize,
+		HighWatermark: cfg.Writer.HighWatermark,
+		LowWatermark:  cfg.Writer.LowWatermark,
+
+		MaxConcurrentInserts: cfg.Writer.MaxConcurrentInserts,
+		TargetInsertLatency:  time.Duration(cfg.Writer.TargetInsertLatency),
+		DedupWindow:          time.Duration(cfg.Writer.DedupWindow),
+		DedupSize:            cfg.Writer.DedupSize,
+		Names:                cfg.Datastore.Names(),
+		Tenants:              cfg.Datastore.Tenants,
+		Currency:             normalizer,
+		Conversions:          cfg.Conversions(),
+		Forwarders:           append(forwarders, hub),
+		Logger:               logger,
 	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Datastore: %v\n", err)
-		os.Exit(1)
+		fatal(logger, "datastore connection failed", "error", err)
 	}
 
-	// Ensure schema
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	if err := w.EnsureSchema(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: schema: %v\n", err)
+	// Event catalog; like the hub it receives every accepted event.
+	builtin := []writer.Forwarder{hub}
+	var cat *catalog.Catalog
+	if cfg.Catalog.Enabled {
+		cat = catalog.New(&catalog.Config{
+			MaxEvents:     cfg.Catalog.MaxEvents,
+			MaxProperties: cfg.Catalog.MaxProperties,
+			Samples:       cfg.Catalog.Samples,
+			FlushInterval: time.Duration(cfg.Catalog.FlushInterval),
+			Store:         w.CatalogStore(),
+			Logger:        logger,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := cat.Load(ctx); err != nil {
+			logger.Warn("catalog load failed, starting empty", "error", err)
+		}
+		cancel()
+		builtin = append(builtin, cat)
+		w.SetForwarders(append(forwarders, builtin...))
 	}
-	cancel()
+
+	metrics.RegisterQueueDepth(func() float64 { return float64(w.QueueDepth()) })
+
+	// Analytics handler
+	handler := api.NewHandler(w, logger)
+	handler.SetSettings(handlerSettings(cfg))
+
+	rl := &reloader{
+		path:    *configPath,
+		logger:  logger,
+		writer:  w,
+		handler: handler,
+		level:   level,
+		builtin: builtin,
+		current: cfg,
+		running: running,
+	}
+	rl.forwarders.Store(int32(len(forwarders)))
 
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	r.Use(gin.Recovery())
+	r.Use(gin.Recovery(), metrics.Middleware(), tracing.Middleware())
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "forwarders": len(forwarders)})
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "forwarders": rl.forwarders.Load()})
 	})
 
+	// Readiness: load balancers should route away while the writer refuses
+	// events.
+	r.GET("/ready", func(c *gin.Context) {
+		if ready, reason := w.Ready(); !ready {
+			c.Header("Retry-After", strconv.Itoa(int(w.RetryAfter().Seconds())))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "reason": reason})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// Analytics endpoints
-	handler := api.NewHandler(w)
 	handler.Route(r.Group("/"))
 	handler.Route(r.Group("/v1/analytics"))
 
+	// Live tail, authenticated by per-organization API keys.
+	liveHandler := api.NewLiveHandler(hub)
+	liveAuth := api.KeyAuth(cfg.Live.APIKeys)
+	liveHandler.Route(r.Group("/", liveAuth))
+	liveHandler.Route(r.Group("/v1/analytics", liveAuth))
+
+	// Event catalog, authenticated like the live tail.
+	if cat != nil {
+		catalogHandler := api.NewCatalogHandler(cat)
+		catalogHandler.Route(r.Group("/", liveAuth))
+		catalogHandler.Route(r.Group("/v1/analytics", liveAuth))
+	}
+
 	// Start server
 	srv := &http.Server{
-		Addr:         addr,
+		Addr:         cfg.Listen.Addr,
 		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		ReadTimeout:  time.Duration(cfg.Listen.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Listen.WriteTimeout),
 	}
 
 	go func() {
-		fmt.Printf("analytics-collector starting on %s\n", addr)
+		logger.Info("analytics-collector starting", "addr", cfg.Listen.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Fprintf(os.Stderr, "server: %v\n", err)
-			os.Exit(1)
+			fatal(logger, "server failed", "error", err)
 		}
 	}()
 
+	// Hot reload on SIGHUP or when the config file changes.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if *configPath != "" {
+		go config.Watch(watchCtx, *configPath, 2*time.Second, rl.reload)
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		rl.reload()
+	}
 
-	fmt.Println("Shutting down...")
+	logger.Info("shutting down")
+	stopWatch()
+	rl.stop()
 	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
 	w.Close()
+	shutdownTracing(ctx)
 }
 
-func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// loadConfig reads the config file when given, otherwise the environment.
+func loadConfig(path string) (*config.Config, error) {
+	if path != "" {
+		return config.Load(path)
 	}
-	return fallback
+	return config.FromEnv()
+}
+
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/config"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/migrate"
+)
+
+const migrateUsage = `usage: collector migrate [-config path] up|status
+
+  up      apply pending schema migrations
+  status  list migrations and whether they are applied
+`
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("COLLECTOR_CONFIG"), "path to a YAML or TOML config file")
+	fs.Usage = func() { fmt.Fprint(fs.Output(), migrateUsage) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "up" && fs.Arg(0) != "status") {
+		fs.Usage()
+		return 2
+	}
+
+	logger := logging.New(nil)
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if fs.Arg(0) == "status" {
+		fmt.Fprintln(tw, "DATABASE\tVERSION\tNAME\tSTATUS\tAPPLIED AT")
+	}
+	code := 0
+	for _, names := range cfg.Datastore.Schemas() {
+		if err := migrateSchema(ctx, cfg, names, fs.Arg(0), tw, logger); err != nil {
+			logger.Error("migrate "+fs.Arg(0)+" failed", "database", names.Database, "error", err)
+			code = 1
+		}
+	}
+	tw.Flush()
+	return code
+}
+
+// migrateSchema runs one migrate command against one database.
+func migrateSchema(ctx context.Context, cfg *config.Config, names migrate.Names, cmd string, tw *tabwriter.Writer, logger *slog.Logger) error {
+	m, err := migrate.Open(cfg.Datastore.DSN, names, logger)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if cmd == "up" {
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		promoted, err := m.Promote(ctx, cfg.Datastore.Promotions(names.Database))
+		if err != nil {
+			return err
+		}
+		logger.Info("schema up to date", "database", names.Database, "applied", len(applied), "version", migrate.Latest(), "promoted", promoted)
+		return nil
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		state, at := "pending", ""
+		switch {
+		case s.Version > migrate.Latest():
+			state = "unknown (newer collector)"
+		case s.Modified:
+			state = "modified"
+		case s.Applied:
+			state = "applied"
+		}
+		if s.Applied {
+			at = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%02d\t%s\t%s\t%s\n", names.Database, s.Version, s.Name, state, at)
+	}
+	return nil
+}
+
+// checkSchema applies pending migrations and property promotions when
+// auto_migrate is on, then verifies every database's schema matches this
+// binary.
+func checkSchema(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	for _, names := range cfg.Datastore.Schemas() {
+		m, err := migrate.Open(cfg.Datastore.DSN, names, logger)
+		if err != nil {
+			return err
+		}
+		if cfg.Datastore.AutoMigrate {
+			if _, err = m.Up(ctx); err == nil {
+				_, err = m.Promote(ctx, cfg.Datastore.Promotions(names.Database))
+			}
+		}
+		if err == nil {
+			err = m.Check(ctx)
+		}
+		m.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", names.Database, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/api"
+	"github.com/hanzoai/analytics/collector/config"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/writer"
+)
+
+// reloader applies a changed config file to the running collector.
+// Only forwarders whose config changed are rebuilt; they are swapped before
+// the old ones are closed, so events queued in them are flushed rather than
+// dropped.
+type reloader struct {
+	path    string
+	logger  *slog.Logger
+	writer  *writer.Writer
+	handler *api.Handler
+	level   *slog.LevelVar
+	// builtin forwarders, such as the live hub, outlive reloads.
+	builtin []writer.Forwarder
+
+	current *config.Config
+	// running are the configured forwarders and the entries they came from.
+	running    []runningForwarder
+	forwarders atomic.Int32
+	stopped    bool
+	mu         sync.Mutex
+}
+
+// reload re-reads the config file. An invalid file is logged and ignored.
+func (r *reloader) reload() {
+	if r.path == "" {
+		r.logger.Warn("reload requested but no config file is in use")
+		return
+	}
+	next, err := config.Load(r.path)
+	if err != nil {
+		r.logger.Error("config reload failed", "error", err)
+		return
+	}
+	r.apply(next)
+}
+
+func (r *reloader) apply(next *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	normalizer, err := buildCurrency(next)
+	if err != nil {
+		r.logger.Error("config reload failed", "error", err)
+		return
+	}
+	running, err := buildForwarders(next, r.running, r.logger)
+	if err != nil {
+		r.logger.Error("config reload failed", "error", err)
+		return
+	}
+
+	forwarders := forwarderList(running)
+	old := r.writer.SetForwarders(append(forwarders, r.builtin...))
+	for _, f := range old {
+		if !slices.Contains(r.builtin, f) && !slices.Contains(forwarders, f) {
+			f.Close()
+		}
+	}
+	r.running = running
+	r.forwarders.Store(int32(len(forwarders)))
+
+	r.writer.SetTuning(writer.Tuning{
+		BatchSize:     next.Writer.BatchSize,
+		FlushInterval: time.Duration(next.Writer.FlushInterval),
+	})
+	r.writer.SetCurrency(normalizer)
+	r.writer.SetConversions(next.Conversions())
+	r.handler.SetSettings(handlerSettings(next))
+	r.level.Set(logging.ParseLevel(next.Logging.Level))
+
+	if keys := r.current.RestartRequired(next); len(keys) > 0 {
+		r.logger.Warn("config changes need a restart to take effect", "settings", keys)
+	}
+	r.current = next
+	r.logger.Info("config reloaded", "forwarders", len(forwarders))
+}
+
+// stop prevents further reloads; call it before closing the writer.
+func (r *reloader) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
+
+func handlerSettings(cfg *config.Config) *api.Settings {
+	return &api.Settings{
+		Enrichment: api.Enrichment{
+			UserAgent: cfg.Enrichment.UserAgent,
+			UTM:       cfg.Enrichment.UTM,
+			ClickIDs:  cfg.Enrichment.ClickIDs,
+			Referrer:  cfg.Enrichment.Referrer,
+		},
+		Privacy: api.Privacy{
+			AnonymizeIP:      cfg.Privacy.AnonymizeIP,
+			DropIP:           cfg.Privacy.DropIP,
+			DropUserAgent:    cfg.Privacy.DropUserAgent,
+			StripQueryParams: cfg.Privacy.StripQueryParams,
+		},
+		Timestamps: api.Timestamps{
+			MaxFuture: time.Duration(cfg.Timestamps.MaxFuture),
+			MaxPast:   time.Duration(cfg.Timestamps.MaxPast),
+			Policy:    cfg.Timestamps.Policy,
+		},
+	}
+}
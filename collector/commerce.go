@@ -0,0 +1,42 @@
+package collector
+
+// Product is one line of an order or cart.
+type Product struct {
+	ProductID string  `json:"product_id,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Brand     string  `json:"brand,omitempty"`
+	Category  string  `json:"category,omitempty"`
+	Variant   string  `json:"variant,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Quantity  int     `json:"quantity,omitempty"`
+	// Discount is taken off the whole line, not each unit.
+	Discount float64 `json:"discount,omitempty"`
+	Coupon   string  `json:"coupon,omitempty"`
+
+	// BaseRevenue is Revenue in the organization's base currency, set by
+	// the writer.
+	BaseRevenue float64 `json:"base_revenue,omitempty"`
+}
+
+// Units returns the quantity, counting a line without one as a single unit.
+func (p *Product) Units() int {
+	if p.Quantity <= 0 {
+		return 1
+	}
+	return p.Quantity
+}
+
+// Revenue returns the line total: price times units, less the discount.
+func (p *Product) Revenue() float64 {
+	return p.Price*float64(p.Units()) - p.Discount
+}
+
+// Subtotal returns the sum of the line totals.
+func Subtotal(products []Product) float64 {
+	var total float64
+	for i := range products {
+		total += products[i].Revenue()
+	}
+	return total
+}
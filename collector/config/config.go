@@ -0,0 +1,849 @@
+// Package config loads and validates the collector configuration file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/hanzoai/analytics/collector/currency"
+	"github.com/hanzoai/analytics/collector/migrate"
+	"github.com/hanzoai/analytics/collector/writer"
+)
+
+// Duration is a time.Duration that decodes from strings such as "5s".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config is the complete collector configuration.
+type Config struct {
+	Listen     ListenConfig      `yaml:"listen" toml:"listen"`
+	Datastore  DatastoreConfig   `yaml:"datastore" toml:"datastore"`
+	Writer     WriterConfig      `yaml:"writer" toml:"writer"`
+	Forwarders []ForwarderConfig `yaml:"forwarders" toml:"forwarders"`
+	Enrichment EnrichmentConfig  `yaml:"enrichment" toml:"enrichment"`
+	Privacy    PrivacyConfig     `yaml:"privacy" toml:"privacy"`
+	Timestamps TimestampsConfig  `yaml:"timestamps" toml:"timestamps"`
+	Logging    LoggingConfig     `yaml:"logging" toml:"logging"`
+	Tracing    TracingConfig     `yaml:"tracing" toml:"tracing"`
+	Live       LiveConfig        `yaml:"live" toml:"live"`
+	Catalog    CatalogConfig     `yaml:"catalog" toml:"catalog"`
+	Currency   CurrencyConfig    `yaml:"currency" toml:"currency"`
+}
+
+// ListenConfig configures the HTTP listener. Changes require a restart.
+type ListenConfig struct {
+	Addr         string   `yaml:"addr" toml:"addr"`
+	ReadTimeout  Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout"`
+}
+
+// DatastoreConfig configures the datastore connection. Changes require a restart.
+type DatastoreConfig struct {
+	DSN string `yaml:"dsn" toml:"dsn"`
+	// AutoMigrate applies pending schema migrations at startup. When off,
+	// the collector refuses to start until `collector migrate up` has run.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+	// Database and Tables name where events are written.
+	Database string       `yaml:"database" toml:"database"`
+	Tables   TablesConfig `yaml:"tables" toml:"tables"`
+	// Tenants maps organization IDs to a database of their own, created
+	// with the same tables, for customers that require physical separation.
+	Tenants map[string]string `yaml:"tenants" toml:"tenants"`
+	// Promote materializes hot property keys as typed columns of the events
+	// table, backfilled for existing rows.
+	Promote []PromotedProperty `yaml:"promote" toml:"promote"`
+}
+
+// PromotedProperty is a property key promoted to its own column. The
+// column is added in the databases of Organizations, or in every database
+// when none are listed.
+type PromotedProperty struct {
+	Key string `yaml:"key" toml:"key"`
+	// Type is string (default), number or bool.
+	Type string `yaml:"type" toml:"type"`
+	// Source is properties (default), person_properties or group_properties.
+	Source        string   `yaml:"source" toml:"source"`
+	Organizations []string `yaml:"organizations" toml:"organizations"`
+}
+
+func (p PromotedProperty) promotion() migrate.Promotion {
+	return migrate.Promotion{Key: p.Key, Type: p.Type, Source: p.Source}
+}
+
+// TablesConfig names the collector's tables within a database.
+type TablesConfig struct {
+	Events       string `yaml:"events" toml:"events"`
+	EventsHourly string `yaml:"events_hourly" toml:"events_hourly"`
+	Persons      string `yaml:"persons" toml:"persons"`
+	Sessions     string `yaml:"sessions" toml:"sessions"`
+	Groups       string `yaml:"groups" toml:"groups"`
+	Catalog      string `yaml:"catalog" toml:"catalog"`
+
+	OrderItems     string `yaml:"order_items" toml:"order_items"`
+	ProductRevenue string `yaml:"product_revenue" toml:"product_revenue"`
+	OrderLedger    string `yaml:"order_ledger" toml:"order_ledger"`
+	Orders         string `yaml:"orders" toml:"orders"`
+}
+
+// Names returns the schema names of the default database.
+func (c *DatastoreConfig) Names() migrate.Names {
+	return migrate.Names{
+		Database:     c.Database,
+		Events:       c.Tables.Events,
+		EventsHourly: c.Tables.EventsHourly,
+		Persons:      c.Tables.Persons,
+		Sessions:     c.Tables.Sessions,
+		Groups:       c.Tables.Groups,
+		Catalog:      c.Tables.Catalog,
+
+		OrderItems:     c.Tables.OrderItems,
+		ProductRevenue: c.Tables.ProductRevenue,
+		OrderLedger:    c.Tables.OrderLedger,
+		Orders:         c.Tables.Orders,
+	}.WithDefaults()
+}
+
+// Promotions returns the properties to promote in database.
+func (c *DatastoreConfig) Promotions(database string) []migrate.Promotion {
+	var out []migrate.Promotion
+	for _, p := range c.Promote {
+		if len(p.Organizations) == 0 || slices.ContainsFunc(p.Organizations, func(org string) bool {
+			if tenant, ok := c.Tenants[org]; ok {
+				return tenant == database
+			}
+			return c.Names().Database == database
+		}) {
+			out = append(out, p.promotion())
+		}
+	}
+	return out
+}
+
+// Schemas returns the schema names of every database the collector writes
+// to: the default one, then each tenant database in name order.
+func (c *DatastoreConfig) Schemas() []migrate.Names {
+	names := c.Names()
+	out := []migrate.Names{names}
+	seen := map[string]bool{names.Database: true}
+	var tenants []string
+	for _, database := range c.Tenants {
+		if !seen[database] {
+			seen[database] = true
+			tenants = append(tenants, database)
+		}
+	}
+	sort.Strings(tenants)
+	for _, database := range tenants {
+		out = append(out, names.InDatabase(database))
+	}
+	return out
+}
+
+// WriterConfig tunes datastore batching and admission control. Only
+// BatchSize and FlushInterval change without a restart.
+type WriterConfig struct {
+	BatchSize     int      `yaml:"batch_size" toml:"batch_size"`
+	FlushInterval Duration `yaml:"flush_interval" toml:"flush_interval"`
+	AsyncInsert   bool     `yaml:"async_insert" toml:"async_insert"`
+	BufferSize    int      `yaml:"buffer_size" toml:"buffer_size"`
+
+	// Events are refused with 429 once the queue is HighWatermark full,
+	// until it drains to LowWatermark (fractions of buffer_size).
+	HighWatermark float64 `yaml:"high_watermark" toml:"high_watermark"`
+	LowWatermark  float64 `yaml:"low_watermark" toml:"low_watermark"`
+	// MaxConcurrentInserts bounds the adaptive insert concurrency, which
+	// backs off when batches take longer than TargetInsertLatency.
+	MaxConcurrentInserts int      `yaml:"max_concurrent_inserts" toml:"max_concurrent_inserts"`
+	TargetInsertLatency  Duration `yaml:"target_insert_latency" toml:"target_insert_latency"`
+	// Client event IDs seen within DedupWindow are acknowledged without
+	// being written again; DedupSize bounds how many are remembered. A zero
+	// window disables this. Past the window, the events table collapses a
+	// repeat only if its timestamp falls in the same hour, which a retry
+	// stamped on receipt or shifted by skew correction may not.
+	DedupWindow Duration `yaml:"dedup_window" toml:"dedup_window"`
+	DedupSize   int      `yaml:"dedup_size" toml:"dedup_size"`
+}
+
+// Forwarder types.
+const (
+	ForwarderInsights     = "insights"
+	ForwarderDatastoreAPI = "datastore_api"
+	ForwarderAnalytics    = "analytics"
+	ForwarderWebhook      = "webhook"
+	ForwarderKafka        = "kafka"
+	ForwarderArchive      = "archive"
+	ForwarderFile         = "file"
+	ForwarderGA4          = "ga4"
+	ForwarderMeta         = "meta"
+	ForwarderGoogleAds    = "google_ads"
+)
+
+// ForwarderConfig describes one named forwarder.
+type ForwarderConfig struct {
+	Name          string   `yaml:"name" toml:"name"`
+	Type          string   `yaml:"type" toml:"type"`
+	Disabled      bool     `yaml:"disabled" toml:"disabled"`
+	Endpoint      string   `yaml:"endpoint" toml:"endpoint"`
+	APIKey        string   `yaml:"api_key" toml:"api_key"`
+	WebsiteID     string   `yaml:"website_id" toml:"website_id"`
+	BatchSize     int      `yaml:"batch_size" toml:"batch_size"`
+	FlushInterval Duration `yaml:"flush_interval" toml:"flush_interval"`
+	Timeout       Duration `yaml:"timeout" toml:"timeout"`
+
+	// Webhook settings: HMAC signing secret, body format
+	// (json_array, ndjson or single) and extra request headers.
+	Secret  string            `yaml:"secret" toml:"secret"`
+	Format  string            `yaml:"format" toml:"format"`
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+
+	// Kafka settings. Topics route matching event names to other topics;
+	// PartitionKey is organization_id (default) or distinct_id.
+	Brokers      []string     `yaml:"brokers" toml:"brokers"`
+	Topic        string       `yaml:"topic" toml:"topic"`
+	Topics       []TopicRoute `yaml:"topics" toml:"topics"`
+	PartitionKey string       `yaml:"partition_key" toml:"partition_key"`
+	Acks         string       `yaml:"acks" toml:"acks"`
+	Compression  string       `yaml:"compression" toml:"compression"`
+	SASL         SASLConfig   `yaml:"sasl" toml:"sasl"`
+	TLS          TLSConfig    `yaml:"tls" toml:"tls"`
+
+	// Archive settings. Format is parquet (default) or ndjson; files roll
+	// over at MaxFileSize uncompressed bytes or MaxFileAge. Files that fail
+	// to upload are retried, holding at most MaxPendingSize bytes (default
+	// 4 * max_file_size); the oldest beyond that are dropped.
+	Storage        StorageConfig `yaml:"storage" toml:"storage"`
+	MaxFileSize    int64         `yaml:"max_file_size" toml:"max_file_size"`
+	MaxFileAge     Duration      `yaml:"max_file_age" toml:"max_file_age"`
+	MaxPendingSize int64         `yaml:"max_pending_size" toml:"max_pending_size"`
+
+	// File settings. Path is the active JSON lines file; it rotates at
+	// max_file_size bytes or max_file_age, keeping MaxFiles rotated files.
+	Path     string `yaml:"path" toml:"path"`
+	MaxFiles int    `yaml:"max_files" toml:"max_files"`
+	Compress bool   `yaml:"compress" toml:"compress"`
+
+	// Account is the default destination account (a GA4 measurement ID and
+	// API secret, for example); Accounts overrides it per organization.
+	Account  AccountConfig            `yaml:"account" toml:"account"`
+	Accounts map[string]AccountConfig `yaml:"accounts" toml:"accounts"`
+	// Debug sends to the destination's validation endpoint, where supported.
+	Debug bool `yaml:"debug" toml:"debug"`
+	// TestEventCode sends Meta events to the Test Events tool.
+	TestEventCode string `yaml:"test_event_code" toml:"test_event_code"`
+	// GoogleAds holds Google Ads API credentials and conversion actions;
+	// Account and Accounts give the customer ID per organization.
+	GoogleAds GoogleAdsConfig `yaml:"google_ads" toml:"google_ads"`
+
+	// Routing selects and shapes the events this forwarder receives.
+	Routing writer.Routing `yaml:"routing" toml:"routing"`
+	// Buffer bounds the events waiting for this forwarder and sets what
+	// happens when it is full.
+	Buffer writer.Buffer `yaml:"buffer" toml:"buffer"`
+}
+
+// TopicRoute sends events matching Events (names or glob patterns) to Topic.
+type TopicRoute struct {
+	Events []string `yaml:"events" toml:"events"`
+	Topic  string   `yaml:"topic" toml:"topic"`
+}
+
+// SASLConfig configures SASL authentication.
+type SASLConfig struct {
+	Mechanism string `yaml:"mechanism" toml:"mechanism"`
+	Username  string `yaml:"username" toml:"username"`
+	Password  string `yaml:"password" toml:"password"`
+}
+
+// TLSConfig configures TLS to a broker.
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled" toml:"enabled"`
+	CAFile             string `yaml:"ca_file" toml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+}
+
+// AccountConfig identifies an account in a third-party service.
+type AccountConfig struct {
+	ID     string `yaml:"id" toml:"id"`
+	Secret string `yaml:"secret" toml:"secret"`
+}
+
+// GoogleAdsConfig configures offline conversion uploads. ConversionActions
+// maps event names to conversion action IDs. An account's secret, if set,
+// overrides RefreshToken for that customer. ResultsPath is a JSON lines file
+// recording the outcome of every upload.
+//
+// The writer remembers the latest gclid per person and session in memory
+// (up to 100000 keys for 90 days) and stores it on conversions that arrive
+// without one. A conversion whose click is not in memory, after a restart
+// for example, gets the person's latest stored gclid; session-only
+// attribution does not survive.
+// Uploads already made are remembered only in memory too, so a conversion
+// replayed after a restart is uploaded again; Google Ads rejects repeats of
+// an order ID.
+type GoogleAdsConfig struct {
+	DeveloperToken    string            `yaml:"developer_token" toml:"developer_token"`
+	ClientID          string            `yaml:"client_id" toml:"client_id"`
+	ClientSecret      string            `yaml:"client_secret" toml:"client_secret"`
+	RefreshToken      string            `yaml:"refresh_token" toml:"refresh_token"`
+	LoginCustomerID   string            `yaml:"login_customer_id" toml:"login_customer_id"`
+	ConversionActions map[string]string `yaml:"conversion_actions" toml:"conversion_actions"`
+	ResultsPath       string            `yaml:"results_path" toml:"results_path"`
+}
+
+// StorageConfig selects a local directory (Dir) or an S3-compatible bucket.
+type StorageConfig struct {
+	Dir       string `yaml:"dir" toml:"dir"`
+	Bucket    string `yaml:"bucket" toml:"bucket"`
+	Prefix    string `yaml:"prefix" toml:"prefix"`
+	Endpoint  string `yaml:"endpoint" toml:"endpoint"`
+	Region    string `yaml:"region" toml:"region"`
+	AccessKey string `yaml:"access_key" toml:"access_key"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	Insecure  bool   `yaml:"insecure" toml:"insecure"`
+	PathStyle bool   `yaml:"path_style" toml:"path_style"`
+}
+
+// EnrichmentConfig selects which fields are derived from the request.
+type EnrichmentConfig struct {
+	UserAgent bool `yaml:"user_agent" toml:"user_agent"`
+	UTM       bool `yaml:"utm" toml:"utm"`
+	ClickIDs  bool `yaml:"click_ids" toml:"click_ids"`
+	Referrer  bool `yaml:"referrer" toml:"referrer"`
+}
+
+// PrivacyConfig controls what personal data is kept.
+type PrivacyConfig struct {
+	AnonymizeIP   bool `yaml:"anonymize_ip" toml:"anonymize_ip"`
+	DropIP        bool `yaml:"drop_ip" toml:"drop_ip"`
+	DropUserAgent bool `yaml:"drop_user_agent" toml:"drop_user_agent"`
+	// StripQueryParams removes these query parameters from URLs; "*" removes the whole query.
+	StripQueryParams []string `yaml:"strip_query_params" toml:"strip_query_params"`
+}
+
+// TimestampsConfig bounds the event times the collector accepts, relative
+// to when the event was received.
+type TimestampsConfig struct {
+	// MaxFuture and MaxPast are how far ahead or behind an event may be;
+	// 0 disables the bound.
+	MaxFuture Duration `yaml:"max_future" toml:"max_future"`
+	MaxPast   Duration `yaml:"max_past" toml:"max_past"`
+	// Policy is "clamp" to record such events at the receive time, or
+	// "reject" to refuse them.
+	Policy string `yaml:"policy" toml:"policy"`
+}
+
+// LoggingConfig configures the logger. Level changes apply on reload;
+// Format changes require a restart.
+type LoggingConfig struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// TracingConfig configures trace export. Changes require a restart.
+type TracingConfig struct {
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	File        string  `yaml:"file" toml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// LiveConfig configures the live event stream.
+type LiveConfig struct {
+	// APIKeys maps an API key to the organization it may tail.
+	APIKeys    map[string]string `yaml:"api_keys" toml:"api_keys"`
+	BufferSize int               `yaml:"buffer_size" toml:"buffer_size"`
+}
+
+// CatalogConfig configures the event and property catalog. Its API is
+// authenticated with live.api_keys. Changes require a restart.
+type CatalogConfig struct {
+	Enabled       bool `yaml:"enabled" toml:"enabled"`
+	MaxEvents     int  `yaml:"max_events" toml:"max_events"`
+	MaxProperties int  `yaml:"max_properties" toml:"max_properties"`
+	// Samples is how many sample values are kept per property; 0 keeps none.
+	Samples       int      `yaml:"samples" toml:"samples"`
+	FlushInterval Duration `yaml:"flush_interval" toml:"flush_interval"`
+}
+
+// CurrencyConfig configures revenue normalization. Revenue is stored in the
+// event's currency and converted to the organization's base currency at
+// the rate of the event's day. The rates file is re-read on reload.
+type CurrencyConfig struct {
+	// Base is the base currency of organizations not in Organizations.
+	Base          string            `yaml:"base" toml:"base"`
+	Organizations map[string]string `yaml:"organizations" toml:"organizations"`
+	// RatesFile is a CSV of date,currency,rate rows, each rate being how
+	// many units of the currency one unit of Reference buys.
+	RatesFile string `yaml:"rates_file" toml:"rates_file"`
+	Reference string `yaml:"reference" toml:"reference"`
+}
+
+// Default returns a configuration with sensible defaults.
+func Default() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			Addr:         ":8091",
+			ReadTimeout:  Duration(30 * time.Second),
+			WriteTimeout: Duration(30 * time.Second),
+		},
+		Datastore: DatastoreConfig{AutoMigrate: true, Database: "commerce"},
+		Writer: WriterConfig{
+			BatchSize:     500,
+			FlushInterval: Duration(5 * time.Second),
+			AsyncInsert:   true,
+			BufferSize:    10000,
+			HighWatermark: 0.8,
+			LowWatermark:  0.5,
+
+			MaxConcurrentInserts: 4,
+			TargetInsertLatency:  Duration(2 * time.Second),
+			DedupWindow:          Duration(10 * time.Minute),
+			DedupSize:            1000000,
+		},
+		Enrichment: EnrichmentConfig{
+			UserAgent: true,
+			UTM:       true,
+			ClickIDs:  true,
+			Referrer:  true,
+		},
+		Timestamps: TimestampsConfig{
+			MaxFuture: Duration(23 * time.Hour),
+			MaxPast:   Duration(365 * 24 * time.Hour),
+			Policy:    "clamp",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "analytics-collector",
+		},
+		Catalog: CatalogConfig{
+			Enabled:       true,
+			MaxEvents:     1000,
+			MaxProperties: 500,
+			Samples:       5,
+			FlushInterval: Duration(time.Minute),
+		},
+		Currency: CurrencyConfig{
+			Base:      "USD",
+			Reference: "USD",
+		},
+	}
+}
+
+// Load reads a YAML (.yaml, .yml) or TOML (.toml) configuration file over
+// the defaults, expanding ${VAR} references from the environment, and
+// validates the result.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	expanded := expandEnv(data)
+
+	config := Default()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(expanded, config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(expanded, config)
+	default:
+		return nil, fmt.Errorf("config %s: unsupported format (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// envRef matches ${VAR}. Bare $VAR is left alone so event names such as
+// "$pageview" survive expansion.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func expandEnv(data []byte) []byte {
+	return envRef.ReplaceAllFunc(data, func(ref []byte) []byte {
+		return []byte(os.Getenv(string(ref[2 : len(ref)-1])))
+	})
+}
+
+// Validate reports every problem with the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Listen.Addr == "" {
+		errs = append(errs, errors.New("listen.addr is required"))
+	}
+	if c.Datastore.DSN == "" {
+		errs = append(errs, errors.New("datastore.dsn is required"))
+	}
+	if err := c.Datastore.Names().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("datastore: %w", err))
+	}
+	for org, database := range c.Datastore.Tenants {
+		if !migrate.ValidIdentifier(database) {
+			errs = append(errs, fmt.Errorf("datastore.tenants.%s: %q is not a valid database name", org, database))
+		}
+	}
+	columns := make(map[string]string)
+	for i, p := range c.Datastore.Promote {
+		if err := p.promotion().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("datastore.promote[%d]: %w", i, err))
+			continue
+		}
+		column := p.promotion().Column()
+		if prev, ok := columns[column]; ok {
+			errs = append(errs, fmt.Errorf("datastore.promote[%d]: %s and %s both map to column %s", i, prev, p.Key, column))
+		}
+		columns[column] = p.Key
+	}
+	if c.Writer.BatchSize <= 0 {
+		errs = append(errs, errors.New("writer.batch_size must be positive"))
+	}
+	if c.Writer.FlushInterval <= 0 {
+		errs = append(errs, errors.New("writer.flush_interval must be positive"))
+	}
+	if c.Writer.BufferSize <= 0 {
+		errs = append(errs, errors.New("writer.buffer_size must be positive"))
+	}
+	if c.Writer.LowWatermark <= 0 || c.Writer.LowWatermark > c.Writer.HighWatermark || c.Writer.HighWatermark > 1 {
+		errs = append(errs, errors.New("writer watermarks must satisfy 0 < low_watermark <= high_watermark <= 1"))
+	}
+	if c.Writer.MaxConcurrentInserts <= 0 || c.Writer.TargetInsertLatency <= 0 {
+		errs = append(errs, errors.New("writer.max_concurrent_inserts and writer.target_insert_latency must be positive"))
+	}
+	if c.Writer.DedupWindow < 0 || (c.Writer.DedupWindow > 0 && c.Writer.DedupSize <= 0) {
+		errs = append(errs, errors.New("writer.dedup_window must not be negative and writer.dedup_size must be positive"))
+	}
+	if c.Timestamps.MaxFuture < 0 || c.Timestamps.MaxPast < 0 {
+		errs = append(errs, errors.New("timestamps.max_future and timestamps.max_past must not be negative"))
+	}
+	if c.Timestamps.Policy != "clamp" && c.Timestamps.Policy != "reject" {
+		errs = append(errs, fmt.Errorf("timestamps.policy %q must be clamp or reject", c.Timestamps.Policy))
+	}
+	if !currency.Valid(c.Currency.Base) {
+		errs = append(errs, fmt.Errorf("currency.base %q is not a currency code", c.Currency.Base))
+	}
+	if !currency.Valid(c.Currency.Reference) {
+		errs = append(errs, fmt.Errorf("currency.reference %q is not a currency code", c.Currency.Reference))
+	}
+	for org, code := range c.Currency.Organizations {
+		if !currency.Valid(code) {
+			errs = append(errs, fmt.Errorf("currency.organizations.%s: %q is not a currency code", org, code))
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	if c.Catalog.Enabled && (c.Catalog.MaxEvents <= 0 || c.Catalog.MaxProperties <= 0 || c.Catalog.FlushInterval <= 0) {
+		errs = append(errs, errors.New("catalog.max_events, catalog.max_properties and catalog.flush_interval must be positive"))
+	}
+	if c.Catalog.Samples < 0 {
+		errs = append(errs, errors.New("catalog.samples must not be negative"))
+	}
+
+	seen := make(map[string]bool)
+	for i, f := range c.Forwarders {
+		if f.Name == "" {
+			errs = append(errs, fmt.Errorf("forwarders[%d]: name is required", i))
+		} else if seen[f.Name] {
+			errs = append(errs, fmt.Errorf("forwarders[%d]: duplicate name %q", i, f.Name))
+		}
+		seen[f.Name] = true
+		if err := f.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("forwarder %q: %w", f.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *ForwarderConfig) validate() error {
+	if err := f.Buffer.Validate(); err != nil {
+		return fmt.Errorf("buffer: %w", err)
+	}
+	switch f.Type {
+	case ForwarderInsights:
+		if f.APIKey == "" {
+			return errors.New("api_key is required")
+		}
+	case ForwarderDatastoreAPI, ForwarderAnalytics:
+	case ForwarderWebhook:
+		switch f.Format {
+		case "", "json_array", "ndjson", "single":
+		default:
+			return fmt.Errorf("unknown webhook format %q", f.Format)
+		}
+	case ForwarderKafka:
+		return f.validateKafka()
+	case ForwarderArchive:
+		return f.validateArchive()
+	case ForwarderFile:
+		return f.validateFile()
+	case ForwarderGA4, ForwarderMeta:
+		return f.validateAccounts()
+	case ForwarderGoogleAds:
+		return f.validateGoogleAds()
+	case "":
+		return errors.New("type is required")
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+	if f.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+	if err := f.Routing.Validate(); err != nil {
+		return fmt.Errorf("routing: %w", err)
+	}
+	return nil
+}
+
+func (f *ForwarderConfig) validateKafka() error {
+	var errs []error
+	if len(f.Brokers) == 0 {
+		errs = append(errs, errors.New("brokers is required"))
+	}
+	if f.Topic == "" && len(f.Topics) == 0 {
+		errs = append(errs, errors.New("topic or topics is required"))
+	}
+	for i, t := range f.Topics {
+		if t.Topic == "" {
+			errs = append(errs, fmt.Errorf("topics[%d]: topic is required", i))
+		}
+		for _, pattern := range t.Events {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("topics[%d]: event pattern %q: %w", i, pattern, err))
+			}
+		}
+	}
+	switch f.PartitionKey {
+	case "", writer.PartitionByOrganization, writer.PartitionByDistinctID:
+	default:
+		errs = append(errs, fmt.Errorf("unknown partition_key %q", f.PartitionKey))
+	}
+	switch f.Acks {
+	case "", "none", "one", "all":
+	default:
+		errs = append(errs, fmt.Errorf("unknown acks %q", f.Acks))
+	}
+	switch f.Compression {
+	case "", "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		errs = append(errs, fmt.Errorf("unknown compression %q", f.Compression))
+	}
+	switch f.SASL.Mechanism {
+	case "", "plain", "scram-sha-256", "scram-sha-512":
+	default:
+		errs = append(errs, fmt.Errorf("unknown sasl mechanism %q", f.SASL.Mechanism))
+	}
+	if err := f.Routing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("routing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *ForwarderConfig) validateArchive() error {
+	var errs []error
+	switch f.Format {
+	case "", "parquet", "ndjson":
+	default:
+		errs = append(errs, fmt.Errorf("unknown archive format %q", f.Format))
+	}
+	if (f.Storage.Dir == "") == (f.Storage.Bucket == "") {
+		errs = append(errs, errors.New("storage needs exactly one of dir or bucket"))
+	}
+	if f.MaxFileSize < 0 || f.MaxFileAge < 0 || f.MaxPendingSize < 0 {
+		errs = append(errs, errors.New("max_file_size, max_file_age and max_pending_size must not be negative"))
+	}
+	if err := f.Routing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("routing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *ForwarderConfig) validateFile() error {
+	var errs []error
+	if f.Path == "" {
+		errs = append(errs, errors.New("path is required"))
+	}
+	if f.MaxFileSize < 0 || f.MaxFileAge < 0 || f.MaxFiles < 0 {
+		errs = append(errs, errors.New("max_file_size, max_file_age and max_files must not be negative"))
+	}
+	if err := f.Routing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("routing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// validateAccounts checks forwarders that send to per-organization accounts.
+func (f *ForwarderConfig) validateAccounts() error {
+	var errs []error
+	if f.Account.ID == "" && len(f.Accounts) == 0 {
+		errs = append(errs, errors.New("account or accounts is required"))
+	}
+	if f.Account.ID != "" && f.Account.Secret == "" {
+		errs = append(errs, errors.New("account: secret is required"))
+	}
+	for org, a := range f.Accounts {
+		if a.ID == "" || a.Secret == "" {
+			errs = append(errs, fmt.Errorf("accounts[%s]: id and secret are required", org))
+		}
+	}
+	if err := f.Routing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("routing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *ForwarderConfig) validateGoogleAds() error {
+	var errs []error
+	g := f.GoogleAds
+	if g.DeveloperToken == "" || g.ClientID == "" || g.ClientSecret == "" {
+		errs = append(errs, errors.New("google_ads: developer_token, client_id and client_secret are required"))
+	}
+	if len(g.ConversionActions) == 0 {
+		errs = append(errs, errors.New("google_ads: conversion_actions is required"))
+	}
+	if g.ResultsPath == "" {
+		errs = append(errs, errors.New("google_ads: results_path is required"))
+	}
+	if f.Account.ID == "" && len(f.Accounts) == 0 {
+		errs = append(errs, errors.New("account or accounts is required"))
+	}
+	if f.Account.ID != "" && f.Account.Secret == "" && g.RefreshToken == "" {
+		errs = append(errs, errors.New("account: google_ads.refresh_token or secret is required"))
+	}
+	for org, a := range f.Accounts {
+		if a.ID == "" {
+			errs = append(errs, fmt.Errorf("accounts[%s]: id is required", org))
+		} else if a.Secret == "" && g.RefreshToken == "" {
+			errs = append(errs, fmt.Errorf("accounts[%s]: google_ads.refresh_token or secret is required", org))
+		}
+	}
+	if err := f.Routing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("routing: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// AccountSet converts the account settings for the writer.
+func (f *ForwarderConfig) AccountSet() *writer.Accounts {
+	accounts := &writer.Accounts{
+		Default:        writer.Account{ID: f.Account.ID, Secret: f.Account.Secret},
+		ByOrganization: make(map[string]writer.Account, len(f.Accounts)),
+	}
+	for org, a := range f.Accounts {
+		accounts.ByOrganization[org] = writer.Account{ID: a.ID, Secret: a.Secret}
+	}
+	return accounts
+}
+
+// Enabled returns the forwarders that are not disabled.
+func (c *Config) Enabled() []ForwarderConfig {
+	var out []ForwarderConfig
+	for _, f := range c.Forwarders {
+		if !f.Disabled {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Conversions returns the event names uploaded by enabled Google Ads
+// forwarders; the writer attributes them to their person's latest gclid.
+func (c *Config) Conversions() []string {
+	var out []string
+	for _, f := range c.Enabled() {
+		if f.Type != ForwarderGoogleAds {
+			continue
+		}
+		for event := range f.GoogleAds.ConversionActions {
+			if !slices.Contains(out, event) {
+				out = append(out, event)
+			}
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// RestartRequired lists settings that differ between c and next but can
+// only take effect after a restart.
+func (c *Config) RestartRequired(next *Config) []string {
+	var out []string
+	if c.Listen != next.Listen {
+		out = append(out, "listen")
+	}
+	if c.Datastore.DSN != next.Datastore.DSN || c.Datastore.AutoMigrate != next.Datastore.AutoMigrate ||
+		c.Datastore.Names() != next.Datastore.Names() || !equalKeys(c.Datastore.Tenants, next.Datastore.Tenants) ||
+		!reflect.DeepEqual(c.Datastore.Promote, next.Datastore.Promote) {
+		out = append(out, "datastore")
+	}
+	if c.Writer.BufferSize != next.Writer.BufferSize {
+		out = append(out, "writer.buffer_size")
+	}
+	if c.Writer.AsyncInsert != next.Writer.AsyncInsert {
+		out = append(out, "writer.async_insert")
+	}
+	if c.Writer.HighWatermark != next.Writer.HighWatermark || c.Writer.LowWatermark != next.Writer.LowWatermark ||
+		c.Writer.MaxConcurrentInserts != next.Writer.MaxConcurrentInserts || c.Writer.TargetInsertLatency != next.Writer.TargetInsertLatency {
+		out = append(out, "writer admission control")
+	}
+	if c.Writer.DedupWindow != next.Writer.DedupWindow || c.Writer.DedupSize != next.Writer.DedupSize {
+		out = append(out, "writer deduplication")
+	}
+	if c.Logging.Format != next.Logging.Format {
+		out = append(out, "logging.format")
+	}
+	if c.Tracing != next.Tracing {
+		out = append(out, "tracing")
+	}
+	if !equalKeys(c.Live.APIKeys, next.Live.APIKeys) || c.Live.BufferSize != next.Live.BufferSize {
+		out = append(out, "live")
+	}
+	if c.Catalog != next.Catalog {
+		out = append(out, "catalog")
+	}
+	return out
+}
+
+func equalKeys(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
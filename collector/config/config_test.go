@@ -0,0 +1,289 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_YAML(t *testing.T) {
+	t.Setenv("TEST_INSIGHTS_KEY", "phc_123")
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+writer:
+  batch_size: 100
+  flush_interval: 2s
+forwarders:
+  - name: insights-ai
+    type: insights
+    endpoint: https://insights.example.com
+    api_key: ${TEST_INSIGHTS_KEY}
+  - name: umami
+    type: analytics
+    endpoint: https://analytics.example.com
+    disabled: true
+privacy:
+  anonymize_ip: true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Listen.Addr != ":8091" {
+		t.Errorf("expected default listen addr, got %q", cfg.Listen.Addr)
+	}
+	if cfg.Writer.BatchSize != 100 || time.Duration(cfg.Writer.FlushInterval) != 2*time.Second {
+		t.Errorf("unexpected writer config: %+v", cfg.Writer)
+	}
+	if cfg.Writer.BufferSize != 10000 {
+		t.Errorf("expected default buffer size, got %d", cfg.Writer.BufferSize)
+	}
+	if cfg.Forwarders[0].APIKey != "phc_123" {
+		t.Errorf("expected env expansion, got %q", cfg.Forwarders[0].APIKey)
+	}
+	if got := len(cfg.Enabled()); got != 1 {
+		t.Errorf("expected 1 enabled forwarder, got %d", got)
+	}
+	if !cfg.Privacy.AnonymizeIP || !cfg.Enrichment.UserAgent {
+		t.Errorf("unexpected privacy/enrichment: %+v %+v", cfg.Privacy, cfg.Enrichment)
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "collector.toml", `
+[datastore]
+dsn = "clickhouse://localhost:9000"
+
+[[forwarders]]
+name = "ds"
+type = "datastore_api"
+endpoint = "http://datastore:8080/api/v1/ingest"
+timeout = "3s"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.Forwarders) != 1 || time.Duration(cfg.Forwarders[0].Timeout) != 3*time.Second {
+		t.Errorf("unexpected forwarders: %+v", cfg.Forwarders)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+writer:
+  batch_size: 0
+forwarders:
+  - name: a
+    type: insights
+    endpoint: https://insights.example.com
+  - name: a
+    type: carrier-pigeon
+timestamps:
+  policy: ignore
+currency:
+  organizations:
+    org_eu: euro
+`)
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"datastore.dsn", "writer.batch_size", "api_key is required", `duplicate name "a"`, "unknown type", "timestamps.policy", "currency.organizations.org_eu"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	a := Default()
+	b := Default()
+	b.Writer.BatchSize = 10
+	b.Listen.Addr = ":9000"
+	b.Logging.Level = "debug"
+	b.Logging.Format = "text"
+
+	keys := a.RestartRequired(b)
+	if len(keys) != 2 || keys[0] != "listen" || keys[1] != "logging.format" {
+		t.Errorf("expected only listen and logging.format to require restart, got %v", keys)
+	}
+}
+
+func TestLoad_Example(t *testing.T) {
+	t.Setenv("DATASTORE_URL", "clickhouse://localhost:9000")
+	t.Setenv("INSIGHTS_HOST", "https://insights.example.com")
+	t.Setenv("INSIGHTS_API_KEY", "phc_123")
+	t.Setenv("GA4_API_SECRET", "secret")
+	t.Setenv("GA4_ACME_API_SECRET", "secret")
+	t.Setenv("META_ACME_ACCESS_TOKEN", "token")
+	t.Setenv("GOOGLE_ADS_DEVELOPER_TOKEN", "dev")
+	t.Setenv("GOOGLE_ADS_CLIENT_ID", "client")
+	t.Setenv("GOOGLE_ADS_CLIENT_SECRET", "secret")
+	t.Setenv("GOOGLE_ADS_REFRESH_TOKEN", "refresh")
+	if _, err := Load("../collector.example.yaml"); err != nil {
+		t.Fatalf("example config does not load: %v", err)
+	}
+}
+
+func TestLoad_KeepsBareDollarNames(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+forwarders:
+  - name: umami
+    type: analytics
+    endpoint: https://analytics.example.com
+    routing:
+      include:
+        - events: ["$pageview"]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.Forwarders[0].Routing.Include[0].Events[0]; got != "$pageview" {
+		t.Errorf("expected $pageview to survive env expansion, got %q", got)
+	}
+}
+
+func TestLoad_BufferValidation(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+forwarders:
+  - name: backup
+    type: analytics
+    endpoint: https://analytics.example.com
+    buffer:
+      overflow: spill_to_disk
+`)
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "spill_dir is required") {
+		t.Fatalf("expected spill_dir error, got %v", err)
+	}
+}
+
+func TestLoad_KafkaValidation(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+forwarders:
+  - name: stream
+    type: kafka
+    topics:
+      - events: ["ai.*"]
+    acks: most
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"brokers is required", "topic is required", `unknown acks "most"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoad_Tenants(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+  database: analytics
+  tables:
+    events: raw_events
+  tenants:
+    org_acme: acme
+    org_globex: globex
+    org_initech: acme
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := cfg.Datastore.Schemas()
+	if len(schemas) != 3 {
+		t.Fatalf("expected the default and two tenant databases, got %+v", schemas)
+	}
+	for i, database := range []string{"analytics", "acme", "globex"} {
+		if schemas[i].Database != database || schemas[i].Events != "raw_events" || schemas[i].Persons != "persons" {
+			t.Errorf("schema %d: unexpected names %+v", i, schemas[i])
+		}
+	}
+
+	path = writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+  tables:
+    events: "events; DROP TABLE x"
+  tenants:
+    org_acme: "acme-prod"
+`)
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "events") || !strings.Contains(err.Error(), "datastore.tenants.org_acme") {
+		t.Fatalf("expected invalid name errors, got %v", err)
+	}
+}
+
+func TestLoad_Promote(t *testing.T) {
+	path := writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+  tenants:
+    org_acme: acme
+  promote:
+    - key: plan
+    - key: seats
+      type: number
+      organizations: [org_acme]
+    - key: trial
+      type: bool
+      organizations: [org_other]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := func(database string) (out []string) {
+		for _, p := range cfg.Datastore.Promotions(database) {
+			out = append(out, p.Key)
+		}
+		return out
+	}
+	if got := keys("commerce"); strings.Join(got, ",") != "plan,trial" {
+		t.Errorf("default database: unexpected promotions %v", got)
+	}
+	if got := keys("acme"); strings.Join(got, ",") != "plan,seats" {
+		t.Errorf("tenant database: unexpected promotions %v", got)
+	}
+
+	path = writeFile(t, "collector.yaml", `
+datastore:
+  dsn: clickhouse://localhost:9000
+  promote:
+    - key: cart-value
+    - key: cart_value
+    - key: when
+      type: date
+`)
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "column prop_cart_value") || !strings.Contains(err.Error(), `unknown type "date"`) {
+		t.Fatalf("expected promotion errors, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FromEnv builds a configuration from the environment variables the
+// collector has always accepted, for deployments without a config file.
+func FromEnv() (*Config, error) {
+	config := Default()
+
+	config.Listen.Addr = getEnv("COLLECTOR_ADDR", config.Listen.Addr)
+	config.Datastore.DSN = getEnv("DATASTORE_URL", os.Getenv("DATASTORE_DSN"))
+	config.Datastore.AutoMigrate = os.Getenv("DATASTORE_AUTO_MIGRATE") != "false"
+	config.Datastore.Database = getEnv("DATASTORE_DATABASE", config.Datastore.Database)
+	config.Currency.Base = getEnv("CURRENCY_BASE", config.Currency.Base)
+	config.Currency.RatesFile = os.Getenv("EXCHANGE_RATES_FILE")
+	config.Currency.Reference = getEnv("EXCHANGE_RATES_REFERENCE", config.Currency.Reference)
+	config.Logging.Level = getEnv("LOG_LEVEL", config.Logging.Level)
+	config.Logging.Format = getEnv("LOG_FORMAT", config.Logging.Format)
+
+	config.Tracing.ServiceName = getEnv("OTEL_SERVICE_NAME", config.Tracing.ServiceName)
+	config.Tracing.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	config.Tracing.Insecure = os.Getenv("OTEL_EXPORTER_OTLP_INSECURE") == "true"
+	config.Tracing.File = os.Getenv("TRACING_FILE")
+
+	// Insights forwarder (behavioral analytics / Insights-compatible).
+	if endpoint := getEnv("INSIGHTS_HOST", os.Getenv("INSIGHTS_ENDPOINT")); endpoint != "" {
+		if apiKey := getEnv("INSIGHTS_API_KEY", os.Getenv("INSIGHTS_KEY")); apiKey != "" {
+			config.Forwarders = append(config.Forwarders, ForwarderConfig{
+				Name:     ForwarderInsights,
+				Type:     ForwarderInsights,
+				Endpoint: endpoint,
+				APIKey:   apiKey,
+			})
+		}
+	}
+
+	// Datastore REST API forwarder (Hanzo datastore service).
+	if endpoint := getEnv("DATASTORE_API_URL", os.Getenv("DATASTORE_API_ENDPOINT")); endpoint != "" {
+		config.Forwarders = append(config.Forwarders, ForwarderConfig{
+			Name:     ForwarderDatastoreAPI,
+			Type:     ForwarderDatastoreAPI,
+			Endpoint: endpoint,
+			APIKey:   os.Getenv("DATASTORE_API_KEY"),
+		})
+	}
+
+	// Analytics backend forwarder (Umami-compatible).
+	if endpoint := getEnv("ANALYTICS_FORWARD_URL", os.Getenv("ANALYTICS_ENDPOINT")); endpoint != "" {
+		config.Forwarders = append(config.Forwarders, ForwarderConfig{
+			Name:      ForwarderAnalytics,
+			Type:      ForwarderAnalytics,
+			Endpoint:  endpoint,
+			WebsiteID: os.Getenv("ANALYTICS_WEBSITE_ID"),
+		})
+	}
+
+	// Kafka producer, sharing KAFKA_BROKER, KAFKA_URL credentials and
+	// KAFKA_SASL_MECHANISM with the web app.
+	if brokers := os.Getenv("KAFKA_BROKER"); brokers != "" {
+		if topic := os.Getenv("KAFKA_TOPIC"); topic != "" {
+			fc := ForwarderConfig{
+				Name:    ForwarderKafka,
+				Type:    ForwarderKafka,
+				Brokers: strings.Split(brokers, ","),
+				Topic:   topic,
+			}
+			if u, err := url.Parse(os.Getenv("KAFKA_URL")); err == nil && u.User != nil {
+				password, _ := u.User.Password()
+				if u.User.Username() != "" && password != "" {
+					fc.SASL = SASLConfig{
+						Mechanism: getEnv("KAFKA_SASL_MECHANISM", "plain"),
+						Username:  u.User.Username(),
+						Password:  password,
+					}
+					fc.TLS.Enabled = true
+				}
+			}
+			config.Forwarders = append(config.Forwarders, fc)
+		}
+	}
+
+	config.Live.APIKeys = parseKeys(os.Getenv("LIVE_API_KEYS"))
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseKeys parses a comma-separated list of key:organization pairs.
+func parseKeys(s string) map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, orgID, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && key != "" && orgID != "" {
+			keys[key] = orgID
+		}
+	}
+	return keys
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls path every interval and calls onChange when its contents
+// change. It returns when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := os.ReadFile(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			onChange()
+		}
+	}
+}
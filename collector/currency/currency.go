@@ -0,0 +1,212 @@
+// Package currency normalizes event revenue to each organization's base
+// currency using a locally loaded table of dated exchange rates.
+package currency
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Rates is a table of exchange rates quoted against one reference
+// currency. A rate is how many units of a currency one unit of the
+// reference buys on a date.
+type Rates struct {
+	reference string
+	series    map[string][]point
+}
+
+type point struct {
+	date time.Time
+	rate float64
+}
+
+// Load reads a rates table from a CSV file; see Parse.
+func Load(path, reference string) (*Rates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open rates: %w", err)
+	}
+	defer f.Close()
+	rates, err := Parse(f, reference)
+	if err != nil {
+		return nil, fmt.Errorf("rates %s: %w", path, err)
+	}
+	return rates, nil
+}
+
+// Parse reads CSV rows of date (YYYY-MM-DD), currency code and rate. An
+// optional "date,currency,rate" header and lines starting with # are
+// skipped.
+func Parse(r io.Reader, reference string) (*Rates, error) {
+	reference = strings.ToUpper(reference)
+	if !Valid(reference) {
+		return nil, fmt.Errorf("invalid reference currency %q", reference)
+	}
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+
+	rates := &Rates{reference: reference, series: make(map[string][]point)}
+	for first := true; ; first = false {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(row[0], "date") {
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		date, err := time.Parse(time.DateOnly, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, row[0])
+		}
+		code := strings.ToUpper(row[1])
+		if !Valid(code) {
+			return nil, fmt.Errorf("line %d: invalid currency %q", line, row[1])
+		}
+		rate, err := strconv.ParseFloat(row[2], 64)
+		if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, row[2])
+		}
+		rates.series[code] = append(rates.series[code], point{date, rate})
+	}
+
+	for code, series := range rates.series {
+		sort.Slice(series, func(i, j int) bool { return series[i].date.Before(series[j].date) })
+		for i := 1; i < len(series); i++ {
+			if series[i].date.Equal(series[i-1].date) {
+				return nil, fmt.Errorf("%s has two rates for %s", code, series[i].date.Format(time.DateOnly))
+			}
+		}
+	}
+	return rates, nil
+}
+
+// Reference returns the currency the rates are quoted against.
+func (r *Rates) Reference() string { return r.reference }
+
+// Currencies returns how many currencies have rates, besides the reference.
+func (r *Rates) Currencies() int { return len(r.series) }
+
+// Rate returns the rate of code in effect at t: the latest one dated on or
+// before t, or the earliest one for times before the table starts.
+func (r *Rates) Rate(code string, t time.Time) (float64, bool) {
+	if code == r.reference {
+		return 1, true
+	}
+	series := r.series[code]
+	if len(series) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(series), func(i int) bool { return series[i].date.After(t) })
+	if i == 0 {
+		return series[0].rate, true
+	}
+	return series[i-1].rate, true
+}
+
+// Convert converts amount from one currency to another at the rates in
+// effect at t. A nil table converts only between equal currencies.
+func (r *Rates) Convert(amount float64, from, to string, t time.Time) (float64, bool) {
+	if from == to {
+		return amount, true
+	}
+	if r == nil {
+		return 0, false
+	}
+	fromRate, ok := r.Rate(from, t)
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := r.Rate(to, t)
+	if !ok {
+		return 0, false
+	}
+	return amount / fromRate * toRate, true
+}
+
+// Valid reports whether code looks like an ISO 4217 code: three
+// upper-case letters.
+func Valid(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrUnconverted is returned by Normalize when there is no rate between
+// an event's currency and its organization's base currency.
+var ErrUnconverted = errors.New("no exchange rate")
+
+// Normalizer sets the base-currency revenue of events.
+type Normalizer struct {
+	// Base is the base currency of organizations not in Organizations.
+	Base          string
+	Organizations map[string]string
+	// Rates may be nil, in which case only revenue already in the base
+	// currency is normalized.
+	Rates *Rates
+}
+
+// BaseCurrency returns the base currency of an organization.
+func (n *Normalizer) BaseCurrency(orgID string) string {
+	if base, ok := n.Organizations[orgID]; ok {
+		return base
+	}
+	return n.Base
+}
+
+// Normalize sets the event's currency code, base currency and revenue in
+// that base currency, converted at the rates of the event's day, and the
+// base revenue of each product line. Revenue without a currency is taken
+// to be in the base currency. When no rate is known the base revenue is
+// left at zero and ErrUnconverted is returned.
+func (n *Normalizer) Normalize(event *collector.RawEvent) error {
+	event.BaseCurrency = n.BaseCurrency(event.OrganizationID)
+	event.Currency = strings.ToUpper(strings.TrimSpace(event.Currency))
+	if event.Currency == "" {
+		event.Currency = event.BaseCurrency
+	}
+
+	var err error
+	convert := func(amount float64) float64 {
+		if amount == 0 || err != nil {
+			return 0
+		}
+		v, ok := n.Rates.Convert(amount, event.Currency, event.BaseCurrency, event.Timestamp)
+		if !ok {
+			err = fmt.Errorf("%w from %s to %s", ErrUnconverted, event.Currency, event.BaseCurrency)
+			return 0
+		}
+		// The datastore keeps four decimal places.
+		return math.Round(v*1e4) / 1e4
+	}
+	event.BaseRevenue = convert(event.Revenue)
+	for i := range event.Products {
+		event.Products[i].BaseRevenue = convert(event.Products[i].Revenue())
+	}
+	if err != nil {
+		metrics.RevenueUnconverted.Inc()
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package currency
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+const table = `date,currency,rate
+# Rates per US dollar.
+2024-01-01,EUR,0.90
+2024-02-01,EUR,0.80
+2024-01-01,gbp,0.75
+`
+
+func day(s string) time.Time {
+	t, _ := time.Parse(time.DateOnly, s)
+	return t.Add(12 * time.Hour)
+}
+
+func TestRates_Convert(t *testing.T) {
+	rates, err := Parse(strings.NewReader(table), "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates.Reference() != "USD" || rates.Currencies() != 2 {
+		t.Fatalf("unexpected table: %s, %d currencies", rates.Reference(), rates.Currencies())
+	}
+
+	tests := []struct {
+		amount   float64
+		from, to string
+		at       string
+		want     float64
+		ok       bool
+	}{
+		{100, "USD", "EUR", "2024-01-15", 90, true},
+		{100, "USD", "EUR", "2024-02-01", 80, true},
+		{100, "USD", "EUR", "2023-06-01", 90, true}, // before the table
+		{90, "EUR", "USD", "2024-01-15", 100, true},
+		{90, "EUR", "GBP", "2024-01-15", 75, true},
+		{10, "JPY", "JPY", "2024-01-15", 10, true},
+		{10, "JPY", "USD", "2024-01-15", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := rates.Convert(tt.amount, tt.from, tt.to, day(tt.at))
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Convert(%v %s to %s at %s) = %v, %v; want %v, %v", tt.amount, tt.from, tt.to, tt.at, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	var none *Rates
+	if _, ok := none.Convert(1, "EUR", "USD", time.Now()); ok {
+		t.Error("nil table converted between currencies")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"2024-01-01,EUR",
+		"01/01/2024,EUR,0.9",
+		"2024-01-01,EURO,0.9",
+		"2024-01-01,EUR,-1",
+		"2024-01-01,EUR,0.9\n2024-01-01,EUR,0.8",
+	} {
+		if _, err := Parse(strings.NewReader(data), "USD"); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	rates, err := Parse(strings.NewReader(table), "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &Normalizer{Base: "USD", Organizations: map[string]string{"eu": "EUR"}, Rates: rates}
+
+	event := &collector.RawEvent{OrganizationID: "eu", Revenue: 10, Currency: "usd", Timestamp: day("2024-02-10")}
+	if err := n.Normalize(event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Currency != "USD" || event.BaseCurrency != "EUR" || event.BaseRevenue != 8 {
+		t.Errorf("unexpected normalization: %s %s %v", event.Currency, event.BaseCurrency, event.BaseRevenue)
+	}
+
+	// Revenue without a currency is in the base currency.
+	event = &collector.RawEvent{OrganizationID: "us", Revenue: 12.5, Timestamp: day("2024-02-10")}
+	if err := n.Normalize(event); err != nil || event.Currency != "USD" || event.BaseRevenue != 12.5 {
+		t.Errorf("unexpected normalization: %s %v, %v", event.Currency, event.BaseRevenue, err)
+	}
+
+	event = &collector.RawEvent{OrganizationID: "us", Revenue: 1000, Currency: "JPY", Timestamp: day("2024-02-10")}
+	if err := n.Normalize(event); !errors.Is(err, ErrUnconverted) || event.BaseRevenue != 0 || event.Revenue != 1000 {
+		t.Errorf("expected ErrUnconverted keeping the original amount, got %v %v", err, event.BaseRevenue)
+	}
+}
@@ -8,6 +8,10 @@ type RawEvent struct {
 	// Core identifiers
 	DistinctID string `json:"distinct_id"`
 	Event      string `json:"event"`
+	// EventID is the client-supplied idempotency key (uuid, $insert_id or
+	// messageId). Retries carrying the same ID are written once. The
+	// writer assigns a random UUID to events sent without one.
+	EventID string `json:"event_id,omitempty"`
 
 	// Organization
 	OrganizationID string `json:"organization_id"`
@@ -71,6 +75,15 @@ type RawEvent struct {
 	Revenue   float64 `json:"revenue,omitempty"`
 	Quantity  int     `json:"quantity,omitempty"`
 
+	// Currency is the ISO 4217 code of Revenue. BaseRevenue is Revenue in
+	// the organization's BaseCurrency, set by the writer.
+	Currency     string  `json:"currency,omitempty"`
+	BaseCurrency string  `json:"base_currency,omitempty"`
+	BaseRevenue  float64 `json:"base_revenue,omitempty"`
+	// Products are the lines of an order; order_completed events with
+	// products are expanded into the order items table.
+	Products []Product `json:"products,omitempty"`
+
 	// AST/Structured Data (astley.js support)
 	ASTContext      string `json:"@context,omitempty"`
 	ASTType         string `json:"@type,omitempty"`
@@ -126,6 +139,7 @@ var StandardEvents = struct {
 	CheckoutStep       string
 	OrderCompleted     string
 	OrderRefunded      string
+	OrderCancelled     string
 	SignedUp           string
 	SignedIn           string
 	SignedOut           string
@@ -174,6 +188,7 @@ var StandardEvents = struct {
 	CheckoutStep:       "checkout_step",
 	OrderCompleted:     "order_completed",
 	OrderRefunded:      "order_refunded",
+	OrderCancelled:     "order_cancelled",
 	SignedUp:           "signed_up",
 	SignedIn:           "signed_in",
 	SignedOut:          "signed_out",
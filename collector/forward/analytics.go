@@ -5,18 +5,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
 )
 
 // ForwardConfig holds analytics forwarding configuration.
 type ForwardConfig struct {
+	Name          string // metrics label; defaults to "analytics"
 	Endpoint      string
 	WebsiteID     string
 	BatchSize     int
 	FlushInterval time.Duration
 	Timeout       time.Duration
+	Logger        *slog.Logger
 }
 
 // ForwardEvent is the internal event representation.
@@ -27,6 +32,7 @@ type ForwardEvent struct {
 
 // ForwardClient forwards events to the analytics backend.
 type ForwardClient struct {
+	logger     *slog.Logger
 	config     *ForwardConfig
 	httpClient *http.Client
 	eventQueue chan *ForwardEvent
@@ -42,6 +48,9 @@ type AnalyticsClientAlias = ForwardClient
 
 // NewForwardClient creates a new analytics forwarding client.
 func NewForwardClient(config *ForwardConfig) *ForwardClient {
+	if config.Name == "" {
+		config.Name = "analytics"
+	}
 	if config.BatchSize == 0 {
 		config.BatchSize = 50
 	}
@@ -54,7 +63,8 @@ func NewForwardClient(config *ForwardConfig) *ForwardClient {
 
 	c := &ForwardClient{
 		config:     config,
-		httpClient: &http.Client{Timeout: config.Timeout},
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		httpClient: newHTTPClient(config.Timeout),
 		eventQueue: make(chan *ForwardEvent, config.BatchSize*10),
 	}
 
@@ -116,6 +126,7 @@ func (c *ForwardClient) sendEvent(event *ForwardEvent) error {
 
 	select {
 	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
 		return nil
 	default:
 		return c.sendEvents([]*ForwardEvent{event})
@@ -123,13 +134,19 @@ func (c *ForwardClient) sendEvent(event *ForwardEvent) error {
 }
 
 func (c *ForwardClient) sendEvents(events []*ForwardEvent) error {
+	return instrumentSend(c.logger, c.config.Name, len(events), func(ctx context.Context) error {
+		return c.send(ctx, events)
+	})
+}
+
+func (c *ForwardClient) send(ctx context.Context, events []*ForwardEvent) error {
 	for _, event := range events {
 		body, err := json.Marshal(event.Payload)
 		if err != nil {
 			return fmt.Errorf("marshal: %w", err)
 		}
 
-		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.config.Endpoint+"/api/send", bytes.NewReader(body))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint+"/api/send", bytes.NewReader(body))
 		if err != nil {
 			return fmt.Errorf("create request: %w", err)
 		}
@@ -164,6 +181,7 @@ func (c *ForwardClient) processBatch() {
 				}
 				return
 			}
+			observeQueue(c.config.Name, len(c.eventQueue))
 			batch = append(batch, event)
 			if len(batch) >= c.config.BatchSize {
 				c.sendEvents(batch)
@@ -0,0 +1,474 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Archive file formats.
+const (
+	ArchiveParquet = "parquet"
+	ArchiveNDJSON  = "ndjson" // gzip-compressed, one event per line
+)
+
+// ArchiveConfig holds raw event archival configuration.
+type ArchiveConfig struct {
+	Name   string // metrics label; defaults to "archive"
+	Store  ObjectStore
+	Prefix string // key prefix inside the store
+	Format string // parquet (default) or ndjson
+
+	// A file is finalized once its events reach MaxFileSize bytes
+	// (uncompressed) or it has been open for MaxFileAge.
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	// MaxPendingSize caps the bytes of finalized files held for a retry
+	// after a failed upload (default 4 * MaxFileSize); beyond it the oldest
+	// files are dropped and their events counted as dropped.
+	MaxPendingSize int64
+
+	FlushInterval time.Duration // how often open files are checked for roll-over
+	Timeout       time.Duration // per upload
+	Logger        *slog.Logger
+}
+
+// ArchiveEvent is one archived event. Its columns match commerce.events, so
+// archives can be loaded back with INSERT ... FORMAT Parquet or JSONEachRow.
+type ArchiveEvent struct {
+	EventID           string             `json:"event_id" parquet:"event_id"`
+	DistinctID        string             `json:"distinct_id" parquet:"distinct_id"`
+	Event             string             `json:"event" parquet:"event"`
+	Timestamp         time.Time          `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	SentAt            time.Time          `json:"sent_at" parquet:"sent_at,timestamp(millisecond)"`
+	CreatedAt         time.Time          `json:"created_at" parquet:"created_at,timestamp(millisecond)"`
+	OrganizationID    string             `json:"organization_id" parquet:"organization_id"`
+	ProjectID         string             `json:"project_id" parquet:"project_id"`
+	SessionID         string             `json:"session_id" parquet:"session_id"`
+	VisitID           string             `json:"visit_id" parquet:"visit_id"`
+	Properties        string             `json:"properties" parquet:"properties"`
+	PropsString       map[string]string  `json:"properties_string" parquet:"properties_string"`
+	PropsNumber       map[string]float64 `json:"properties_number" parquet:"properties_number"`
+	PropsBool         map[string]bool    `json:"properties_bool" parquet:"properties_bool"`
+	PersonProps       string             `json:"person_properties" parquet:"person_properties"`
+	PersonPropsString map[string]string  `json:"person_properties_string" parquet:"person_properties_string"`
+	PersonPropsNumber map[string]float64 `json:"person_properties_number" parquet:"person_properties_number"`
+	PersonPropsBool   map[string]bool    `json:"person_properties_bool" parquet:"person_properties_bool"`
+	GroupType         string             `json:"group_type" parquet:"group_type"`
+	GroupKey          string             `json:"group_key" parquet:"group_key"`
+	GroupProps        string             `json:"group_properties" parquet:"group_properties"`
+	GroupPropsString  map[string]string  `json:"group_properties_string" parquet:"group_properties_string"`
+	GroupPropsNumber  map[string]float64 `json:"group_properties_number" parquet:"group_properties_number"`
+	GroupPropsBool    map[string]bool    `json:"group_properties_bool" parquet:"group_properties_bool"`
+	URL               string             `json:"url" parquet:"url"`
+	URLPath           string             `json:"url_path" parquet:"url_path"`
+	Referrer          string             `json:"referrer" parquet:"referrer"`
+	ReferrerDomain    string             `json:"referrer_domain" parquet:"referrer_domain"`
+	Hostname          string             `json:"hostname" parquet:"hostname"`
+	Browser           string             `json:"browser" parquet:"browser"`
+	BrowserVersion    string             `json:"browser_version" parquet:"browser_version"`
+	OS                string             `json:"os" parquet:"os"`
+	OSVersion         string             `json:"os_version" parquet:"os_version"`
+	Device            string             `json:"device" parquet:"device"`
+	DeviceType        string             `json:"device_type" parquet:"device_type,dict"`
+	Screen            string             `json:"screen" parquet:"screen"`
+	Language          string             `json:"language" parquet:"language"`
+	Country           string             `json:"country" parquet:"country,dict"`
+	Region            string             `json:"region" parquet:"region"`
+	City              string             `json:"city" parquet:"city"`
+	UTMSource         string             `json:"utm_source" parquet:"utm_source"`
+	UTMMedium         string             `json:"utm_medium" parquet:"utm_medium"`
+	UTMCampaign       string             `json:"utm_campaign" parquet:"utm_campaign"`
+	UTMContent        string             `json:"utm_content" parquet:"utm_content"`
+	UTMTerm           string             `json:"utm_term" parquet:"utm_term"`
+	GCLID             string             `json:"gclid" parquet:"gclid"`
+	FBCLID            string             `json:"fbclid" parquet:"fbclid"`
+	MSCLKID           string             `json:"msclkid" parquet:"msclkid"`
+	IP                string             `json:"ip" parquet:"ip"`
+	UserAgent         string             `json:"user_agent" parquet:"user_agent"`
+	OrderID           string             `json:"order_id" parquet:"order_id"`
+	ProductID         string             `json:"product_id" parquet:"product_id"`
+	CartID            string             `json:"cart_id" parquet:"cart_id"`
+	Revenue           float64            `json:"revenue" parquet:"revenue"`
+	Currency          string             `json:"currency" parquet:"currency,dict"`
+	BaseCurrency      string             `json:"base_currency" parquet:"base_currency,dict"`
+	BaseRevenue       float64            `json:"revenue_base" parquet:"revenue_base"`
+	Quantity          uint32             `json:"quantity" parquet:"quantity"`
+	ASTContext        string             `json:"ast_context" parquet:"ast_context"`
+	ASTType           string             `json:"ast_type" parquet:"ast_type"`
+	PageTitle         string             `json:"page_title" parquet:"page_title"`
+	PageDescription   string             `json:"page_description" parquet:"page_description"`
+	PageType          string             `json:"page_type" parquet:"page_type,dict"`
+	ElementID         string             `json:"element_id" parquet:"element_id"`
+	ElementType       string             `json:"element_type" parquet:"element_type,dict"`
+	ElementSelector   string             `json:"element_selector" parquet:"element_selector"`
+	ElementText       string             `json:"element_text" parquet:"element_text"`
+	ElementHref       string             `json:"element_href" parquet:"element_href"`
+	SectionName       string             `json:"section_name" parquet:"section_name"`
+	SectionType       string             `json:"section_type" parquet:"section_type,dict"`
+	SectionID         string             `json:"section_id" parquet:"section_id"`
+	ComponentPath     string             `json:"component_path" parquet:"component_path"`
+	ComponentData     string             `json:"component_data" parquet:"component_data"`
+	ModelProvider     string             `json:"model_provider" parquet:"model_provider,dict"`
+	ModelName         string             `json:"model_name" parquet:"model_name"`
+	TokenCount        uint32             `json:"token_count" parquet:"token_count"`
+	TokenPrice        float64            `json:"token_price" parquet:"token_price"`
+	PromptTokens      uint32             `json:"prompt_tokens" parquet:"prompt_tokens"`
+	OutputTokens      uint32             `json:"output_tokens" parquet:"output_tokens"`
+	Lib               string             `json:"lib" parquet:"lib"`
+	LibVersion        string             `json:"lib_version" parquet:"lib_version"`
+}
+
+// archiveStringFields and archiveMapFields are the indexes of ArchiveEvent
+// string and typed property map fields, used to estimate an event's size.
+var archiveStringFields, archiveMapFields = func() ([]int, []int) {
+	t := reflect.TypeOf(ArchiveEvent{})
+	var strs, maps []int
+	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Type.Kind() {
+		case reflect.String:
+			strs = append(strs, i)
+		case reflect.Map:
+			maps = append(maps, i)
+		}
+	}
+	return strs, maps
+}()
+
+// size approximates the uncompressed size of the event.
+func (e *ArchiveEvent) size() int64 {
+	v := reflect.ValueOf(e).Elem()
+	n := int64(64) // timestamps and numeric columns
+	for _, i := range archiveStringFields {
+		n += int64(v.Field(i).Len())
+	}
+	for _, i := range archiveMapFields {
+		for it := v.Field(i).MapRange(); it.Next(); {
+			n += int64(it.Key().Len()) + 8
+			if it.Value().Kind() == reflect.String {
+				n += int64(it.Value().Len())
+			}
+		}
+	}
+	return n
+}
+
+// ArchiveClient writes events to partitioned files in an object store:
+//
+//	<prefix>/org=<org>/date=<YYYY-MM-DD>/hour=<HH>/<start>-<instance>-<seq>.parquet
+//
+// Files are built in memory and uploaded whole when finalized.
+type ArchiveClient struct {
+	logger     *slog.Logger
+	config     *ArchiveConfig
+	instance   string
+	eventQueue chan *ArchiveEvent
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+
+	fileMu      sync.Mutex
+	files       map[string]*archiveFile // open files by partition
+	pending     []*archiveFile          // finalized files whose upload failed
+	pendingSize int64                   // bytes held in pending
+	seq         int
+}
+
+// NewArchiveClient creates a new archival client.
+func NewArchiveClient(config *ArchiveConfig) *ArchiveClient {
+	if config.Name == "" {
+		config.Name = "archive"
+	}
+	if config.Format == "" {
+		config.Format = ArchiveParquet
+	}
+	if config.MaxFileSize == 0 {
+		config.MaxFileSize = 128 << 20
+	}
+	if config.MaxFileAge == 0 {
+		config.MaxFileAge = 15 * time.Minute
+	}
+	if config.MaxPendingSize == 0 {
+		config.MaxPendingSize = 4 * config.MaxFileSize
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	c := &ArchiveClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		instance:   hex.EncodeToString(id),
+		eventQueue: make(chan *ArchiveEvent, 10000),
+		files:      make(map[string]*archiveFile),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c
+}
+
+// Send queues an event, adding it directly if the queue is full or closed.
+func (c *ArchiveClient) Send(event *ArchiveEvent) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.add(event)
+	}
+
+	select {
+	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.add(event)
+	}
+}
+
+// add appends the event to the open file for its partition, finalizing
+// the file once it reaches MaxFileSize.
+func (c *ArchiveClient) add(event *ArchiveEvent) error {
+	partition := archivePartition(event.OrganizationID, event.Timestamp)
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	f, ok := c.files[partition]
+	if !ok {
+		f = c.newFile(partition)
+		c.files[partition] = f
+	}
+	if err := f.write(event); err != nil {
+		c.logger.Error("archive write failed", "error", err, "partition", partition)
+		return err
+	}
+	if f.size >= c.config.MaxFileSize {
+		delete(c.files, partition)
+		return c.finalize(f)
+	}
+	return nil
+}
+
+func archivePartition(orgID string, t time.Time) string {
+	if orgID == "" {
+		orgID = "_"
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+	return fmt.Sprintf("org=%s/date=%s/hour=%02d", url.PathEscape(orgID), t.Format("2006-01-02"), t.Hour())
+}
+
+func (c *ArchiveClient) newFile(partition string) *archiveFile {
+	c.seq++
+	opened := time.Now()
+	name := fmt.Sprintf("%d-%s-%06d", opened.Unix(), c.instance, c.seq)
+
+	f := &archiveFile{opened: opened}
+	if c.config.Format == ArchiveNDJSON {
+		f.key = path.Join(c.config.Prefix, partition, name+".ndjson.gz")
+		f.contentType = "application/gzip"
+		f.enc = &ndjsonEncoder{gz: gzip.NewWriter(&f.buf)}
+	} else {
+		f.key = path.Join(c.config.Prefix, partition, name+".parquet")
+		f.contentType = "application/vnd.apache.parquet"
+		f.enc = &parquetEncoder{w: parquet.NewGenericWriter[ArchiveEvent](&f.buf, parquet.Compression(&parquet.Zstd))}
+	}
+	return f
+}
+
+// finalize closes the file and uploads it; failed uploads are retried on
+// the next tick. Callers hold fileMu.
+func (c *ArchiveClient) finalize(f *archiveFile) error {
+	if f.enc != nil {
+		if err := f.enc.Close(); err != nil {
+			c.logger.Error("archive encode failed", "error", err, "key", f.key, "events", f.count)
+			return err
+		}
+		f.enc = nil
+	}
+
+	err := instrumentSend(c.logger, c.config.Name, f.count, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+		return c.config.Store.Put(ctx, f.key, bytes.NewReader(f.buf.Bytes()), int64(f.buf.Len()), f.contentType)
+	})
+	if err != nil {
+		c.retryLater(f)
+	}
+	return err
+}
+
+// retryLater holds f for the next tick, dropping the oldest held files
+// beyond MaxPendingSize. Callers hold fileMu.
+func (c *ArchiveClient) retryLater(f *archiveFile) {
+	c.pending = append(c.pending, f)
+	c.pendingSize += int64(f.buf.Len())
+	for len(c.pending) > 1 && c.pendingSize > c.config.MaxPendingSize {
+		old := c.pending[0]
+		c.pending = c.pending[1:]
+		c.pendingSize -= int64(old.buf.Len())
+		metrics.ForwarderEvents.WithLabelValues(c.config.Name, "dropped").Add(float64(old.count))
+		c.logger.Error("archive file dropped, too many failed uploads", "key", old.key, "events", old.count)
+	}
+}
+
+// roll finalizes files older than MaxFileAge (or all files when force is
+// set) and retries failed uploads.
+func (c *ArchiveClient) roll(force bool) {
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	pending := c.pending
+	c.pending, c.pendingSize = nil, 0
+	for _, f := range pending {
+		c.finalize(f)
+	}
+
+	for partition, f := range c.files {
+		if force || time.Since(f.opened) >= c.config.MaxFileAge {
+			delete(c.files, partition)
+			c.finalize(f)
+		}
+	}
+}
+
+func (c *ArchiveClient) processBatch() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.eventQueue:
+			if !ok {
+				c.roll(true)
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			c.add(event)
+		case <-ticker.C:
+			c.roll(false)
+		}
+	}
+}
+
+// Flush finalizes all open files.
+func (c *ArchiveClient) Flush() error {
+	for {
+		select {
+		case event := <-c.eventQueue:
+			c.add(event)
+		default:
+			c.roll(true)
+			c.fileMu.Lock()
+			defer c.fileMu.Unlock()
+			if n := len(c.pending); n > 0 {
+				return fmt.Errorf("%d archive files failed to upload", n)
+			}
+			return nil
+		}
+	}
+}
+
+// Close finalizes all open files. Files that still fail to upload are lost.
+func (c *ArchiveClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+	if n := len(c.pending); n > 0 {
+		c.logger.Error("archive files lost", "files", n)
+		return fmt.Errorf("%d archive files failed to upload", n)
+	}
+	return nil
+}
+
+// archiveFile is an open or finalized archive file.
+type archiveFile struct {
+	key         string
+	contentType string
+	opened      time.Time
+	buf         bytes.Buffer
+	enc         archiveEncoder
+	size        int64
+	count       int
+}
+
+func (f *archiveFile) write(event *ArchiveEvent) error {
+	if err := f.enc.Write(event); err != nil {
+		return err
+	}
+	f.size += event.size()
+	f.count++
+	return nil
+}
+
+type archiveEncoder interface {
+	Write(event *ArchiveEvent) error
+	Close() error
+}
+
+type ndjsonEncoder struct {
+	gz *gzip.Writer
+}
+
+func (e *ndjsonEncoder) Write(event *ArchiveEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = e.gz.Write(line)
+	return err
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return e.gz.Close()
+}
+
+type parquetEncoder struct {
+	w *parquet.GenericWriter[ArchiveEvent]
+}
+
+func (e *parquetEncoder) Write(event *ArchiveEvent) error {
+	_, err := e.w.Write([]ArchiveEvent{*event})
+	return err
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.Close()
+}
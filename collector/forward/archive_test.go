@@ -0,0 +1,187 @@
+package forward
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func archiveFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestArchiveClient_Parquet(t *testing.T) {
+	dir := t.TempDir()
+	client := NewArchiveClient(&ArchiveConfig{
+		Store:         &LocalStore{Dir: dir},
+		Prefix:        "raw",
+		FlushInterval: time.Hour,
+	})
+
+	ts := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+	client.Send(&ArchiveEvent{Event: "$pageview", OrganizationID: "org1", DistinctID: "u1", Timestamp: ts})
+	client.Send(&ArchiveEvent{Event: "order_completed", OrganizationID: "org1", DistinctID: "u1", Timestamp: ts, Revenue: 42.5,
+		PropsString: map[string]string{"plan": "pro"}, PropsNumber: map[string]float64{"seats": 3}})
+	client.Send(&ArchiveEvent{Event: "$pageview", OrganizationID: "org2", DistinctID: "u2", Timestamp: ts.Add(time.Hour)})
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := archiveFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v", files)
+	}
+	if !strings.HasPrefix(files[0], "raw/org=org1/date=2026-03-04/hour=15/") || !strings.HasSuffix(files[0], ".parquet") {
+		t.Errorf("unexpected key %q", files[0])
+	}
+	if !strings.HasPrefix(files[1], "raw/org=org2/date=2026-03-04/hour=16/") {
+		t.Errorf("unexpected key %q", files[1])
+	}
+
+	rows, err := parquet.ReadFile[ArchiveEvent](filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1].Event != "order_completed" || rows[1].Revenue != 42.5 {
+		t.Errorf("unexpected rows: %+v", rows)
+	}
+	if rows[1].PropsString["plan"] != "pro" || rows[1].PropsNumber["seats"] != 3 {
+		t.Errorf("typed properties not archived: %+v", rows[1])
+	}
+	if !rows[0].Timestamp.Equal(ts) {
+		t.Errorf("timestamp = %v, want %v", rows[0].Timestamp, ts)
+	}
+}
+
+func TestArchiveClient_NDJSONRollover(t *testing.T) {
+	dir := t.TempDir()
+	event := &ArchiveEvent{Event: "ai.completion", OrganizationID: "org1", Timestamp: time.Now()}
+	client := NewArchiveClient(&ArchiveConfig{
+		Store:         &LocalStore{Dir: dir},
+		Format:        ArchiveNDJSON,
+		MaxFileSize:   event.size() * 2,
+		FlushInterval: time.Hour,
+	})
+	for i := 0; i < 5; i++ {
+		client.Send(event)
+	}
+	client.Close()
+
+	files := archiveFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files (2+2+1 events), got %v", files)
+	}
+
+	f, err := os.Open(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row["event"] != "ai.completion" {
+			t.Errorf("unexpected row %v", row)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 lines, got %d", lines)
+	}
+}
+
+// flakyStore fails the first n puts.
+type flakyStore struct {
+	mu    sync.Mutex
+	fails int
+	keys  []string
+}
+
+func (s *flakyStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("unavailable")
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func TestArchiveClient_RetriesFailedUploads(t *testing.T) {
+	store := &flakyStore{fails: 1}
+	client := NewArchiveClient(&ArchiveConfig{
+		Store:         store,
+		Format:        ArchiveNDJSON,
+		FlushInterval: time.Hour,
+	})
+	client.add(&ArchiveEvent{Event: "e", OrganizationID: "org1"})
+
+	if err := client.Flush(); err == nil {
+		t.Fatal("expected first upload to fail")
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	client.Close()
+
+	if len(store.keys) != 1 {
+		t.Errorf("expected 1 uploaded file, got %v", store.keys)
+	}
+}
+
+func TestArchiveClient_BoundsPendingUploads(t *testing.T) {
+	store := &flakyStore{fails: 3}
+	client := NewArchiveClient(&ArchiveConfig{
+		Store:          store,
+		Format:         ArchiveNDJSON,
+		FlushInterval:  time.Hour,
+		MaxPendingSize: 1,
+	})
+	client.add(&ArchiveEvent{Event: "e", OrganizationID: "org1"})
+	client.Flush()
+	// Holding both failed files would exceed the cap, so the older is dropped.
+	client.add(&ArchiveEvent{Event: "e", OrganizationID: "org2"})
+	client.Flush()
+	if n := len(client.pending); n != 1 {
+		t.Fatalf("expected 1 pending file, got %d", n)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	client.Close()
+
+	if len(store.keys) != 1 || !strings.Contains(store.keys[0], "org=org2/") {
+		t.Errorf("expected only the newer file uploaded, got %v", store.keys)
+	}
+}
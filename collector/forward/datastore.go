@@ -5,17 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
 )
 
 type DatastoreConfig struct {
+	Name          string // metrics label; defaults to "datastore_api"
 	Endpoint      string // e.g. http://datastore.hanzo.svc:8080/api/v1/ingest
 	APIKey        string
 	BatchSize     int
 	FlushInterval time.Duration
 	Timeout       time.Duration
+	Logger        *slog.Logger
 }
 
 type DatastoreEvent struct {
@@ -30,6 +35,7 @@ type DatastoreEvent struct {
 }
 
 type DatastoreClient struct {
+	logger     *slog.Logger
 	config     *DatastoreConfig
 	httpClient *http.Client
 	eventQueue chan *DatastoreEvent
@@ -39,6 +45,9 @@ type DatastoreClient struct {
 }
 
 func NewDatastoreClient(config *DatastoreConfig) *DatastoreClient {
+	if config.Name == "" {
+		config.Name = "datastore_api"
+	}
 	if config.BatchSize == 0 {
 		config.BatchSize = 100
 	}
@@ -51,7 +60,8 @@ func NewDatastoreClient(config *DatastoreConfig) *DatastoreClient {
 
 	c := &DatastoreClient{
 		config:     config,
-		httpClient: &http.Client{Timeout: config.Timeout},
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		httpClient: newHTTPClient(config.Timeout),
 		eventQueue: make(chan *DatastoreEvent, config.BatchSize*10),
 	}
 
@@ -78,6 +88,7 @@ func (c *DatastoreClient) Send(event *DatastoreEvent) error {
 
 	select {
 	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
 		return nil
 	default:
 		return c.sendEvents([]*DatastoreEvent{event})
@@ -85,6 +96,12 @@ func (c *DatastoreClient) Send(event *DatastoreEvent) error {
 }
 
 func (c *DatastoreClient) sendEvents(events []*DatastoreEvent) error {
+	return instrumentSend(c.logger, c.config.Name, len(events), func(ctx context.Context) error {
+		return c.send(ctx, events)
+	})
+}
+
+func (c *DatastoreClient) send(ctx context.Context, events []*DatastoreEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
@@ -94,7 +111,7 @@ func (c *DatastoreClient) sendEvents(events []*DatastoreEvent) error {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.config.Endpoint, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
@@ -131,6 +148,7 @@ func (c *DatastoreClient) processBatch() {
 				}
 				return
 			}
+			observeQueue(c.config.Name, len(c.eventQueue))
 			batch = append(batch, event)
 			if len(batch) >= c.config.BatchSize {
 				c.sendEvents(batch)
@@ -0,0 +1,352 @@
+package forward
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// FileConfig holds rotating JSON lines file configuration.
+type FileConfig struct {
+	Name string // metrics label; defaults to "file"
+	Path string // active file, e.g. /var/log/collector/events.jsonl
+
+	// The active file is rotated once it reaches MaxSize bytes or has been
+	// open for MaxAge (0 disables time-based rotation).
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxFiles is the number of rotated files kept; older ones are deleted.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Logger        *slog.Logger
+}
+
+// FileClient appends events as JSON lines to a local file and rotates it.
+// Rotated files are named <name>-<UTC time><ext>[.gz] next to the active file.
+type FileClient struct {
+	logger     *slog.Logger
+	config     *FileConfig
+	eventQueue chan json.RawMessage
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+
+	fileMu sync.Mutex
+	file   *os.File
+	out    *bufio.Writer
+	size   int64
+	opened time.Time
+
+	// compress tracks background compression; compressMu runs one
+	// compress-and-prune pass at a time.
+	compress   sync.WaitGroup
+	compressMu sync.Mutex
+}
+
+// NewFileClient opens (or creates) the active file and starts the writer.
+func NewFileClient(config *FileConfig) (*FileClient, error) {
+	if config.Name == "" {
+		config.Name = "file"
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = 100 << 20
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = 7
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Second
+	}
+
+	c := &FileClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		eventQueue: make(chan json.RawMessage, config.BatchSize*10),
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c, nil
+}
+
+// Send queues an encoded event, writing it directly if the queue is full or closed.
+func (c *FileClient) Send(event json.RawMessage) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.writeEvents([]json.RawMessage{event})
+	}
+
+	select {
+	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.writeEvents([]json.RawMessage{event})
+	}
+}
+
+func (c *FileClient) writeEvents(events []json.RawMessage) error {
+	return instrumentSend(c.logger, c.config.Name, len(events), func(ctx context.Context) error {
+		c.fileMu.Lock()
+		defer c.fileMu.Unlock()
+
+		if c.file == nil {
+			return fmt.Errorf("%s is closed", c.config.Path)
+		}
+		for _, event := range events {
+			if c.size > 0 && c.size+int64(len(event))+1 > c.config.MaxSize {
+				if err := c.rotate(); err != nil {
+					return err
+				}
+			}
+			n, err := c.out.Write(event)
+			c.size += int64(n)
+			if err != nil {
+				return fmt.Errorf("write %s: %w", c.config.Path, err)
+			}
+			if err := c.out.WriteByte('\n'); err != nil {
+				return fmt.Errorf("write %s: %w", c.config.Path, err)
+			}
+			c.size++
+		}
+		return c.out.Flush()
+	})
+}
+
+// open opens the active file for appending. Callers hold fileMu.
+func (c *FileClient) open() error {
+	f, err := os.OpenFile(c.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", c.config.Path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat %s: %w", c.config.Path, err)
+	}
+	c.file = f
+	c.out = bufio.NewWriter(f)
+	c.size = info.Size()
+	c.opened = time.Now()
+	return nil
+}
+
+// rotate renames the active file aside, opens a new one and prunes old
+// rotated files. Callers hold fileMu.
+func (c *FileClient) rotate() error {
+	if err := c.out.Flush(); err != nil {
+		return fmt.Errorf("flush %s: %w", c.config.Path, err)
+	}
+	if err := c.file.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", c.config.Path, err)
+	}
+	c.file = nil
+
+	rotated := c.rotatedName(time.Now())
+	if err := os.Rename(c.config.Path, rotated); err != nil {
+		// Keep appending to the current file rather than losing events.
+		if oerr := c.open(); oerr != nil {
+			return oerr
+		}
+		return fmt.Errorf("rotate %s: %w", c.config.Path, err)
+	}
+	if err := c.open(); err != nil {
+		return err
+	}
+
+	if c.config.Compress {
+		c.compress.Add(1)
+		go func() {
+			defer c.compress.Done()
+			c.compressMu.Lock()
+			defer c.compressMu.Unlock()
+			if err := gzipFile(rotated); err != nil {
+				c.logger.Error("compress rotated file failed", "error", err, "file", rotated)
+			}
+			c.prune()
+		}()
+	} else {
+		c.prune()
+	}
+	return nil
+}
+
+func (c *FileClient) rotatedName(t time.Time) string {
+	ext := filepath.Ext(c.config.Path)
+	base := strings.TrimSuffix(c.config.Path, ext)
+	return base + "-" + t.UTC().Format("20060102T150405.000") + ext
+}
+
+// prune deletes the oldest rotated files beyond MaxFiles.
+func (c *FileClient) prune() {
+	ext := filepath.Ext(c.config.Path)
+	base := strings.TrimSuffix(c.config.Path, ext)
+	matches, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, m := range matches {
+		if strings.HasSuffix(m, ext) || strings.HasSuffix(m, ext+".gz") {
+			rotated = append(rotated, m)
+		}
+	}
+	// Names embed the rotation time, so lexical order is chronological.
+	sort.Strings(rotated)
+	for len(rotated) > c.config.MaxFiles {
+		if err := os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			c.logger.Error("remove rotated file failed", "error", err, "file", rotated[0])
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rotateIfExpired rotates a non-empty active file older than MaxAge.
+func (c *FileClient) rotateIfExpired() {
+	if c.config.MaxAge <= 0 {
+		return
+	}
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+	if c.file != nil && c.size > 0 && time.Since(c.opened) >= c.config.MaxAge {
+		if err := c.rotate(); err != nil {
+			c.logger.Error("rotate failed", "error", err)
+		}
+	}
+}
+
+func (c *FileClient) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]json.RawMessage, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.writeEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, event)
+			if len(batch) >= c.config.BatchSize {
+				c.writeEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.writeEvents(batch)
+				batch = batch[:0]
+			}
+			c.rotateIfExpired()
+		}
+	}
+}
+
+// Flush writes all queued events.
+func (c *FileClient) Flush() error {
+	batch := make([]json.RawMessage, 0, c.config.BatchSize)
+	for {
+		select {
+		case event := <-c.eventQueue:
+			batch = append(batch, event)
+		default:
+			if len(batch) > 0 {
+				return c.writeEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close writes queued events and closes the active file.
+func (c *FileClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	c.compress.Wait()
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.out.Flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	c.file = nil
+	return err
+}
@@ -0,0 +1,120 @@
+package forward
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(gz)
+	} else {
+		scanner = bufio.NewScanner(f)
+	}
+	n := 0
+	for scanner.Scan() {
+		if !json.Valid(scanner.Bytes()) {
+			t.Errorf("invalid line %q", scanner.Text())
+		}
+		n++
+	}
+	return n
+}
+
+func TestFileClient_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	client, err := NewFileClient(&FileConfig{Path: path, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(json.RawMessage(`{"event":"a"}`))
+	client.Send(json.RawMessage(`{"event":"b"}`))
+	client.Close()
+
+	// Reopening appends to the existing file.
+	client, err = NewFileClient(&FileConfig{Path: path, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(json.RawMessage(`{"event":"c"}`))
+	client.Close()
+
+	if n := countLines(t, path); n != 3 {
+		t.Errorf("expected 3 lines, got %d", n)
+	}
+}
+
+func TestFileClient_RotateAndRetain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	event := json.RawMessage(`{"event":"order_completed"}`)
+	client, err := NewFileClient(&FileConfig{
+		Path:          path,
+		MaxSize:       int64(len(event)+1) * 2,
+		MaxFiles:      2,
+		Compress:      true,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		client.writeEvents([]json.RawMessage{event})
+		// Rotated names have millisecond resolution.
+		time.Sleep(2 * time.Millisecond)
+	}
+	client.Close()
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz"))
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 retained gzipped files, got %v", rotated)
+	}
+	for _, f := range rotated {
+		if n := countLines(t, f); n != 2 {
+			t.Errorf("%s: expected 2 lines, got %d", f, n)
+		}
+	}
+	if n := countLines(t, path); n != 1 {
+		t.Errorf("active file: expected 1 line, got %d", n)
+	}
+	if plain, _ := filepath.Glob(filepath.Join(dir, "events-*.jsonl")); len(plain) != 0 {
+		t.Errorf("uncompressed rotated files left behind: %v", plain)
+	}
+}
+
+func TestFileClient_RotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	client, err := NewFileClient(&FileConfig{Path: path, MaxAge: time.Millisecond, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.writeEvents([]json.RawMessage{json.RawMessage(`{}`)})
+	time.Sleep(5 * time.Millisecond)
+	client.rotateIfExpired()
+	client.Close()
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "events-*.jsonl"))
+	if len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file, got %v", rotated)
+	}
+}
@@ -0,0 +1,287 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// GA4MaxEvents is the Measurement Protocol limit of events per request.
+const GA4MaxEvents = 25
+
+// GA4Config holds GA4 Measurement Protocol configuration.
+type GA4Config struct {
+	Name     string // metrics label; defaults to "ga4"
+	Endpoint string // defaults to https://www.google-analytics.com
+	// Debug sends to the validation endpoint and fails on validation messages.
+	// Events sent in debug mode are not recorded by GA4.
+	Debug         bool
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *slog.Logger
+}
+
+// GA4Event is a single Measurement Protocol event.
+type GA4Event struct {
+	Name            string                 `json:"name"`
+	Params          map[string]interface{} `json:"params,omitempty"`
+	TimestampMicros int64                  `json:"timestamp_micros,omitempty"`
+}
+
+// GA4Message is an event addressed to a GA4 property on behalf of a client.
+type GA4Message struct {
+	MeasurementID string
+	APISecret     string
+	ClientID      string
+	UserID        string
+	Event         GA4Event
+}
+
+// ga4Payload is the Measurement Protocol request body.
+type ga4Payload struct {
+	ClientID string     `json:"client_id"`
+	UserID   string     `json:"user_id,omitempty"`
+	Events   []GA4Event `json:"events"`
+}
+
+// ga4Validation is the debug endpoint response.
+type ga4Validation struct {
+	ValidationMessages []struct {
+		FieldPath      string `json:"fieldPath"`
+		Description    string `json:"description"`
+		ValidationCode string `json:"validationCode"`
+	} `json:"validationMessages"`
+}
+
+// GA4Client sends events to the GA4 Measurement Protocol.
+type GA4Client struct {
+	logger     *slog.Logger
+	config     *GA4Config
+	httpClient *http.Client
+	eventQueue chan *GA4Message
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// NewGA4Client creates a new GA4 forwarding client.
+func NewGA4Client(config *GA4Config) *GA4Client {
+	if config.Name == "" {
+		config.Name = "ga4"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://www.google-analytics.com"
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	c := &GA4Client{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		httpClient: newHTTPClient(config.Timeout),
+		eventQueue: make(chan *GA4Message, config.BatchSize*10),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c
+}
+
+// Send queues a message, falling back to synchronous send if the queue is full or closed.
+func (c *GA4Client) Send(msg *GA4Message) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.sendEvents([]*GA4Message{msg})
+	}
+
+	select {
+	case c.eventQueue <- msg:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.sendEvents([]*GA4Message{msg})
+	}
+}
+
+// ga4Request is one Measurement Protocol request: events for a single
+// property and client, at most GA4MaxEvents of them.
+type ga4Request struct {
+	measurementID string
+	apiSecret     string
+	payload       ga4Payload
+}
+
+// groupGA4 splits messages into requests. Every event in a request shares
+// client_id and user_id, so those are part of the grouping key.
+func groupGA4(msgs []*GA4Message) []*ga4Request {
+	type key struct {
+		measurementID, apiSecret, clientID, userID string
+	}
+	open := make(map[key]*ga4Request)
+	var out []*ga4Request
+	for _, m := range msgs {
+		k := key{m.MeasurementID, m.APISecret, m.ClientID, m.UserID}
+		req := open[k]
+		if req == nil || len(req.payload.Events) >= GA4MaxEvents {
+			req = &ga4Request{
+				measurementID: m.MeasurementID,
+				apiSecret:     m.APISecret,
+				payload: ga4Payload{
+					ClientID: m.ClientID,
+					UserID:   m.UserID,
+				},
+			}
+			open[k] = req
+			out = append(out, req)
+		}
+		req.payload.Events = append(req.payload.Events, m.Event)
+	}
+	return out
+}
+
+func (c *GA4Client) sendEvents(msgs []*GA4Message) error {
+	return instrumentSend(c.logger, c.config.Name, len(msgs), func(ctx context.Context) error {
+		var errs []error
+		for _, req := range groupGA4(msgs) {
+			if err := c.post(ctx, req); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (c *GA4Client) post(ctx context.Context, r *ga4Request) error {
+	body, err := json.Marshal(r.payload)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	path := "/mp/collect"
+	if c.config.Debug {
+		path = "/debug/mp/collect"
+	}
+	query := url.Values{"measurement_id": {r.measurementID}, "api_secret": {r.apiSecret}}
+	endpoint := strings.TrimRight(c.config.Endpoint, "/") + path + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Don't leak the API secret from the URL into logs.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("send: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ga4 error: status %d (measurement_id %s)", resp.StatusCode, r.measurementID)
+	}
+	if !c.config.Debug {
+		return nil
+	}
+
+	var v ga4Validation
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("decode validation response: %w", err)
+	}
+	if len(v.ValidationMessages) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v.ValidationMessages))
+	for i, m := range v.ValidationMessages {
+		msgs[i] = fmt.Sprintf("%s: %s (%s)", m.FieldPath, m.Description, m.ValidationCode)
+	}
+	return fmt.Errorf("ga4 validation (measurement_id %s): %s", r.measurementID, strings.Join(msgs, "; "))
+}
+
+func (c *GA4Client) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]*GA4Message, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.sendEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, msg)
+			if len(batch) >= c.config.BatchSize {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Flush sends all queued events.
+func (c *GA4Client) Flush() error {
+	batch := make([]*GA4Message, 0, c.config.BatchSize)
+	for {
+		select {
+		case msg := <-c.eventQueue:
+			batch = append(batch, msg)
+		default:
+			if len(batch) > 0 {
+				return c.sendEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close gracefully shuts down the client.
+func (c *GA4Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,70 @@
+package forward
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGA4Client_BatchLimit(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []ga4Payload
+	var queries []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mp/collect" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var p ga4Payload
+		json.Unmarshal(body, &p)
+		mu.Lock()
+		payloads = append(payloads, p)
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := NewGA4Client(&GA4Config{Endpoint: srv.URL, BatchSize: 100, FlushInterval: time.Hour})
+	for i := 0; i < 30; i++ {
+		client.Send(&GA4Message{MeasurementID: "G-1", APISecret: "s", ClientID: "c1", Event: GA4Event{Name: "page_view"}})
+	}
+	client.Send(&GA4Message{MeasurementID: "G-2", APISecret: "s", ClientID: "c1", Event: GA4Event{Name: "purchase"}})
+	client.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 3 {
+		t.Fatalf("expected 3 requests (25+5 for G-1, 1 for G-2), got %d", len(payloads))
+	}
+	if len(payloads[0].Events) != GA4MaxEvents || len(payloads[1].Events) != 5 {
+		t.Errorf("unexpected split: %d, %d", len(payloads[0].Events), len(payloads[1].Events))
+	}
+	if !strings.Contains(queries[2], "measurement_id=G-2") {
+		t.Errorf("unexpected query %q", queries[2])
+	}
+}
+
+func TestGA4Client_Debug(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/mp/collect" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"validationMessages":[{"fieldPath":"events[0].name","description":"bad name","validationCode":"NAME_INVALID"}]}`))
+	}))
+	defer srv.Close()
+
+	client := NewGA4Client(&GA4Config{Endpoint: srv.URL, Debug: true, FlushInterval: time.Hour})
+	defer client.Close()
+
+	err := client.sendEvents([]*GA4Message{{MeasurementID: "G-1", APISecret: "s", ClientID: "c", Event: GA4Event{Name: "1bad"}}})
+	if err == nil || !strings.Contains(err.Error(), "NAME_INVALID") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
@@ -0,0 +1,531 @@
+package forward
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// GoogleAdsMaxConversions is the API limit of conversions per upload.
+const GoogleAdsMaxConversions = 2000
+
+// GoogleAdsConfig holds Google Ads offline conversion upload configuration.
+type GoogleAdsConfig struct {
+	Name       string // metrics label; defaults to "google_ads"
+	Endpoint   string // defaults to https://googleads.googleapis.com
+	TokenURL   string // defaults to https://oauth2.googleapis.com/token
+	APIVersion string // defaults to v17
+
+	DeveloperToken  string
+	ClientID        string
+	ClientSecret    string
+	RefreshToken    string
+	LoginCustomerID string // manager account, when uploading on behalf of clients
+
+	// Failed uploads are retried MaxRetries times (default 3) with
+	// exponential backoff starting at RetryBackoff (default 1s).
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// DedupSize is how many uploaded conversions are remembered to skip
+	// duplicates (default 100000).
+	DedupSize int
+	// OnResult, if set, receives the outcome of every conversion.
+	OnResult func(GoogleAdsResult)
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *slog.Logger
+}
+
+// GoogleAdsConversion is a click conversion to upload.
+type GoogleAdsConversion struct {
+	CustomerID string `json:"customer_id"`
+	// RefreshToken overrides the configured refresh token for this customer.
+	RefreshToken     string    `json:"-"`
+	ConversionAction string    `json:"conversion_action"` // resource name or numeric ID
+	GCLID            string    `json:"gclid"`
+	DateTime         time.Time `json:"conversion_date_time"`
+	Value            float64   `json:"value,omitempty"`
+	Currency         string    `json:"currency,omitempty"`
+	OrderID          string    `json:"order_id,omitempty"`
+}
+
+// Google Ads upload outcomes.
+const (
+	GoogleAdsUploaded  = "uploaded"
+	GoogleAdsFailed    = "failed"
+	GoogleAdsDuplicate = "duplicate"
+)
+
+// GoogleAdsResult records the outcome of one conversion upload.
+type GoogleAdsResult struct {
+	Conversion GoogleAdsConversion `json:"conversion"`
+	Status     string              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+	Attempts   int                 `json:"attempts"`
+	At         time.Time           `json:"at"`
+}
+
+// GoogleAdsClient uploads offline click conversions to the Google Ads API.
+type GoogleAdsClient struct {
+	logger     *slog.Logger
+	config     *GoogleAdsConfig
+	httpClient *http.Client
+	eventQueue chan *GoogleAdsConversion
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+
+	tokenMu sync.Mutex
+	tokens  map[string]oauthToken // by refresh token
+
+	seenMu sync.Mutex
+	seen   map[string]*list.Element
+	order  *list.List
+}
+
+type oauthToken struct {
+	accessToken string
+	expiry      time.Time
+}
+
+// NewGoogleAdsClient creates a new Google Ads conversion upload client.
+func NewGoogleAdsClient(config *GoogleAdsConfig) *GoogleAdsClient {
+	if config.Name == "" {
+		config.Name = "google_ads"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://googleads.googleapis.com"
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = "https://oauth2.googleapis.com/token"
+	}
+	if config.APIVersion == "" {
+		config.APIVersion = "v17"
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.DedupSize == 0 {
+		config.DedupSize = 100000
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 200
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 30 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	c := &GoogleAdsClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		httpClient: newHTTPClient(config.Timeout),
+		eventQueue: make(chan *GoogleAdsConversion, config.BatchSize*10),
+		tokens:     make(map[string]oauthToken),
+		seen:       make(map[string]*list.Element),
+		order:      list.New(),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c
+}
+
+// Send queues a conversion, falling back to synchronous upload if the
+// queue is full or closed. Conversions already uploaded are skipped.
+func (c *GoogleAdsClient) Send(conv *GoogleAdsConversion) error {
+	if !c.markSeen(dedupKey(conv)) {
+		c.record(conv, GoogleAdsDuplicate, nil, 0)
+		return nil
+	}
+
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.sendEvents([]*GoogleAdsConversion{conv})
+	}
+
+	select {
+	case c.eventQueue <- conv:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.sendEvents([]*GoogleAdsConversion{conv})
+	}
+}
+
+// dedupKey identifies a conversion: by order ID when there is one (as
+// Google Ads does), otherwise by click and time.
+func dedupKey(conv *GoogleAdsConversion) string {
+	if conv.OrderID != "" {
+		return conv.CustomerID + "|" + conv.ConversionAction + "|order:" + conv.OrderID
+	}
+	return conv.CustomerID + "|" + conv.ConversionAction + "|" + conv.GCLID + "|" + conv.DateTime.UTC().Format(time.RFC3339)
+}
+
+// markSeen records key and reports whether it was new.
+func (c *GoogleAdsClient) markSeen(key string) bool {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+	if el, ok := c.seen[key]; ok {
+		c.order.MoveToFront(el)
+		return false
+	}
+	c.seen[key] = c.order.PushFront(key)
+	for c.order.Len() > c.config.DedupSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.seen, oldest.Value.(string))
+	}
+	return true
+}
+
+// forget lets a failed conversion be sent again.
+func (c *GoogleAdsClient) forget(key string) {
+	c.seenMu.Lock()
+	defer c.seenMu.Unlock()
+	if el, ok := c.seen[key]; ok {
+		c.order.Remove(el)
+		delete(c.seen, key)
+	}
+}
+
+func (c *GoogleAdsClient) record(conv *GoogleAdsConversion, status string, err error, attempts int) {
+	if status == GoogleAdsFailed {
+		c.forget(dedupKey(conv))
+	}
+	if c.config.OnResult == nil {
+		return
+	}
+	r := GoogleAdsResult{Conversion: *conv, Status: status, Attempts: attempts, At: time.Now()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	c.config.OnResult(r)
+}
+
+func (c *GoogleAdsClient) sendEvents(convs []*GoogleAdsConversion) error {
+	return instrumentSend(c.logger, c.config.Name, len(convs), func(ctx context.Context) error {
+		type account struct{ customerID, refreshToken string }
+		var order []account
+		byAccount := make(map[account][]*GoogleAdsConversion)
+		for _, conv := range convs {
+			a := account{conv.CustomerID, conv.RefreshToken}
+			if _, ok := byAccount[a]; !ok {
+				order = append(order, a)
+			}
+			byAccount[a] = append(byAccount[a], conv)
+		}
+
+		var errs []error
+		for _, a := range order {
+			pending := byAccount[a]
+			for len(pending) > 0 {
+				n := min(len(pending), GoogleAdsMaxConversions)
+				if err := c.upload(ctx, a.customerID, a.refreshToken, pending[:n]); err != nil {
+					errs = append(errs, err)
+				}
+				pending = pending[n:]
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// upload sends one request, retrying transient failures, and records the
+// result of every conversion in it.
+func (c *GoogleAdsClient) upload(ctx context.Context, customerID, refreshToken string, convs []*GoogleAdsConversion) error {
+	var (
+		failures map[int]string
+		err      error
+		attempt  int
+	)
+	backoff := c.config.RetryBackoff
+	for attempt = 1; ; attempt++ {
+		failures, err = c.post(ctx, customerID, refreshToken, convs)
+		var perm *permanentError
+		if err == nil || errors.As(err, &perm) || attempt > c.config.MaxRetries {
+			break
+		}
+		c.logger.Warn("google ads upload failed, retrying", "error", err, "attempt", attempt, "customer_id", customerID)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	failed := 0
+	for i, conv := range convs {
+		switch {
+		case err != nil:
+			c.record(conv, GoogleAdsFailed, err, attempt)
+		case failures[i] != "":
+			failed++
+			c.record(conv, GoogleAdsFailed, errors.New(failures[i]), attempt)
+		default:
+			c.record(conv, GoogleAdsUploaded, nil, attempt)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("google ads: %d of %d conversions rejected for customer %s", failed, len(convs), customerID)
+	}
+	return nil
+}
+
+// permanentError is an upload failure that retrying will not fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type googleAdsUploadRequest struct {
+	Conversions    []googleAdsClickConversion `json:"conversions"`
+	PartialFailure bool                       `json:"partialFailure"`
+}
+
+type googleAdsClickConversion struct {
+	GCLID              string  `json:"gclid"`
+	ConversionAction   string  `json:"conversionAction"`
+	ConversionDateTime string  `json:"conversionDateTime"`
+	ConversionValue    float64 `json:"conversionValue,omitempty"`
+	CurrencyCode       string  `json:"currencyCode,omitempty"`
+	OrderID            string  `json:"orderId,omitempty"`
+}
+
+// googleAdsUploadResponse holds per-conversion failures, located by their
+// index in the request.
+type googleAdsUploadResponse struct {
+	PartialFailureError *struct {
+		Message string `json:"message"`
+		Details []struct {
+			Errors []struct {
+				Message  string `json:"message"`
+				Location struct {
+					FieldPathElements []struct {
+						FieldName string `json:"fieldName"`
+						Index     *int   `json:"index"`
+					} `json:"fieldPathElements"`
+				} `json:"location"`
+			} `json:"errors"`
+		} `json:"details"`
+	} `json:"partialFailureError"`
+}
+
+func (c *GoogleAdsClient) post(ctx context.Context, customerID, refreshToken string, convs []*GoogleAdsConversion) (map[int]string, error) {
+	token, err := c.accessToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	body := googleAdsUploadRequest{PartialFailure: true}
+	for _, conv := range convs {
+		action := conv.ConversionAction
+		if !strings.HasPrefix(action, "customers/") {
+			action = "customers/" + customerID + "/conversionActions/" + action
+		}
+		body.Conversions = append(body.Conversions, googleAdsClickConversion{
+			GCLID:              conv.GCLID,
+			ConversionAction:   action,
+			ConversionDateTime: conv.DateTime.Format("2006-01-02 15:04:05-07:00"),
+			ConversionValue:    conv.Value,
+			CurrencyCode:       conv.Currency,
+			OrderID:            conv.OrderID,
+		})
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("marshal: %w", err)}
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/customers/%s:uploadClickConversions",
+		strings.TrimRight(c.config.Endpoint, "/"), c.config.APIVersion, url.PathEscape(customerID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("create request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("developer-token", c.config.DeveloperToken)
+	if c.config.LoginCustomerID != "" {
+		req.Header.Set("login-customer-id", c.config.LoginCustomerID)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("google ads error: status %d", resp.StatusCode)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &permanentError{fmt.Errorf("google ads error: status %d: %s", resp.StatusCode, truncate(string(respBody), 512))}
+	}
+
+	var parsed googleAdsUploadResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, &permanentError{fmt.Errorf("decode response: %w", err)}
+	}
+	failures := make(map[int]string)
+	if pf := parsed.PartialFailureError; pf != nil {
+		for _, d := range pf.Details {
+			for _, e := range d.Errors {
+				for _, el := range e.Location.FieldPathElements {
+					if el.FieldName == "conversions" && el.Index != nil {
+						failures[*el.Index] = e.Message
+					}
+				}
+			}
+		}
+	}
+	return failures, nil
+}
+
+// accessToken exchanges a refresh token for an access token, caching it
+// until shortly before it expires.
+func (c *GoogleAdsClient) accessToken(ctx context.Context, refreshToken string) (string, error) {
+	if refreshToken == "" {
+		refreshToken = c.config.RefreshToken
+	}
+
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if t, ok := c.tokens[refreshToken]; ok && time.Until(t.expiry) > time.Minute {
+		return t.accessToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {c.config.ClientID},
+		"client_secret": {c.config.ClientSecret},
+		"refresh_token": {refreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", &permanentError{fmt.Errorf("create token request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&tok)
+	if resp.StatusCode >= 500 {
+		return "", fmt.Errorf("token: status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return "", &permanentError{fmt.Errorf("token: status %d %s", resp.StatusCode, tok.Error)}
+	}
+	c.tokens[refreshToken] = oauthToken{
+		accessToken: tok.AccessToken,
+		expiry:      time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second),
+	}
+	return tok.AccessToken, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func (c *GoogleAdsClient) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]*GoogleAdsConversion, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case conv, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.sendEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, conv)
+			if len(batch) >= c.config.BatchSize {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Flush uploads all queued conversions.
+func (c *GoogleAdsClient) Flush() error {
+	batch := make([]*GoogleAdsConversion, 0, c.config.BatchSize)
+	for {
+		select {
+		case conv := <-c.eventQueue:
+			batch = append(batch, conv)
+		default:
+			if len(batch) > 0 {
+				return c.sendEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close gracefully shuts down the client.
+func (c *GoogleAdsClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,103 @@
+package forward
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGoogleAdsClient_Upload(t *testing.T) {
+	var mu sync.Mutex
+	var tokenRequests, uploads int
+	var got []googleAdsClickConversion
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			if r.FormValue("refresh_token") != "refresh" {
+				t.Errorf("unexpected refresh token %q", r.FormValue("refresh_token"))
+			}
+			w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+			return
+		}
+
+		if r.URL.Path != "/v17/customers/123:uploadClickConversions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer access" || r.Header.Get("developer-token") != "dev" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		uploads++
+		if uploads == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req googleAdsUploadRequest
+		json.Unmarshal(body, &req)
+		got = append(got, req.Conversions...)
+		// Reject the second conversion.
+		w.Write([]byte(`{"partialFailureError":{"message":"1 error","details":[{"errors":[{"message":"unparseable gclid",
+			"location":{"fieldPathElements":[{"fieldName":"conversions","index":1},{"fieldName":"gclid"}]}}]}]},
+			"results":[{"gclid":"g1"},{}]}`))
+	}))
+	defer srv.Close()
+
+	var results []GoogleAdsResult
+	client := NewGoogleAdsClient(&GoogleAdsConfig{
+		Endpoint:       srv.URL,
+		TokenURL:       srv.URL + "/token",
+		DeveloperToken: "dev",
+		ClientID:       "id",
+		ClientSecret:   "secret",
+		RefreshToken:   "refresh",
+		RetryBackoff:   time.Millisecond,
+		FlushInterval:  time.Hour,
+		OnResult:       func(r GoogleAdsResult) { results = append(results, r) },
+	})
+
+	at := time.Date(2026, 5, 1, 12, 30, 0, 0, time.UTC)
+	client.Send(&GoogleAdsConversion{CustomerID: "123", ConversionAction: "42", GCLID: "g1", DateTime: at, Value: 30, Currency: "USD", OrderID: "o1"})
+	client.Send(&GoogleAdsConversion{CustomerID: "123", ConversionAction: "42", GCLID: "bad", DateTime: at, OrderID: "o2"})
+	// Same order again: skipped as a duplicate.
+	client.Send(&GoogleAdsConversion{CustomerID: "123", ConversionAction: "42", GCLID: "g1", DateTime: at, OrderID: "o1"})
+	if err := client.Flush(); err == nil {
+		t.Error("expected the rejected conversion to be reported")
+	}
+	client.Close()
+
+	if uploads != 2 || tokenRequests != 1 {
+		t.Errorf("uploads = %d, token requests = %d", uploads, tokenRequests)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 conversions, got %d", len(got))
+	}
+	c := got[0]
+	if c.ConversionAction != "customers/123/conversionActions/42" || c.ConversionDateTime != "2026-05-01 12:30:00+00:00" ||
+		c.ConversionValue != 30 || c.CurrencyCode != "USD" || c.OrderID != "o1" {
+		t.Errorf("unexpected conversion %+v", c)
+	}
+
+	status := map[string]string{}
+	for _, r := range results {
+		status[r.Conversion.OrderID+"/"+r.Status] = r.Error
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	if _, ok := status["o1/"+GoogleAdsDuplicate]; !ok {
+		t.Errorf("expected o1 duplicate, got %v", status)
+	}
+	if _, ok := status["o1/"+GoogleAdsUploaded]; !ok {
+		t.Errorf("expected o1 uploaded, got %v", status)
+	}
+	if status["o2/"+GoogleAdsFailed] != "unparseable gclid" {
+		t.Errorf("expected o2 failed, got %v", status)
+	}
+}
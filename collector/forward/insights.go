@@ -5,18 +5,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
 )
 
 // InsightsConfig holds Insights forwarding configuration.
 type InsightsConfig struct {
+	Name          string // metrics label; defaults to "insights"
 	Endpoint      string
 	APIKey        string
 	BatchSize     int
 	FlushInterval time.Duration
 	Timeout       time.Duration
+	Logger        *slog.Logger
 }
 
 // InsightsEvent represents an event to forward to Insights.
@@ -30,6 +35,7 @@ type InsightsEvent struct {
 
 // InsightsClient forwards events to Hanzo Insights.
 type InsightsClient struct {
+	logger     *slog.Logger
 	config     *InsightsConfig
 	httpClient *http.Client
 	eventQueue chan *InsightsEvent
@@ -40,6 +46,9 @@ type InsightsClient struct {
 
 // NewInsightsClient creates a new Insights forwarding client.
 func NewInsightsClient(config *InsightsConfig) *InsightsClient {
+	if config.Name == "" {
+		config.Name = "insights"
+	}
 	if config.BatchSize == 0 {
 		config.BatchSize = 100
 	}
@@ -52,7 +61,8 @@ func NewInsightsClient(config *InsightsConfig) *InsightsClient {
 
 	c := &InsightsClient{
 		config:     config,
-		httpClient: &http.Client{Timeout: config.Timeout},
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		httpClient: newHTTPClient(config.Timeout),
 		eventQueue: make(chan *InsightsEvent, config.BatchSize*10),
 	}
 
@@ -79,6 +89,7 @@ func (c *InsightsClient) Capture(event *InsightsEvent) error {
 
 	select {
 	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
 		return nil
 	default:
 		return c.sendEvents([]*InsightsEvent{event})
@@ -86,6 +97,12 @@ func (c *InsightsClient) Capture(event *InsightsEvent) error {
 }
 
 func (c *InsightsClient) sendEvents(events []*InsightsEvent) error {
+	return instrumentSend(c.logger, c.config.Name, len(events), func(ctx context.Context) error {
+		return c.send(ctx, events)
+	})
+}
+
+func (c *InsightsClient) send(ctx context.Context, events []*InsightsEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
@@ -107,7 +124,7 @@ func (c *InsightsClient) sendEvents(events []*InsightsEvent) error {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.config.Endpoint+"/batch/", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint+"/batch/", bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
@@ -141,6 +158,7 @@ func (c *InsightsClient) processBatch() {
 				}
 				return
 			}
+			observeQueue(c.config.Name, len(c.eventQueue))
 			batch = append(batch, event)
 			if len(batch) >= c.config.BatchSize {
 				c.sendEvents(batch)
@@ -0,0 +1,53 @@
+package forward
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hanzoai/analytics/collector/metrics"
+	"github.com/hanzoai/analytics/collector/tracing"
+)
+
+// newHTTPClient returns an HTTP client whose requests are traced and carry
+// trace context to the receiving service.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+// instrumentSend runs send for n events inside a span, records its latency
+// and outcome under the forwarder name, and logs failures.
+func instrumentSend(logger *slog.Logger, name string, n int, send func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(context.Background(), "forward.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("collector.forwarder", name), tracing.Count(n)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := send(ctx)
+	tracing.RecordError(span, err)
+
+	metrics.ForwarderSendDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ForwarderErrors.WithLabelValues(name).Inc()
+		metrics.ForwarderEvents.WithLabelValues(name, "error").Add(float64(n))
+		logger.Error("forward send failed", "error", err, "batch_size", n)
+		return err
+	}
+	metrics.ForwarderEvents.WithLabelValues(name, "ok").Add(float64(n))
+	return nil
+}
+
+// observeQueue records the current depth of a forwarder queue.
+func observeQueue(name string, depth int) {
+	metrics.ForwarderQueueDepth.WithLabelValues(name).Set(float64(depth))
+}
@@ -0,0 +1,350 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// Kafka acks levels.
+const (
+	KafkaAcksNone = "none"
+	KafkaAcksOne  = "one"
+	KafkaAcksAll  = "all"
+)
+
+// KafkaTopicRoute sends events whose name matches one of Events (names or
+// glob patterns such as "ai.*") to Topic.
+type KafkaTopicRoute struct {
+	Events []string
+	Topic  string
+}
+
+// KafkaConfig holds Kafka producer configuration.
+type KafkaConfig struct {
+	Name    string // metrics label; defaults to "kafka"
+	Brokers []string
+	// Topic receives events that match no route in Topics.
+	Topic  string
+	Topics []KafkaTopicRoute
+
+	Acks        string // none, one (default) or all
+	Compression string // none (default), gzip, snappy, lz4 or zstd
+
+	SASLMechanism string // plain, scram-sha-256 or scram-sha-512
+	Username      string
+	Password      string
+
+	TLS                   bool
+	TLSCAFile             string
+	TLSInsecureSkipVerify bool
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *slog.Logger
+
+	// Producer replaces the broker connection, e.g. with an in-memory stand-in.
+	Producer KafkaProducer
+}
+
+// KafkaMessage is a single record to produce.
+type KafkaMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer writes messages to a Kafka cluster.
+type KafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...KafkaMessage) error
+	Close() error
+}
+
+// KafkaClient produces batches of messages to Kafka.
+type KafkaClient struct {
+	logger     *slog.Logger
+	config     *KafkaConfig
+	producer   KafkaProducer
+	eventQueue chan KafkaMessage
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// NewKafkaClient creates a new Kafka forwarding client.
+func NewKafkaClient(config *KafkaConfig) (*KafkaClient, error) {
+	if config.Name == "" {
+		config.Name = "kafka"
+	}
+	if config.Acks == "" {
+		config.Acks = KafkaAcksOne
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	producer := config.Producer
+	if producer == nil {
+		w, err := newKafkaWriter(config)
+		if err != nil {
+			return nil, err
+		}
+		producer = w
+	}
+
+	c := &KafkaClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		producer:   producer,
+		eventQueue: make(chan KafkaMessage, config.BatchSize*10),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c, nil
+}
+
+// TopicFor returns the topic for an event name: the first matching route,
+// otherwise the default topic.
+func (c *KafkaClient) TopicFor(event string) string {
+	for _, route := range c.config.Topics {
+		for _, pattern := range route.Events {
+			if pattern == event {
+				return route.Topic
+			}
+			if ok, _ := path.Match(pattern, event); ok {
+				return route.Topic
+			}
+		}
+	}
+	return c.config.Topic
+}
+
+// Send queues a message, falling back to synchronous send if the queue is full or closed.
+func (c *KafkaClient) Send(msg KafkaMessage) error {
+	if msg.Topic == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.sendEvents([]KafkaMessage{msg})
+	}
+
+	select {
+	case c.eventQueue <- msg:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.sendEvents([]KafkaMessage{msg})
+	}
+}
+
+func (c *KafkaClient) sendEvents(msgs []KafkaMessage) error {
+	return instrumentSend(c.logger, c.config.Name, len(msgs), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+		return c.producer.WriteMessages(ctx, msgs...)
+	})
+}
+
+func (c *KafkaClient) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]KafkaMessage, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.sendEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, msg)
+			if len(batch) >= c.config.BatchSize {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Flush sends all queued messages.
+func (c *KafkaClient) Flush() error {
+	batch := make([]KafkaMessage, 0, c.config.BatchSize)
+	for {
+		select {
+		case msg := <-c.eventQueue:
+			batch = append(batch, msg)
+		default:
+			if len(batch) > 0 {
+				return c.sendEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close flushes queued messages and closes the producer.
+func (c *KafkaClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	return c.producer.Close()
+}
+
+// kafkaWriter adapts kafka.Writer to KafkaProducer.
+type kafkaWriter struct {
+	w *kafka.Writer
+}
+
+func newKafkaWriter(config *KafkaConfig) (*kafkaWriter, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers")
+	}
+
+	acks, err := kafkaAcks(config.Acks)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := kafkaCompression(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &kafka.Transport{DialTimeout: config.Timeout}
+	if transport.SASL, err = kafkaSASL(config); err != nil {
+		return nil, err
+	}
+	if config.TLS {
+		if transport.TLS, err = kafkaTLS(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return &kafkaWriter{w: &kafka.Writer{
+		Addr: kafka.TCP(config.Brokers...),
+		// Messages with the same key land on the same partition.
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		Compression:  compression,
+		Transport:    transport,
+		BatchSize:    config.BatchSize,
+		// Batching happens in KafkaClient; don't wait for more messages.
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: config.Timeout,
+	}}, nil
+}
+
+func (k *kafkaWriter) WriteMessages(ctx context.Context, msgs ...KafkaMessage) error {
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+	}
+	return k.w.WriteMessages(ctx, out...)
+}
+
+func (k *kafkaWriter) Close() error {
+	return k.w.Close()
+}
+
+func kafkaAcks(s string) (kafka.RequiredAcks, error) {
+	switch s {
+	case KafkaAcksNone:
+		return kafka.RequireNone, nil
+	case "", KafkaAcksOne:
+		return kafka.RequireOne, nil
+	case KafkaAcksAll:
+		return kafka.RequireAll, nil
+	}
+	return 0, fmt.Errorf("kafka: unknown acks %q", s)
+}
+
+func kafkaCompression(s string) (kafka.Compression, error) {
+	switch s {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("kafka: unknown compression %q", s)
+}
+
+func kafkaSASL(config *KafkaConfig) (sasl.Mechanism, error) {
+	switch config.SASLMechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: config.Username, Password: config.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, config.Username, config.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, config.Username, config.Password)
+	}
+	return nil, fmt.Errorf("kafka: unknown sasl mechanism %q", config.SASLMechanism)
+}
+
+func kafkaTLS(config *KafkaConfig) (*tls.Config, error) {
+	t := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TLSInsecureSkipVerify,
+	}
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kafka: no certificates in %s", config.TLSCAFile)
+		}
+		t.RootCAs = pool
+	}
+	return t, nil
+}
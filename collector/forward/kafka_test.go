@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubProducer stands in for a Kafka broker and records produced messages.
+type stubProducer struct {
+	mu      sync.Mutex
+	batches [][]KafkaMessage
+	err     error
+	closed  bool
+}
+
+func (p *stubProducer) WriteMessages(ctx context.Context, msgs ...KafkaMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, append([]KafkaMessage(nil), msgs...))
+	return nil
+}
+
+func (p *stubProducer) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return nil
+}
+
+func TestKafkaClient_Batches(t *testing.T) {
+	producer := &stubProducer{}
+	client, err := NewKafkaClient(&KafkaConfig{
+		Topic:         "events",
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Producer:      producer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		client.Send(KafkaMessage{Topic: "events", Key: []byte(key), Value: []byte(`{}`)})
+	}
+	client.Close()
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if len(producer.batches) != 2 || len(producer.batches[0]) != 2 || len(producer.batches[1]) != 1 {
+		t.Fatalf("unexpected batches: %v", producer.batches)
+	}
+	if string(producer.batches[1][0].Key) != "c" {
+		t.Errorf("expected last key c, got %q", producer.batches[1][0].Key)
+	}
+	if !producer.closed {
+		t.Error("producer not closed")
+	}
+}
+
+func TestKafkaClient_TopicFor(t *testing.T) {
+	client, err := NewKafkaClient(&KafkaConfig{
+		Topic: "events",
+		Topics: []KafkaTopicRoute{
+			{Events: []string{"ai.*"}, Topic: "ai"},
+			{Events: []string{"order_completed"}, Topic: "orders"},
+		},
+		Producer: &stubProducer{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := map[string]string{
+		"ai.completion":   "ai",
+		"order_completed": "orders",
+		"$pageview":       "events",
+	}
+	for event, want := range tests {
+		if got := client.TopicFor(event); got != want {
+			t.Errorf("TopicFor(%q) = %q, want %q", event, got, want)
+		}
+	}
+}
+
+func TestKafkaClient_SendError(t *testing.T) {
+	client, err := NewKafkaClient(&KafkaConfig{
+		Topic:    "events",
+		Producer: &stubProducer{err: errors.New("broker down")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	if err := client.Send(KafkaMessage{Topic: "events", Value: []byte(`{}`)}); err == nil {
+		t.Error("expected producer error from synchronous send after close")
+	}
+}
+
+func TestNewKafkaClient_InvalidConfig(t *testing.T) {
+	tests := []*KafkaConfig{
+		{Topic: "events"},
+		{Topic: "events", Brokers: []string{"localhost:9092"}, Acks: "most"},
+		{Topic: "events", Brokers: []string{"localhost:9092"}, Compression: "brotli"},
+		{Topic: "events", Brokers: []string{"localhost:9092"}, SASLMechanism: "kerberos"},
+	}
+	for _, config := range tests {
+		if _, err := NewKafkaClient(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
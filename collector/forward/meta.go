@@ -0,0 +1,293 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// MetaMaxEvents is the Conversions API limit of events per request.
+const MetaMaxEvents = 1000
+
+// MetaConfig holds Meta Conversions API configuration.
+type MetaConfig struct {
+	Name       string // metrics label; defaults to "meta"
+	Endpoint   string // defaults to https://graph.facebook.com
+	APIVersion string // defaults to v21.0
+	// TestEventCode routes events to the Test Events tool instead of
+	// production reporting.
+	TestEventCode string
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *slog.Logger
+}
+
+// MetaUserData identifies the person. Personal fields must already be
+// normalized and SHA-256 hashed.
+type MetaUserData struct {
+	Email           []string `json:"em,omitempty"`
+	Phone           []string `json:"ph,omitempty"`
+	FirstName       []string `json:"fn,omitempty"`
+	LastName        []string `json:"ln,omitempty"`
+	City            []string `json:"ct,omitempty"`
+	State           []string `json:"st,omitempty"`
+	Zip             []string `json:"zp,omitempty"`
+	Country         []string `json:"country,omitempty"`
+	ExternalID      []string `json:"external_id,omitempty"`
+	ClientIPAddress string   `json:"client_ip_address,omitempty"`
+	ClientUserAgent string   `json:"client_user_agent,omitempty"`
+	FBC             string   `json:"fbc,omitempty"`
+	FBP             string   `json:"fbp,omitempty"`
+}
+
+// MetaContent is one product in custom_data.contents.
+type MetaContent struct {
+	ID        string  `json:"id"`
+	Quantity  int     `json:"quantity,omitempty"`
+	ItemPrice float64 `json:"item_price,omitempty"`
+}
+
+// MetaCustomData carries commerce details.
+type MetaCustomData struct {
+	Currency    string        `json:"currency,omitempty"`
+	Value       float64       `json:"value,omitempty"`
+	OrderID     string        `json:"order_id,omitempty"`
+	ContentIDs  []string      `json:"content_ids,omitempty"`
+	Contents    []MetaContent `json:"contents,omitempty"`
+	ContentType string        `json:"content_type,omitempty"`
+	NumItems    int           `json:"num_items,omitempty"`
+	SearchQuery string        `json:"search_string,omitempty"`
+}
+
+// MetaEvent is a single server event.
+type MetaEvent struct {
+	EventName      string          `json:"event_name"`
+	EventTime      int64           `json:"event_time"`
+	EventID        string          `json:"event_id,omitempty"`
+	EventSourceURL string          `json:"event_source_url,omitempty"`
+	ActionSource   string          `json:"action_source"`
+	UserData       MetaUserData    `json:"user_data"`
+	CustomData     *MetaCustomData `json:"custom_data,omitempty"`
+}
+
+// MetaMessage is an event addressed to a pixel.
+type MetaMessage struct {
+	PixelID     string
+	AccessToken string
+	Event       MetaEvent
+}
+
+type metaPayload struct {
+	Data          []MetaEvent `json:"data"`
+	TestEventCode string      `json:"test_event_code,omitempty"`
+}
+
+type metaError struct {
+	Error struct {
+		Message   string `json:"message"`
+		Type      string `json:"type"`
+		Code      int    `json:"code"`
+		FBTraceID string `json:"fbtrace_id"`
+	} `json:"error"`
+}
+
+// MetaClient sends events to the Meta Conversions API.
+type MetaClient struct {
+	logger     *slog.Logger
+	config     *MetaConfig
+	httpClient *http.Client
+	eventQueue chan *MetaMessage
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// NewMetaClient creates a new Conversions API client.
+func NewMetaClient(config *MetaConfig) *MetaClient {
+	if config.Name == "" {
+		config.Name = "meta"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://graph.facebook.com"
+	}
+	if config.APIVersion == "" {
+		config.APIVersion = "v21.0"
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	c := &MetaClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		httpClient: newHTTPClient(config.Timeout),
+		eventQueue: make(chan *MetaMessage, config.BatchSize*10),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c
+}
+
+// Send queues a message, falling back to synchronous send if the queue is full or closed.
+func (c *MetaClient) Send(msg *MetaMessage) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.sendEvents([]*MetaMessage{msg})
+	}
+
+	select {
+	case c.eventQueue <- msg:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.sendEvents([]*MetaMessage{msg})
+	}
+}
+
+func (c *MetaClient) sendEvents(msgs []*MetaMessage) error {
+	return instrumentSend(c.logger, c.config.Name, len(msgs), func(ctx context.Context) error {
+		type pixel struct{ id, token string }
+		var order []pixel
+		byPixel := make(map[pixel][]MetaEvent)
+		for _, m := range msgs {
+			p := pixel{m.PixelID, m.AccessToken}
+			if _, ok := byPixel[p]; !ok {
+				order = append(order, p)
+			}
+			byPixel[p] = append(byPixel[p], m.Event)
+		}
+
+		var errs []error
+		for _, p := range order {
+			events := byPixel[p]
+			for len(events) > 0 {
+				n := min(len(events), MetaMaxEvents)
+				if err := c.post(ctx, p.id, p.token, events[:n]); err != nil {
+					errs = append(errs, err)
+				}
+				events = events[n:]
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (c *MetaClient) post(ctx context.Context, pixelID, token string, events []MetaEvent) error {
+	body, err := json.Marshal(metaPayload{Data: events, TestEventCode: c.config.TestEventCode})
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/events?%s", strings.TrimRight(c.config.Endpoint, "/"),
+		c.config.APIVersion, url.PathEscape(pixelID), url.Values{"access_token": {token}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Don't leak the access token from the URL into logs.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("send: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e metaError
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("meta error (pixel %s): status %d: %s (code %d, fbtrace_id %s)",
+				pixelID, resp.StatusCode, e.Error.Message, e.Error.Code, e.Error.FBTraceID)
+		}
+		return fmt.Errorf("meta error (pixel %s): status %d", pixelID, resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *MetaClient) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]*MetaMessage, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.sendEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, msg)
+			if len(batch) >= c.config.BatchSize {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Flush sends all queued events.
+func (c *MetaClient) Flush() error {
+	batch := make([]*MetaMessage, 0, c.config.BatchSize)
+	for {
+		select {
+		case msg := <-c.eventQueue:
+			batch = append(batch, msg)
+		default:
+			if len(batch) > 0 {
+				return c.sendEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close gracefully shuts down the client.
+func (c *MetaClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	return nil
+}
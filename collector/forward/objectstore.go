@@ -0,0 +1,121 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ObjectStore stores immutable objects. Put must be atomic: readers either
+// see the complete object under key or nothing.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+}
+
+// LocalStore writes objects as files below a directory.
+type LocalStore struct {
+	Dir string
+}
+
+// Put writes the object to a hidden temporary file and renames it into place.
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s: %w", key, err)
+	}
+	return nil
+}
+
+// S3Config configures an S3-compatible object store.
+type S3Config struct {
+	Endpoint string // host[:port], e.g. "s3.amazonaws.com" or "minio:9000"
+	Bucket   string
+	Region   string
+	// AccessKey and SecretKey default to the AWS_* / MINIO_* environment
+	// variables, then instance credentials.
+	AccessKey string
+	SecretKey string
+	Insecure  bool // plain HTTP
+	PathStyle bool // bucket in the path instead of the host name
+}
+
+// S3Store writes objects to an S3-compatible bucket.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store creates an S3-compatible object store.
+func NewS3Store(config *S3Config) (*S3Store, error) {
+	if config.Endpoint == "" {
+		config.Endpoint = "s3.amazonaws.com"
+	}
+
+	creds := credentials.NewStaticV4(config.AccessKey, config.SecretKey, "")
+	if config.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		})
+	}
+	lookup := minio.BucketLookupAuto
+	if config.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !config.Insecure,
+		Region:       config.Region,
+		BucketLookup: lookup,
+		Transport:    newHTTPClient(0).Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 client: %w", err)
+	}
+	return &S3Store{client: client, bucket: config.Bucket}, nil
+}
+
+// Put uploads the object in a single request, so it only becomes visible
+// once complete.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	return nil
+}
+
+var (
+	_ ObjectStore = (*LocalStore)(nil)
+	_ ObjectStore = (*S3Store)(nil)
+)
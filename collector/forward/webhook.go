@@ -0,0 +1,281 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// Webhook body formats.
+const (
+	WebhookJSONArray = "json_array" // one request per batch: [event, ...]
+	WebhookNDJSON    = "ndjson"     // one request per batch, one event per line
+	WebhookSingle    = "single"     // one request per event
+)
+
+// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>",
+// where the HMAC is computed with the shared secret over "<t>.<body>".
+const WebhookSignatureHeader = "X-Hanzo-Signature"
+
+// WebhookConfig holds generic webhook forwarding configuration.
+type WebhookConfig struct {
+	Name          string // metrics label; defaults to "webhook"
+	Endpoint      string
+	Secret        string
+	Format        string
+	Headers       map[string]string
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *slog.Logger
+}
+
+// WebhookClient POSTs signed batches of JSON events to an HTTP endpoint.
+type WebhookClient struct {
+	logger     *slog.Logger
+	config     *WebhookConfig
+	httpClient *http.Client
+	eventQueue chan json.RawMessage
+	wg         sync.WaitGroup
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// NewWebhookClient creates a new webhook forwarding client.
+func NewWebhookClient(config *WebhookConfig) *WebhookClient {
+	if config.Name == "" {
+		config.Name = "webhook"
+	}
+	if config.Format == "" {
+		config.Format = WebhookJSONArray
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	c := &WebhookClient{
+		logger:     logging.Or(config.Logger).With("subsystem", "forward", "forwarder", config.Name),
+		config:     config,
+		httpClient: newHTTPClient(config.Timeout),
+		eventQueue: make(chan json.RawMessage, config.BatchSize*10),
+	}
+
+	c.wg.Add(1)
+	go c.processBatch()
+	return c
+}
+
+// Send queues an encoded event, falling back to synchronous send if the queue is full or closed.
+func (c *WebhookClient) Send(event json.RawMessage) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return c.sendEvents([]json.RawMessage{event})
+	}
+
+	select {
+	case c.eventQueue <- event:
+		observeQueue(c.config.Name, len(c.eventQueue))
+		return nil
+	default:
+		return c.sendEvents([]json.RawMessage{event})
+	}
+}
+
+// sendEvents posts events as one body, or one request per event in single
+// format, where every event is attempted and only failed ones count as
+// errors.
+func (c *WebhookClient) sendEvents(events []json.RawMessage) error {
+	if c.config.Format == WebhookSingle {
+		var errs []error
+		for _, event := range events {
+			errs = append(errs, instrumentSend(c.logger, c.config.Name, 1, func(ctx context.Context) error {
+				return c.post(ctx, event)
+			}))
+		}
+		return errors.Join(errs...)
+	}
+	return instrumentSend(c.logger, c.config.Name, len(events), func(ctx context.Context) error {
+		return c.post(ctx, encodeWebhookBody(c.config.Format, events))
+	})
+}
+
+func encodeWebhookBody(format string, events []json.RawMessage) []byte {
+	var buf bytes.Buffer
+	if format == WebhookNDJSON {
+		for _, event := range events {
+			buf.Write(event)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes()
+	}
+	buf.WriteByte('[')
+	for i, event := range events {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(event)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+func (c *WebhookClient) post(ctx context.Context, body []byte) error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range c.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if c.config.Format == WebhookNDJSON {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(c.config.Secret, time.Now(), body))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook error: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook returns the signature header value for body sent at t.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+// VerifyWebhook checks a signature header produced by SignWebhook. It
+// rejects signatures older or newer than tolerance to prevent replays.
+func VerifyWebhook(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	if ts == "" || sig == "" {
+		return errors.New("malformed signature header")
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(webhookMAC(secret, ts, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func webhookMAC(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *WebhookClient) processBatch() {
+	defer c.wg.Done()
+
+	batch := make([]json.RawMessage, 0, c.config.BatchSize)
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.eventQueue:
+			if !ok {
+				if len(batch) > 0 {
+					c.sendEvents(batch)
+				}
+				return
+			}
+			observeQueue(c.config.Name, len(c.eventQueue))
+			batch = append(batch, event)
+			if len(batch) >= c.config.BatchSize {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.sendEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Flush sends all queued events.
+func (c *WebhookClient) Flush() error {
+	batch := make([]json.RawMessage, 0, c.config.BatchSize)
+	for {
+		select {
+		case event := <-c.eventQueue:
+			batch = append(batch, event)
+		default:
+			if len(batch) > 0 {
+				return c.sendEvents(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// Close gracefully shuts down the client.
+func (c *WebhookClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.eventQueue)
+	c.wg.Wait()
+	return nil
+}
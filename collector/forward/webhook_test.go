@@ -0,0 +1,161 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookClient_SignedBatch(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var headers []http.Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := NewWebhookClient(&WebhookConfig{
+		Endpoint:      srv.URL,
+		Secret:        "s3cret",
+		Headers:       map[string]string{"X-Team": "commerce"},
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	client.Send(json.RawMessage(`{"event":"order_completed"}`))
+	client.Send(json.RawMessage(`{"event":"ai.error"}`))
+	client.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(bodies))
+	}
+	var events []map[string]string
+	if err := json.Unmarshal(bodies[0], &events); err != nil {
+		t.Fatalf("body is not a JSON array: %v", err)
+	}
+	if len(events) != 2 || events[1]["event"] != "ai.error" {
+		t.Errorf("unexpected events: %v", events)
+	}
+	if got := headers[0].Get("X-Team"); got != "commerce" {
+		t.Errorf("X-Team = %q", got)
+	}
+	sig := headers[0].Get(WebhookSignatureHeader)
+	if err := VerifyWebhook("s3cret", sig, bodies[0], time.Minute, time.Now()); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := VerifyWebhook("wrong", sig, bodies[0], time.Minute, time.Now()); err == nil {
+		t.Error("verify accepted the wrong secret")
+	}
+}
+
+func TestWebhookClient_Formats(t *testing.T) {
+	tests := []struct {
+		format   string
+		requests int
+		lines    int
+	}{
+		{WebhookNDJSON, 1, 3},
+		{WebhookSingle, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies [][]byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				bodies = append(bodies, body)
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			client := NewWebhookClient(&WebhookConfig{
+				Endpoint:      srv.URL,
+				Format:        tt.format,
+				BatchSize:     3,
+				FlushInterval: time.Hour,
+			})
+			for i := 0; i < 3; i++ {
+				client.Send(json.RawMessage(`{"event":"e"}`))
+			}
+			client.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(bodies) != tt.requests {
+				t.Fatalf("expected %d requests, got %d", tt.requests, len(bodies))
+			}
+			lines := 0
+			scanner := bufio.NewScanner(bytes.NewReader(bodies[0]))
+			for scanner.Scan() {
+				if !json.Valid(scanner.Bytes()) {
+					t.Errorf("invalid JSON line %q", scanner.Text())
+				}
+				lines++
+			}
+			if lines != tt.lines {
+				t.Errorf("expected %d lines, got %d", tt.lines, lines)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhook_Tolerance(t *testing.T) {
+	body := []byte(`[]`)
+	sent := time.Unix(1700000000, 0)
+	sig := SignWebhook("k", sent, body)
+
+	if err := VerifyWebhook("k", sig, body, 5*time.Minute, sent.Add(time.Minute)); err != nil {
+		t.Errorf("fresh signature rejected: %v", err)
+	}
+	if err := VerifyWebhook("k", sig, body, 5*time.Minute, sent.Add(time.Hour)); err == nil {
+		t.Error("stale signature accepted")
+	}
+	if err := VerifyWebhook("k", "v1=abc", body, 5*time.Minute, sent); err == nil {
+		t.Error("malformed header accepted")
+	}
+}
+
+func TestWebhookClient_SingleTriesEveryEvent(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewWebhookClient(&WebhookConfig{Endpoint: srv.URL, Format: WebhookSingle, FlushInterval: time.Hour})
+	defer client.Close()
+	events := []json.RawMessage{[]byte(`{"n":1}`), []byte(`{"n":2}`), []byte(`{"n":3}`)}
+	err := client.sendEvents(events)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 {
+		t.Errorf("expected every event attempted after a failure, got %d requests", requests)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 1 {
+		t.Errorf("expected one failed event, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package live fans accepted events out to in-process subscribers for tailing.
+package live
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// DefaultBufferSize is the per-subscriber buffer used when none is configured.
+const DefaultBufferSize = 256
+
+// Filter selects which events a subscriber receives. Empty fields match everything.
+type Filter struct {
+	// Events lists event names to match; entries may be glob patterns (e.g. "ai.*").
+	Events []string
+	// DistinctID matches the event distinct_id exactly.
+	DistinctID string
+	// URL matches events whose URL contains this substring.
+	URL string
+}
+
+// Match reports whether the event passes the filter.
+func (f *Filter) Match(event *collector.RawEvent) bool {
+	if f.DistinctID != "" && event.DistinctID != f.DistinctID {
+		return false
+	}
+	if f.URL != "" && !strings.Contains(event.URL, f.URL) {
+		return false
+	}
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, pattern := range f.Events {
+		if pattern == event.Event {
+			return true
+		}
+		if ok, _ := path.Match(pattern, event.Event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives events for a single organization.
+type Subscription struct {
+	OrganizationID string
+	Filter         Filter
+
+	ch      chan *collector.RawEvent
+	dropped atomic.Uint64
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription is removed or the hub is closed.
+func (s *Subscription) Events() <-chan *collector.RawEvent {
+	return s.ch
+}
+
+// Dropped returns how many events were discarded because the subscriber was too slow.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Hub broadcasts events to subscribers. Delivery never blocks: when a
+// subscriber's buffer is full the event is dropped for that subscriber only.
+type Hub struct {
+	bufferSize int
+	subs       map[*Subscription]struct{}
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// NewHub creates a hub with the given per-subscriber buffer size.
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Hub{
+		bufferSize: bufferSize,
+		subs:       make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber for an organization. It returns nil if the hub is closed.
+func (h *Hub) Subscribe(orgID string, filter Filter) *Subscription {
+	s := &Subscription{
+		OrganizationID: orgID,
+		Filter:         filter,
+		ch:             make(chan *collector.RawEvent, h.bufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe removes a subscriber and closes its channel.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.ch)
+}
+
+// Subscribers returns the number of active subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Forward delivers the event to every matching subscriber without blocking.
+func (h *Hub) Forward(event *collector.RawEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subs {
+		if s.OrganizationID != event.OrganizationID || !s.Filter.Match(event) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close disconnects all subscribers.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+	return nil
+}
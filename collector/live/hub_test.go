@@ -0,0 +1,59 @@
+package live
+
+import (
+	"testing"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+func TestHub_FiltersByOrgAndEvent(t *testing.T) {
+	hub := NewHub(10)
+	defer hub.Close()
+
+	sub := hub.Subscribe("org-1", Filter{Events: []string{"ai.*"}, URL: "/chat"})
+
+	hub.Forward(&collector.RawEvent{Event: "ai.completion", OrganizationID: "org-2", URL: "https://x.com/chat"})
+	hub.Forward(&collector.RawEvent{Event: "$pageview", OrganizationID: "org-1", URL: "https://x.com/chat"})
+	hub.Forward(&collector.RawEvent{Event: "ai.completion", OrganizationID: "org-1", URL: "https://x.com/home"})
+	hub.Forward(&collector.RawEvent{Event: "ai.completion", OrganizationID: "org-1", URL: "https://x.com/chat"})
+
+	if got := len(sub.Events()); got != 1 {
+		t.Fatalf("expected 1 matching event, got %d", got)
+	}
+	event := <-sub.Events()
+	if event.OrganizationID != "org-1" || event.Event != "ai.completion" {
+		t.Errorf("unexpected event delivered: %+v", event)
+	}
+}
+
+func TestHub_SlowSubscriberDrops(t *testing.T) {
+	hub := NewHub(2)
+	defer hub.Close()
+
+	slow := hub.Subscribe("org-1", Filter{})
+	for i := 0; i < 5; i++ {
+		hub.Forward(&collector.RawEvent{Event: "$pageview", OrganizationID: "org-1"})
+	}
+
+	if got := len(slow.Events()); got != 2 {
+		t.Errorf("expected buffer of 2, got %d", got)
+	}
+	if got := slow.Dropped(); got != 3 {
+		t.Errorf("expected 3 dropped, got %d", got)
+	}
+}
+
+func TestHub_CloseEndsSubscriptions(t *testing.T) {
+	hub := NewHub(1)
+	sub := hub.Subscribe("org-1", Filter{})
+	hub.Close()
+
+	if _, ok := <-sub.Events(); ok {
+		t.Error("expected subscription channel to be closed")
+	}
+	if hub.Subscribe("org-1", Filter{}) != nil {
+		t.Error("expected Subscribe on closed hub to return nil")
+	}
+	// Unsubscribing after close must not panic.
+	hub.Unsubscribe(sub)
+}
@@ -0,0 +1,185 @@
+// Package logging builds the collector's structured slog loggers.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config configures the root logger.
+type Config struct {
+	// Level is one of debug, info, warn or error. Defaults to info.
+	Level string
+	// LevelVar, if set, is given Level and read for every record, so the
+	// level can be changed while running.
+	LevelVar *slog.LevelVar
+	// Format is json or text. Defaults to json.
+	Format string
+	Output io.Writer
+	// ErrorBurst is how many error records with the same message are logged
+	// per ErrorInterval before further ones are suppressed. Zero disables limiting.
+	ErrorBurst    int
+	ErrorInterval time.Duration
+}
+
+// DefaultConfig returns sensible defaults.
+func DefaultConfig() *Config {
+	return &Config{
+		Level:         "info",
+		Format:        "json",
+		Output:        os.Stdout,
+		ErrorBurst:    10,
+		ErrorInterval: time.Minute,
+	}
+}
+
+// New creates a logger from config.
+func New(config *Config) *slog.Logger {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	out := config.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	opts := &slog.HandlerOptions{Level: ParseLevel(config.Level)}
+	if config.LevelVar != nil {
+		config.LevelVar.Set(ParseLevel(config.Level))
+		opts.Level = config.LevelVar
+	}
+	var h slog.Handler
+	if strings.EqualFold(config.Format, "text") {
+		h = slog.NewTextHandler(out, opts)
+	} else {
+		h = slog.NewJSONHandler(out, opts)
+	}
+	if config.ErrorBurst > 0 {
+		h = NewRateLimitHandler(h, config.ErrorBurst, config.ErrorInterval)
+	}
+	return slog.New(h)
+}
+
+// ParseLevel parses a level name, defaulting to info.
+func ParseLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// Or returns logger, or the default logger if it is nil.
+func Or(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// Discard returns a logger that drops every record.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// RateLimitHandler limits error-level records so an outage does not flood
+// the output. Records are limited per message; the next record let through
+// after suppression carries a "suppressed" count.
+type RateLimitHandler struct {
+	next    slog.Handler
+	limiter *limiter
+}
+
+// NewRateLimitHandler wraps next, allowing burst error records per message per interval.
+func NewRateLimitHandler(next slog.Handler, burst int, interval time.Duration) *RateLimitHandler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &RateLimitHandler{
+		next: next,
+		limiter: &limiter{
+			burst:    burst,
+			interval: interval,
+			windows:  make(map[string]*window),
+		},
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *RateLimitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *RateLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError {
+		return h.next.Handle(ctx, r)
+	}
+	allow, suppressed := h.limiter.allow(r.Message, r.Time)
+	if !allow {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *RateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &RateLimitHandler{next: h.next.WithAttrs(attrs), limiter: h.limiter}
+}
+
+// WithGroup implements slog.Handler.
+func (h *RateLimitHandler) WithGroup(name string) slog.Handler {
+	return &RateLimitHandler{next: h.next.WithGroup(name), limiter: h.limiter}
+}
+
+type window struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+type limiter struct {
+	burst    int
+	interval time.Duration
+	windows  map[string]*window
+	mu       sync.Mutex
+}
+
+func (l *limiter) allow(key string, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.interval {
+		suppressed := 0
+		if ok {
+			suppressed = w.suppressed
+		}
+		// Bound memory: forget stale windows when a new one opens.
+		if len(l.windows) > 1000 {
+			for k, old := range l.windows {
+				if now.Sub(old.start) >= l.interval {
+					delete(l.windows, k)
+				}
+			}
+		}
+		l.windows[key] = &window{start: now, count: 1}
+		return true, suppressed
+	}
+
+	if w.count < l.burst {
+		w.count++
+		return true, 0
+	}
+	w.suppressed++
+	return false, 0
+}
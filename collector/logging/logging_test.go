@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_RateLimitsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&Config{
+		Level:         "debug",
+		Format:        "json",
+		Output:        &buf,
+		ErrorBurst:    2,
+		ErrorInterval: time.Hour,
+	}).With("subsystem", "writer")
+
+	for i := 0; i < 5; i++ {
+		logger.Error("datastore insert failed", "batch_size", i)
+	}
+	logger.Error("forward send failed")
+	logger.Info("not limited")
+	logger.Info("not limited")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 log lines, got %d:\n%s", len(lines), buf.String())
+	}
+
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("expected JSON output: %v", err)
+	}
+	if first["subsystem"] != "writer" {
+		t.Errorf("expected subsystem attribute, got %v", first["subsystem"])
+	}
+}
+
+func TestLimiter_ReportsSuppressed(t *testing.T) {
+	l := &limiter{burst: 1, interval: time.Minute, windows: make(map[string]*window)}
+	now := time.Now()
+
+	if ok, _ := l.allow("msg", now); !ok {
+		t.Fatal("expected first record to be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("msg", now); ok {
+			t.Fatal("expected record over burst to be suppressed")
+		}
+	}
+
+	ok, suppressed := l.allow("msg", now.Add(2*time.Minute))
+	if !ok || suppressed != 3 {
+		t.Errorf("expected allowed with 3 suppressed, got %v, %d", ok, suppressed)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if ParseLevel("warn").String() != "WARN" {
+		t.Error("expected warn level")
+	}
+	if ParseLevel("bogus").String() != "INFO" {
+		t.Error("expected unknown level to default to info")
+	}
+}
+
+func TestNew_LevelVar(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	logger := New(&Config{Level: "warn", LevelVar: level, Output: &buf})
+
+	logger.Info("hidden")
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Errorf("expected the level to follow the LevelVar, got %s", out)
+	}
+}
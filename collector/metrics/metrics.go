@@ -0,0 +1,300 @@
+// Package metrics exposes Prometheus metrics for the ingestion pipeline.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "collector"
+
+// Label value used once a bounded label has seen its maximum number of values.
+const OtherLabel = "__other__"
+
+// Registry holds all collector metrics. It is separate from the default
+// registry so tests and embedders do not collide with global state.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts handled requests by route, method and status.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes request latency by route, method and status.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// EventsAccepted counts events accepted by the writer.
+	EventsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_accepted_total",
+		Help:      "Events accepted for writing, by organization and event name (bounded cardinality).",
+	}, []string{"organization_id", "event"})
+
+	// EventsDropped counts events that were accepted but never persisted.
+	EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Events that were not persisted to the datastore, by reason.",
+	}, []string{"reason"})
+
+	// EventsDuplicate counts retried events acknowledged without being
+	// written again because their event ID was seen recently.
+	EventsDuplicate = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_duplicate_total",
+		Help:      "Events skipped because their client event ID was already accepted.",
+	})
+
+	// RevenueUnconverted counts events whose revenue could not be
+	// converted to the organization's base currency.
+	RevenueUnconverted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_unconverted_total",
+		Help:      "Events with revenue in a currency that has no exchange rate to the base currency.",
+	})
+
+	// TimestampsAdjusted counts event times derived from an offset,
+	// corrected for device clock skew, or clamped by policy.
+	TimestampsAdjusted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timestamps_adjusted_total",
+		Help:      "Event timestamps adjusted by the collector, by action (offset, skew_corrected, clamped).",
+	}, []string{"action"})
+
+	// BatchSize observes the number of events per datastore batch.
+	BatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_size",
+		Help:      "Events per datastore batch.",
+		Buckets:   []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000},
+	})
+
+	// FlushDuration observes how long datastore batch writes take.
+	FlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "flush_duration_seconds",
+		Help:      "Datastore batch write latency.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// InsertErrors counts failed datastore inserts.
+	InsertErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "datastore_insert_errors_total",
+		Help:      "Failed datastore insert operations.",
+	})
+
+	// ForwarderQueueDepth reports events waiting in each forwarder queue.
+	ForwarderQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forwarder_queue_depth",
+		Help:      "Events queued in each forwarder.",
+	}, []string{"forwarder"})
+
+	// ForwarderSendDuration observes forwarder send latency.
+	ForwarderSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "forwarder_send_duration_seconds",
+		Help:      "Forwarder send latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"forwarder"})
+
+	// ForwarderErrors counts failed forwarder sends.
+	ForwarderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarder_errors_total",
+		Help:      "Failed forwarder sends.",
+	}, []string{"forwarder"})
+
+	// ForwarderEvents counts events handed to each forwarder's backend.
+	ForwarderEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarder_events_total",
+		Help:      "Events sent by each forwarder, by result.",
+	}, []string{"forwarder", "result"})
+
+	// ForwarderBufferDepth reports events waiting in each forwarder's
+	// fan-out buffer, ahead of its client queue.
+	ForwarderBufferDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forwarder_buffer_depth",
+		Help:      "Events buffered for each forwarder's workers.",
+	}, []string{"forwarder"})
+
+	// ForwarderOverflow counts events dropped or spilled to disk because a
+	// forwarder's buffer was full, by outcome.
+	ForwarderOverflow = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forwarder_overflow_total",
+		Help:      "Events a full forwarder buffer dropped or spilled, by outcome.",
+	}, []string{"forwarder", "outcome"})
+
+	// InsertConcurrency reports the adaptive limit on concurrent
+	// datastore inserts.
+	InsertConcurrency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "insert_concurrency_limit",
+		Help:      "Concurrent datastore inserts currently allowed.",
+	})
+
+	// Shedding is 1 while the writer refuses events above its high watermark.
+	Shedding = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "load_shedding",
+		Help:      "1 while new events are refused because the write queue is above its high watermark.",
+	})
+
+	// CatalogDropped counts events the catalog skipped because its queue
+	// was full. Skipped events are still written.
+	CatalogDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "catalog_dropped_total",
+		Help:      "Events not added to the event catalog because its queue was full.",
+	})
+
+	// CatalogDrift counts properties first seen with a conflicting type.
+	CatalogDrift = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "catalog_drift_total",
+		Help:      "Properties observed with a new, conflicting type.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		EventsAccepted,
+		EventsDropped,
+		EventsDuplicate,
+		TimestampsAdjusted,
+		RevenueUnconverted,
+		BatchSize,
+		FlushDuration,
+		InsertErrors,
+		ForwarderQueueDepth,
+		ForwarderSendDuration,
+		ForwarderErrors,
+		ForwarderEvents,
+		ForwarderBufferDepth,
+		ForwarderOverflow,
+		InsertConcurrency,
+		Shedding,
+		CatalogDropped,
+		CatalogDrift,
+	)
+}
+
+// Handler returns the /metrics HTTP handler.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterQueueDepth exposes the writer queue depth through fn.
+func RegisterQueueDepth(fn func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Events waiting in the writer queue.",
+	}, fn))
+}
+
+// Middleware records request counts and latency per route.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		HTTPRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		HTTPDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Organization and event labels are bounded so a misbehaving client
+// cannot create unbounded time series. The pair is bounded too: 1000
+// organizations each sending 500 event names would otherwise be 500k series.
+var acceptedLabels = newPairLabels(1000, 500, 10000)
+
+// EventAccepted records an accepted event.
+func EventAccepted(orgID, event string) {
+	EventsAccepted.WithLabelValues(acceptedLabels.Values(orgID, event)).Inc()
+}
+
+// pairLabels bounds two labels and the combinations of them. Once the
+// combinations are exhausted, new ones keep their first label and map the
+// second to OtherLabel.
+type pairLabels struct {
+	first, second, pairs *BoundedLabel
+}
+
+func newPairLabels(first, second, pairs int) *pairLabels {
+	return &pairLabels{
+		first:  NewBoundedLabel(first),
+		second: NewBoundedLabel(second),
+		pairs:  NewBoundedLabel(pairs),
+	}
+}
+
+// Values returns the label values to record a and b under.
+func (p *pairLabels) Values(a, b string) (string, string) {
+	a, b = p.first.Value(a), p.second.Value(b)
+	if b != OtherLabel && p.pairs.Value(a+"\x00"+b) == OtherLabel {
+		b = OtherLabel
+	}
+	return a, b
+}
+
+// BoundedLabel admits up to max distinct label values and maps the rest to OtherLabel.
+type BoundedLabel struct {
+	max  int
+	seen map[string]struct{}
+	mu   sync.RWMutex
+}
+
+// NewBoundedLabel creates a label limiter admitting max distinct values.
+func NewBoundedLabel(max int) *BoundedLabel {
+	return &BoundedLabel{max: max, seen: make(map[string]struct{})}
+}
+
+// Value returns v if it has been admitted, otherwise OtherLabel.
+func (b *BoundedLabel) Value(v string) string {
+	b.mu.RLock()
+	_, ok := b.seen[v]
+	b.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seen[v]; ok {
+		return v
+	}
+	if len(b.seen) >= b.max {
+		return OtherLabel
+	}
+	b.seen[v] = struct{}{}
+	return v
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import "testing"
+
+func TestBoundedLabel(t *testing.T) {
+	b := NewBoundedLabel(2)
+
+	if got := b.Value("a"); got != "a" {
+		t.Errorf("expected a, got %s", got)
+	}
+	if got := b.Value("b"); got != "b" {
+		t.Errorf("expected b, got %s", got)
+	}
+	if got := b.Value("c"); got != OtherLabel {
+		t.Errorf("expected %s once full, got %s", OtherLabel, got)
+	}
+	if got := b.Value("a"); got != "a" {
+		t.Errorf("expected admitted value to stay a, got %s", got)
+	}
+}
+
+func TestPairLabels(t *testing.T) {
+	p := newPairLabels(10, 10, 3)
+
+	for _, org := range []string{"o1", "o2", "o3"} {
+		if gotOrg, gotEvent := p.Values(org, "e"); gotOrg != org || gotEvent != "e" {
+			t.Errorf("expected (%s, e), got (%s, %s)", org, gotOrg, gotEvent)
+		}
+	}
+	// The pairs are exhausted: the organization is kept, the event is not.
+	if gotOrg, gotEvent := p.Values("o4", "e"); gotOrg != "o4" || gotEvent != OtherLabel {
+		t.Errorf("expected (o4, %s), got (%s, %s)", OtherLabel, gotOrg, gotEvent)
+	}
+	if _, gotEvent := p.Values("o1", "e"); gotEvent != "e" {
+		t.Errorf("expected admitted pair to keep its event, got %s", gotEvent)
+	}
+}
@@ -0,0 +1,417 @@
+// Package migrate applies the collector's versioned datastore schema.
+//
+// Migrations are numbered SQL files embedded from migrations/, named
+// NN_description.sql and applied in order. They are text/template files
+// over Names, so the same schema can be created in any database. Each
+// applied migration is recorded in the database's schema_migrations table
+// with a checksum of its contents.
+//
+// The datastore has no DDL transactions, so every statement must be
+// idempotent (IF NOT EXISTS, ADD COLUMN IF NOT EXISTS, ...): a migration
+// that fails part way is retried from its first statement.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	ds "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+//go:embed migrations/*.sql
+var files embed.FS
+
+// migrationsTable records applied migrations in each database.
+const migrationsTable = "schema_migrations"
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	// SQL is the migration template; see Render.
+	SQL string
+	// Checksum hashes the migration rendered with DefaultNames, so it does
+	// not depend on where the schema is created.
+	Checksum string
+}
+
+// Render fills the migration's database and table names.
+func (m Migration) Render(names Names) (string, error) {
+	tmpl, err := template.New(m.Name).Option("missingkey=error").Parse(m.SQL)
+	if err != nil {
+		return "", fmt.Errorf("migration %02d_%s: %w", m.Version, m.Name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, names); err != nil {
+		return "", fmt.Errorf("migration %02d_%s: %w", m.Version, m.Name, err)
+	}
+	return b.String(), nil
+}
+
+// Status is a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the applied checksum differs from the file.
+	Modified bool
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// Migrations returns the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	return load(files, "migrations")
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must be NN_description.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if prev, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", prev, e.Name(), version)
+		}
+		seen[version] = e.Name()
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig := Migration{Version: version, Name: m[2], SQL: string(data)}
+		canonical, err := mig.Render(DefaultNames())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256([]byte(canonical))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		out = append(out, mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Latest is the highest embedded migration version.
+func Latest() int {
+	migrations, err := Migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// guardPrefix starts a comment line whose query decides whether the next
+// statement runs: it must return a single count, and the statement is
+// skipped when that is zero. Guards let migrations that cannot be written
+// with IF NOT EXISTS, such as swapping a table, resume safely.
+const guardPrefix = "-- migrate:if "
+
+// statement is one statement of a migration and its optional guard.
+type statement struct {
+	sql   string
+	guard string
+}
+
+// Statements splits a migration into single statements, dropping comments;
+// the datastore executes one statement per query.
+func Statements(sql string) []string {
+	parsed := parse(sql)
+	out := make([]string, len(parsed))
+	for i, stmt := range parsed {
+		out[i] = stmt.sql
+	}
+	return out
+}
+
+func parse(sql string) []statement {
+	var out []statement
+	var b strings.Builder
+	guard := ""
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			out = append(out, statement{sql: stmt, guard: guard})
+			guard = ""
+		}
+		b.Reset()
+	}
+	inString := false
+	for _, line := range strings.Split(sql, "\n") {
+		if trimmed := strings.TrimSpace(line); !inString && strings.HasPrefix(trimmed, "--") {
+			if g, ok := strings.CutPrefix(trimmed, guardPrefix); ok {
+				guard = strings.TrimSpace(g)
+			}
+			continue
+		}
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\'' && (i == 0 || line[i-1] != '\\'):
+				inString = !inString
+			case c == ';' && !inString:
+				flush()
+				continue
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('\n')
+	}
+	flush()
+	return out
+}
+
+// Migrator applies migrations to one database over a datastore connection.
+type Migrator struct {
+	conn       driver.Conn
+	names      Names
+	logger     *slog.Logger
+	migrations []Migration
+	owned      bool
+}
+
+// New creates a migrator for the schema named by names, using conn. Empty
+// names take their defaults. A nil logger uses slog.Default.
+func New(conn driver.Conn, names Names, logger *slog.Logger) (*Migrator, error) {
+	names = names.WithDefaults()
+	if err := names.Validate(); err != nil {
+		return nil, err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		conn:       conn,
+		names:      names,
+		logger:     logging.Or(logger).With("subsystem", "migrate", "database", names.Database),
+		migrations: migrations,
+	}, nil
+}
+
+// Open connects to the datastore at dsn and creates a migrator that owns
+// the connection.
+func Open(dsn string, names Names, logger *slog.Logger) (*Migrator, error) {
+	opts, err := ds.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid datastore DSN: %w", err)
+	}
+	conn, err := ds.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to datastore: %w", err)
+	}
+	m, err := New(conn, names, logger)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	m.owned = true
+	return m, nil
+}
+
+// Close closes the connection if the migrator opened it.
+func (m *Migrator) Close() error {
+	if m.owned {
+		return m.conn.Close()
+	}
+	return nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if err := m.conn.Exec(ctx, "CREATE DATABASE IF NOT EXISTS "+m.names.Database); err != nil {
+		return fmt.Errorf("create database %s: %w", m.names.Database, err)
+	}
+	err := m.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+m.table()+` (
+    version UInt32,
+    name String,
+    checksum String,
+    applied_at DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(applied_at)
+ORDER BY version`)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", m.table(), err)
+	}
+	return nil
+}
+
+func (m *Migrator) table() string {
+	return m.names.Database + "." + migrationsTable
+}
+
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]applied, error) {
+	rows, err := m.conn.Query(ctx, `SELECT version, argMax(name, applied_at), argMax(checksum, applied_at), max(applied_at)
+FROM `+m.table()+` GROUP BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", m.table(), err)
+	}
+	defer rows.Close()
+
+	out := make(map[int]applied)
+	for rows.Next() {
+		var version uint32
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("read %s: %w", m.table(), err)
+		}
+		out[int(version)] = a
+	}
+	return out, rows.Err()
+}
+
+// Status lists every embedded migration, plus any applied migration this
+// binary does not know about.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Status
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, ok := done[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != mig.Checksum
+			delete(done, mig.Version)
+		}
+		out = append(out, s)
+	}
+	for version, a := range done {
+		out = append(out, Status{
+			Migration: Migration{Version: version, Name: a.name, Checksum: a.checksum},
+			Applied:   true,
+			AppliedAt: a.appliedAt,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up applies pending migrations in order and returns those it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := compatible(statuses, Latest()); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+		if err := m.apply(ctx, s.Migration); err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	start := time.Now()
+	sql, err := mig.Render(m.names)
+	if err != nil {
+		return err
+	}
+	stmts := parse(sql)
+	for i, stmt := range stmts {
+		if stmt.guard != "" {
+			var n uint64
+			if err := m.conn.QueryRow(ctx, stmt.guard).Scan(&n); err != nil {
+				return fmt.Errorf("migration %02d_%s: guard of statement %d of %d: %w", mig.Version, mig.Name, i+1, len(stmts), err)
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		if err := m.conn.Exec(ctx, stmt.sql); err != nil {
+			return fmt.Errorf("migration %02d_%s: statement %d of %d: %w", mig.Version, mig.Name, i+1, len(stmts), err)
+		}
+	}
+	if err := m.conn.Exec(ctx, "INSERT INTO "+m.table()+" (version, name, checksum) VALUES (?, ?, ?)",
+		uint32(mig.Version), mig.Name, mig.Checksum); err != nil {
+		return fmt.Errorf("record migration %02d_%s: %w", mig.Version, mig.Name, err)
+	}
+	m.logger.Info("applied migration", "version", mig.Version, "name", mig.Name, "duration", time.Since(start))
+	return nil
+}
+
+// ErrIncompatible is returned when the datastore schema does not match
+// this binary.
+var ErrIncompatible = errors.New("incompatible datastore schema")
+
+// Check verifies that every embedded migration is applied unmodified and
+// that the datastore has no migrations newer than this binary.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if err := compatible(statuses, Latest()); err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%02d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending migrations %s; run `collector migrate up`", ErrIncompatible, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// compatible rejects schemas written by a newer binary and applied
+// migrations whose files have since changed.
+func compatible(statuses []Status, latest int) error {
+	var errs []error
+	for _, s := range statuses {
+		switch {
+		case s.Applied && s.Version > latest:
+			errs = append(errs, fmt.Errorf("migration %02d_%s is newer than this collector (latest %02d)", s.Version, s.Name, latest))
+		case s.Modified:
+			errs = append(errs, fmt.Errorf("migration %02d_%s was changed after it was applied", s.Version, s.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrIncompatible, errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package migrate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations_Embedded(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("expected migrations starting at 01, got %+v", migrations)
+	}
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("migrations out of order at %02d_%s", m.Version, m.Name)
+		}
+		if len(Statements(m.SQL)) == 0 {
+			t.Errorf("migration %02d_%s has no statements", m.Version, m.Name)
+		}
+	}
+	if Latest() != migrations[len(migrations)-1].Version {
+		t.Errorf("Latest() = %d", Latest())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/10_later.sql":  {Data: []byte("SELECT 10")},
+		"m/02_second.sql": {Data: []byte("SELECT 2")},
+	}
+	migrations, err := load(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrations[0].Version != 2 || migrations[1].Version != 10 || migrations[1].Name != "later" {
+		t.Errorf("unexpected order %+v", migrations)
+	}
+	if migrations[0].Checksum == migrations[1].Checksum {
+		t.Error("expected checksums to differ")
+	}
+
+	fsys["m/2_dup.sql"] = &fstest.MapFile{Data: []byte("SELECT 2")}
+	if _, err := load(fsys, "m"); err == nil {
+		t.Error("expected duplicate versions to be rejected")
+	}
+	delete(fsys, "m/2_dup.sql")
+	fsys["m/notes.txt"] = &fstest.MapFile{}
+	if _, err := load(fsys, "m"); err == nil {
+		t.Error("expected badly named files to be rejected")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	sql := `-- leading comment; not a statement
+CREATE TABLE a (x String DEFAULT 'a;b');
+
+-- between
+ALTER TABLE a ADD COLUMN y String DEFAULT 'it\'s';
+SELECT 1`
+	got := Statements(sql)
+	want := []string{
+		"CREATE TABLE a (x String DEFAULT 'a;b')",
+		"ALTER TABLE a ADD COLUMN y String DEFAULT 'it\\'s'",
+		"SELECT 1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statements, got %q", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	ok := []Status{
+		{Migration: Migration{Version: 1, Name: "init"}, Applied: true},
+		{Migration: Migration{Version: 2, Name: "next"}},
+	}
+	if err := compatible(ok, 2); err != nil {
+		t.Errorf("pending migrations are compatible: %v", err)
+	}
+
+	newer := append(ok, Status{Migration: Migration{Version: 3, Name: "future"}, Applied: true})
+	if err := compatible(newer, 2); !errors.Is(err, ErrIncompatible) || !strings.Contains(err.Error(), "03_future") {
+		t.Errorf("expected a newer schema to be rejected, got %v", err)
+	}
+
+	modified := []Status{{Migration: Migration{Version: 1, Name: "init"}, Applied: true, Modified: true}}
+	if err := compatible(modified, 1); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("expected a modified migration to be rejected, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := DefaultNames().InDatabase("acme")
+	names.Events = "raw_events"
+	sql, err := migrations[0].Render(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sql, "CREATE TABLE IF NOT EXISTS acme.raw_events (") || !strings.Contains(sql, "FROM acme.raw_events") {
+		t.Errorf("expected names to be rendered, got %s", sql)
+	}
+	if strings.Contains(sql, "commerce.") || strings.Contains(sql, "{{") {
+		t.Error("expected no default or template names left")
+	}
+
+	// The checksum is independent of the names a schema is created under.
+	other, _ := load(files, "migrations")
+	if other[0].Checksum != migrations[0].Checksum {
+		t.Error("expected a stable checksum")
+	}
+}
+
+func TestNames_Validate(t *testing.T) {
+	if err := DefaultNames().Validate(); err != nil {
+		t.Errorf("default names: %v", err)
+	}
+	names := DefaultNames()
+	names.Database = "acme-prod"
+	names.Sessions = "events"
+	names.Groups = migrationsTable
+	err := names.Validate()
+	for _, want := range []string{"database", "sessions", "groups"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error for %s, got %v", want, err)
+		}
+	}
+	if got := (Names{Database: "acme"}).WithDefaults(); got.Database != "acme" || got.Events != "events" {
+		t.Errorf("unexpected defaults %+v", got)
+	}
+}
+
+func TestParse_Guards(t *testing.T) {
+	got := parse(`-- explanation
+-- migrate:if SELECT count() FROM system.tables WHERE name = 'events'
+EXCHANGE TABLES a AND b;
+
+DROP VIEW IF EXISTS v;`)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %+v", got)
+	}
+	if got[0].guard != "SELECT count() FROM system.tables WHERE name = 'events'" || got[0].sql != "EXCHANGE TABLES a AND b" {
+		t.Errorf("unexpected guarded statement %+v", got[0])
+	}
+	if got[1].guard != "" {
+		t.Errorf("expected the guard to apply to one statement, got %+v", got[1])
+	}
+}
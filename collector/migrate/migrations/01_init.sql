@@ -1,8 +1,5 @@
-package writer
-
-// Schema contains datastore table definitions for unified analytics.
-const Schema = `
-CREATE TABLE IF NOT EXISTS commerce.events (
+-- Initial commerce schema: events, hourly rollup, persons, sessions, groups.
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Events}} (
     event_id UUID DEFAULT generateUUIDv4(),
     distinct_id String,
     event String,
@@ -79,7 +76,7 @@ PARTITION BY toYYYYMM(_partition_date)
 ORDER BY (organization_id, toStartOfHour(timestamp), distinct_id, session_id, event_id)
 SETTINGS index_granularity = 8192;
 
-CREATE TABLE IF NOT EXISTS commerce.events_hourly (
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.EventsHourly}} (
     organization_id String,
     hour DateTime,
     event String,
@@ -98,8 +95,8 @@ ENGINE = SummingMergeTree()
 PARTITION BY toYYYYMM(hour)
 ORDER BY (organization_id, hour, event, url_path, referrer_domain, country, device_type, browser, os);
 
-CREATE MATERIALIZED VIEW IF NOT EXISTS commerce.events_hourly_mv
-TO commerce.events_hourly
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.{{.EventsHourly}}_mv
+TO {{.Database}}.{{.EventsHourly}}
 AS SELECT
     organization_id,
     toStartOfHour(timestamp) as hour,
@@ -114,10 +111,10 @@ AS SELECT
     uniqExact(distinct_id) as unique_users,
     uniqExact(session_id) as unique_sessions,
     sum(revenue) as total_revenue
-FROM commerce.events
+FROM {{.Database}}.{{.Events}}
 GROUP BY organization_id, hour, event, url_path, referrer_domain, country, device_type, browser, os;
 
-CREATE TABLE IF NOT EXISTS commerce.persons (
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Persons}} (
     distinct_id String,
     organization_id String,
     properties String DEFAULT '{}',
@@ -131,7 +128,7 @@ ENGINE = ReplacingMergeTree(updated_at)
 PARTITION BY toYYYYMM(_partition_date)
 ORDER BY (organization_id, distinct_id);
 
-CREATE TABLE IF NOT EXISTS commerce.sessions (
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Sessions}} (
     session_id String,
     distinct_id String,
     organization_id String,
@@ -153,7 +150,7 @@ ENGINE = ReplacingMergeTree(ended_at)
 PARTITION BY toYYYYMM(_partition_date)
 ORDER BY (organization_id, session_id);
 
-CREATE TABLE IF NOT EXISTS commerce.groups (
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Groups}} (
     group_type String,
     group_key String,
     organization_id String,
@@ -163,4 +160,3 @@ CREATE TABLE IF NOT EXISTS commerce.groups (
 )
 ENGINE = ReplacingMergeTree(updated_at)
 ORDER BY (organization_id, group_type, group_key);
-`
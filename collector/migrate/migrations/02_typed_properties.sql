@@ -0,0 +1,14 @@
+-- Typed property maps. Properties are split by JSON type so filters and
+-- aggregates read a column instead of parsing JSON; nested objects and
+-- arrays are kept as JSON text in the string map. The JSON columns stay for
+-- existing readers.
+ALTER TABLE {{.Database}}.{{.Events}}
+    ADD COLUMN IF NOT EXISTS properties_string Map(String, String) AFTER properties,
+    ADD COLUMN IF NOT EXISTS properties_number Map(String, Float64) AFTER properties_string,
+    ADD COLUMN IF NOT EXISTS properties_bool Map(String, Bool) AFTER properties_number,
+    ADD COLUMN IF NOT EXISTS person_properties_string Map(String, String) AFTER person_properties,
+    ADD COLUMN IF NOT EXISTS person_properties_number Map(String, Float64) AFTER person_properties_string,
+    ADD COLUMN IF NOT EXISTS person_properties_bool Map(String, Bool) AFTER person_properties_number,
+    ADD COLUMN IF NOT EXISTS group_properties_string Map(String, String) AFTER group_properties,
+    ADD COLUMN IF NOT EXISTS group_properties_number Map(String, Float64) AFTER group_properties_string,
+    ADD COLUMN IF NOT EXISTS group_properties_bool Map(String, Bool) AFTER group_properties_number;
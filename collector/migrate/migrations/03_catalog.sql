@@ -0,0 +1,16 @@
+-- Event and property catalog. Each collector saves its cumulative view of
+-- an entry; the latest save of a row wins on merge.
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Catalog}} (
+    organization_id String,
+    event String,
+    property String,
+    types Array(LowCardinality(String)),
+    first_seen DateTime64(3),
+    last_seen DateTime64(3),
+    count UInt64,
+    samples Array(String),
+    drift Bool,
+    updated_at DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (organization_id, event, property);
@@ -0,0 +1,54 @@
+-- Collapse duplicate events. The events table becomes a ReplacingMergeTree,
+-- so rows sharing a sorting key (including event_id) merge into one; read
+-- with FINAL for exact counts before merges catch up. Existing rows are
+-- copied into the new table, which is then swapped in. The old table is
+-- kept as {{.Events}}_merge_tree for rows written by other collectors
+-- during the copy; drop it once they are checked.
+--
+-- The sorting key starts with the hour, so duplicates collapse only when
+-- they land in the same hour. A retry whose timestamp moved across an hour
+-- boundary (one sent without a client timestamp is stamped on receipt, and
+-- skew correction shifts by transit time) survives the merge; only the
+-- collector's dedup window catches it.
+--
+-- The hourly rollup is fed on insert and still counts a duplicate that
+-- reaches the datastore; the collector drops retries it has already seen.
+
+-- migrate:if SELECT count() FROM system.tables WHERE database = '{{.Database}}' AND name = '{{.Events}}' AND engine = 'MergeTree'
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.Events}}_replacing AS {{.Database}}.{{.Events}}
+ENGINE = ReplacingMergeTree(created_at)
+PARTITION BY toYYYYMM(_partition_date)
+ORDER BY (organization_id, toStartOfHour(timestamp), distinct_id, session_id, event_id)
+SETTINGS index_granularity = 8192;
+
+-- A repeated copy only adds rows that collapse on merge.
+-- migrate:if SELECT count() FROM system.tables WHERE database = '{{.Database}}' AND name = '{{.Events}}' AND engine = 'MergeTree'
+INSERT INTO {{.Database}}.{{.Events}}_replacing SELECT * FROM {{.Database}}.{{.Events}};
+
+-- migrate:if SELECT count() FROM system.tables WHERE database = '{{.Database}}' AND name = '{{.Events}}' AND engine = 'MergeTree'
+EXCHANGE TABLES {{.Database}}.{{.Events}} AND {{.Database}}.{{.Events}}_replacing;
+
+-- migrate:if SELECT count() FROM system.tables WHERE database = '{{.Database}}' AND name = '{{.Events}}_replacing'
+RENAME TABLE {{.Database}}.{{.Events}}_replacing TO {{.Database}}.{{.Events}}_merge_tree;
+
+-- Re-create the rollup view so it reads from the swapped-in table.
+DROP VIEW IF EXISTS {{.Database}}.{{.EventsHourly}}_mv;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.{{.EventsHourly}}_mv
+TO {{.Database}}.{{.EventsHourly}}
+AS SELECT
+    organization_id,
+    toStartOfHour(timestamp) as hour,
+    event,
+    url_path,
+    referrer_domain,
+    country,
+    device_type,
+    browser,
+    os,
+    count() as event_count,
+    uniqExact(distinct_id) as unique_users,
+    uniqExact(session_id) as unique_sessions,
+    sum(revenue) as total_revenue
+FROM {{.Database}}.{{.Events}}
+GROUP BY organization_id, hour, event, url_path, referrer_domain, country, device_type, browser, os;
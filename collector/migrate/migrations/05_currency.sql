@@ -0,0 +1,31 @@
+-- Multi-currency revenue. revenue keeps the amount in the event's
+-- currency; revenue_base is that amount converted to the organization's
+-- base currency at the rate of the event's day. Rows written before this
+-- migration had no currency and read revenue_base as their revenue.
+ALTER TABLE {{.Database}}.{{.Events}}
+    ADD COLUMN IF NOT EXISTS currency LowCardinality(String) DEFAULT '' AFTER revenue,
+    ADD COLUMN IF NOT EXISTS base_currency LowCardinality(String) DEFAULT '' AFTER currency,
+    ADD COLUMN IF NOT EXISTS revenue_base Decimal64(4) DEFAULT revenue AFTER base_currency;
+
+-- The hourly rollup sums base-currency revenue from now on; hours already
+-- rolled up keep their mixed-currency totals.
+DROP VIEW IF EXISTS {{.Database}}.{{.EventsHourly}}_mv;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.{{.EventsHourly}}_mv
+TO {{.Database}}.{{.EventsHourly}}
+AS SELECT
+    organization_id,
+    toStartOfHour(timestamp) as hour,
+    event,
+    url_path,
+    referrer_domain,
+    country,
+    device_type,
+    browser,
+    os,
+    count() as event_count,
+    uniqExact(distinct_id) as unique_users,
+    uniqExact(session_id) as unique_sessions,
+    sum(revenue_base) as total_revenue
+FROM {{.Database}}.{{.Events}}
+GROUP BY organization_id, hour, event, url_path, referrer_domain, country, device_type, browser, os;
@@ -0,0 +1,65 @@
+-- Order line items, expanded from order_completed events that carry
+-- products. Rows are keyed by order and line, so a repeated order
+-- collapses on merge; read with FINAL for exact figures. revenue is the
+-- line total (price times quantity, less discount) in the order's
+-- currency, revenue_base the same in the organization's base currency.
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.OrderItems}} (
+    organization_id String,
+    order_id String,
+    line UInt16,
+    event_id UUID,
+    distinct_id String,
+    session_id String,
+    timestamp DateTime64(3),
+    product_id String,
+    sku String,
+    name String,
+    brand String,
+    category String,
+    variant String,
+    price Decimal64(4),
+    quantity UInt32,
+    discount Decimal64(4),
+    coupon String,
+    revenue Decimal64(4),
+    currency LowCardinality(String),
+    base_currency LowCardinality(String),
+    revenue_base Decimal64(4),
+    created_at DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(created_at)
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (organization_id, order_id, line)
+SETTINGS index_granularity = 8192;
+
+-- Product revenue per day, for product-level reports. Like the hourly
+-- rollup it is fed on insert.
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.ProductRevenue}} (
+    organization_id String,
+    day Date,
+    product_id String,
+    sku String,
+    category String,
+    base_currency LowCardinality(String),
+    orders UInt64,
+    quantity UInt64,
+    revenue_base Decimal64(4)
+)
+ENGINE = SummingMergeTree()
+PARTITION BY toYYYYMM(day)
+ORDER BY (organization_id, day, product_id, sku, category, base_currency);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.{{.ProductRevenue}}_mv
+TO {{.Database}}.{{.ProductRevenue}}
+AS SELECT
+    organization_id,
+    toDate(timestamp) as day,
+    product_id,
+    sku,
+    category,
+    base_currency,
+    uniqExact(order_id) as orders,
+    sum(quantity) as quantity,
+    sum(revenue_base) as revenue_base
+FROM {{.Database}}.{{.OrderItems}}
+GROUP BY organization_id, day, product_id, sku, category, base_currency;
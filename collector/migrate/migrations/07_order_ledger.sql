@@ -0,0 +1,81 @@
+-- Order lifecycle ledger. Every order_completed, order_refunded and
+-- order_cancelled event with an order ID adds an entry; refunds and
+-- cancellations carry negative amounts. An order has one completion and
+-- one cancellation entry, so a repeated order_completed replaces the first
+-- on merge instead of counting twice; refunds are kept per event. Read
+-- with FINAL, as the orders view does.
+CREATE TABLE IF NOT EXISTS {{.Database}}.{{.OrderLedger}} (
+    organization_id String,
+    order_id String,
+    kind LowCardinality(String),
+    entry_id String,
+    event_id UUID,
+    distinct_id String,
+    timestamp DateTime64(3),
+    currency LowCardinality(String),
+    base_currency LowCardinality(String),
+    amount Decimal64(4),
+    amount_base Decimal64(4),
+    created_at DateTime64(3) DEFAULT now64(3)
+)
+ENGINE = ReplacingMergeTree(created_at)
+ORDER BY (organization_id, order_id, kind, entry_id)
+SETTINGS index_granularity = 8192;
+
+-- The state of each order: gross is the completed amount, refunded the
+-- refunds, net the difference, all in the base currency. A cancelled
+-- order is reversed whole, whether or not the cancellation carried an
+-- amount: its refunded revenue is its gross and its net is zero.
+CREATE VIEW IF NOT EXISTS {{.Database}}.{{.Orders}} AS
+SELECT
+    organization_id,
+    order_id,
+    any(base_currency) as base_currency,
+    minIf(timestamp, kind = 'completed') as completed_at,
+    max(timestamp) as updated_at,
+    sumIf(amount_base, kind = 'completed') as gross_revenue,
+    if(countIf(kind = 'cancelled') > 0, gross_revenue, -sumIf(amount_base, kind != 'completed')) as refunded_revenue,
+    gross_revenue - refunded_revenue as net_revenue,
+    multiIf(
+        countIf(kind = 'cancelled') > 0, 'cancelled',
+        refunded_revenue > 0 AND refunded_revenue >= gross_revenue, 'refunded',
+        refunded_revenue > 0, 'partially_refunded',
+        countIf(kind = 'completed') > 0, 'completed',
+        'pending'
+    ) as status
+FROM {{.Database}}.{{.OrderLedger}} FINAL
+GROUP BY organization_id, order_id;
+
+-- The hourly rollup reports gross (positive) and refunded (negative)
+-- revenue besides the net total_revenue. Like the rest of the rollup these
+-- are summed from events as they are inserted, so they are approximate
+-- where the orders view is exact: a repeated order_completed that reaches
+-- the datastore (sent after writer.dedup_window, or to another collector)
+-- counts twice, and a cancellation without an amount does not reduce
+-- them. Report order revenue from the orders view.
+ALTER TABLE {{.Database}}.{{.EventsHourly}}
+    ADD COLUMN IF NOT EXISTS gross_revenue Decimal64(4) DEFAULT 0 AFTER total_revenue,
+    ADD COLUMN IF NOT EXISTS refunded_revenue Decimal64(4) DEFAULT 0 AFTER gross_revenue;
+
+DROP VIEW IF EXISTS {{.Database}}.{{.EventsHourly}}_mv;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{.Database}}.{{.EventsHourly}}_mv
+TO {{.Database}}.{{.EventsHourly}}
+AS SELECT
+    organization_id,
+    toStartOfHour(timestamp) as hour,
+    event,
+    url_path,
+    referrer_domain,
+    country,
+    device_type,
+    browser,
+    os,
+    count() as event_count,
+    uniqExact(distinct_id) as unique_users,
+    uniqExact(session_id) as unique_sessions,
+    sum(revenue_base) as total_revenue,
+    sumIf(revenue_base, revenue_base > 0) as gross_revenue,
+    -sumIf(revenue_base, revenue_base < 0) as refunded_revenue
+FROM {{.Database}}.{{.Events}}
+GROUP BY organization_id, hour, event, url_path, referrer_domain, country, device_type, browser, os;
@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Names are the database and table names a schema is created under.
+// Migrations refer to them as template fields, e.g. {{.Database}}.{{.Events}}.
+type Names struct {
+	Database     string
+	Events       string
+	EventsHourly string
+	Persons      string
+	Sessions     string
+	Groups       string
+	Catalog      string
+	// OrderItems holds one row per order line; ProductRevenue rolls them
+	// up per product and day.
+	OrderItems     string
+	ProductRevenue string
+	// OrderLedger records each order's completion, refunds and
+	// cancellation; the Orders view sums them into a state per order.
+	OrderLedger string
+	Orders      string
+}
+
+// DefaultNames returns the names used when none are configured.
+func DefaultNames() Names {
+	return Names{
+		Database:     "commerce",
+		Events:       "events",
+		EventsHourly: "events_hourly",
+		Persons:      "persons",
+		Sessions:     "sessions",
+		Groups:       "groups",
+		Catalog:      "catalog",
+
+		OrderItems:     "order_items",
+		ProductRevenue: "product_revenue_daily",
+		OrderLedger:    "order_ledger",
+		Orders:         "orders",
+	}
+}
+
+// WithDefaults fills empty names from DefaultNames.
+func (n Names) WithDefaults() Names {
+	d := DefaultNames()
+	for _, f := range []struct{ v, d *string }{
+		{&n.Database, &d.Database},
+		{&n.Events, &d.Events},
+		{&n.EventsHourly, &d.EventsHourly},
+		{&n.Persons, &d.Persons},
+		{&n.Sessions, &d.Sessions},
+		{&n.Groups, &d.Groups},
+		{&n.Catalog, &d.Catalog},
+		{&n.OrderItems, &d.OrderItems},
+		{&n.ProductRevenue, &d.ProductRevenue},
+		{&n.OrderLedger, &d.OrderLedger},
+		{&n.Orders, &d.Orders},
+	} {
+		if *f.v == "" {
+			*f.v = *f.d
+		}
+	}
+	return n
+}
+
+// InDatabase returns the same table names in another database.
+func (n Names) InDatabase(database string) Names {
+	n.Database = database
+	return n
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidIdentifier reports whether name can be used unquoted as a database
+// or table name.
+func ValidIdentifier(name string) bool {
+	return identifier.MatchString(name)
+}
+
+// Validate reports names that are not plain identifiers or that collide.
+func (n Names) Validate() error {
+	var errs []error
+	seen := make(map[string]string)
+	for _, f := range []struct{ field, name string }{
+		{"database", n.Database},
+		{"events", n.Events},
+		{"events_hourly", n.EventsHourly},
+		{"persons", n.Persons},
+		{"sessions", n.Sessions},
+		{"groups", n.Groups},
+		{"catalog", n.Catalog},
+		{"order_items", n.OrderItems},
+		{"product_revenue", n.ProductRevenue},
+		{"order_ledger", n.OrderLedger},
+		{"orders", n.Orders},
+	} {
+		if !ValidIdentifier(f.name) {
+			errs = append(errs, fmt.Errorf("%s: %q is not a valid identifier", f.field, f.name))
+			continue
+		}
+		if f.field == "database" {
+			continue
+		}
+		if f.name == migrationsTable {
+			errs = append(errs, fmt.Errorf("%s: %q is reserved", f.field, f.name))
+		}
+		if prev, ok := seen[f.name]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s are both %q", prev, f.field, f.name))
+		}
+		seen[f.name] = f.field
+	}
+	return errors.Join(errs...)
+}
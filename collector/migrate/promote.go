@@ -0,0 +1,150 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Property types a promoted column can have, matching the typed property
+// maps added in 02_typed_properties.
+const (
+	PropertyString = "string"
+	PropertyNumber = "number"
+	PropertyBool   = "bool"
+)
+
+// Property sources a promoted column can read from.
+const (
+	SourceProperties       = "properties"
+	SourcePersonProperties = "person_properties"
+	SourceGroupProperties  = "group_properties"
+)
+
+// Promotion materializes one property key as a typed column of the events
+// table, so filtering on it reads a real column instead of a map.
+type Promotion struct {
+	Key    string
+	Type   string
+	Source string
+}
+
+var columnUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Column returns the column name, e.g. prop_plan or person_prop_plan.
+func (p Promotion) Column() string {
+	prefix := "prop_"
+	switch p.source() {
+	case SourcePersonProperties:
+		prefix = "person_prop_"
+	case SourceGroupProperties:
+		prefix = "group_prop_"
+	}
+	return prefix + strings.ToLower(columnUnsafe.ReplaceAllString(p.Key, "_"))
+}
+
+func (p Promotion) source() string {
+	if p.Source == "" {
+		return SourceProperties
+	}
+	return p.Source
+}
+
+func (p Promotion) columnType() string {
+	switch p.Type {
+	case PropertyNumber:
+		return "Float64"
+	case PropertyBool:
+		return "Bool"
+	}
+	return "String"
+}
+
+// expr reads the key from its typed map; rows without it get the type's
+// zero value.
+func (p Promotion) expr() string {
+	t := p.Type
+	if t == "" {
+		t = PropertyString
+	}
+	return fmt.Sprintf("%s_%s['%s']", p.source(), t, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.Key))
+}
+
+// Validate reports an empty key or an unknown type or source.
+func (p Promotion) Validate() error {
+	if p.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	switch p.Type {
+	case "", PropertyString, PropertyNumber, PropertyBool:
+	default:
+		return fmt.Errorf("%s: unknown type %q", p.Key, p.Type)
+	}
+	switch p.Source {
+	case "", SourceProperties, SourcePersonProperties, SourceGroupProperties:
+	default:
+		return fmt.Errorf("%s: unknown source %q", p.Key, p.Source)
+	}
+	return nil
+}
+
+// Promote adds a materialized column for each promotion that does not have
+// one yet and starts backfilling it for existing rows. The backfill runs in
+// the background as a mutation; new rows are filled on insert. It returns
+// the columns it added.
+func (m *Migrator) Promote(ctx context.Context, promotions []Promotion) ([]string, error) {
+	if len(promotions) == 0 {
+		return nil, nil
+	}
+	existing, err := m.columns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	table := m.names.Database + "." + m.names.Events
+	var added []string
+	for _, p := range promotions {
+		if err := p.Validate(); err != nil {
+			return added, err
+		}
+		column := p.Column()
+		if t, ok := existing[column]; ok {
+			if t != p.columnType() {
+				return added, fmt.Errorf("promoted column %s exists as %s, not %s", column, t, p.columnType())
+			}
+			continue
+		}
+		if err := m.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s MATERIALIZED %s",
+			table, column, p.columnType(), p.expr())); err != nil {
+			return added, fmt.Errorf("promote %s: %w", p.Key, err)
+		}
+		if err := m.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s MATERIALIZE COLUMN %s", table, column)); err != nil {
+			return added, fmt.Errorf("backfill %s: %w", column, err)
+		}
+		existing[column] = p.columnType()
+		added = append(added, column)
+		m.logger.Info("promoted property", "key", p.Key, "column", column, "type", p.columnType())
+	}
+	return added, nil
+}
+
+// columns returns the events table's column types by name.
+func (m *Migrator) columns(ctx context.Context) (map[string]string, error) {
+	rows, err := m.conn.Query(ctx, "SELECT name, type FROM system.columns WHERE database = ? AND table = ?",
+		m.names.Database, m.names.Events)
+	if err != nil {
+		return nil, fmt.Errorf("read columns: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("read columns: %w", err)
+		}
+		out[name] = typ
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,35 @@
+package migrate
+
+import "testing"
+
+func TestPromotion(t *testing.T) {
+	for _, tc := range []struct {
+		p            Promotion
+		column, expr string
+		typ          string
+	}{
+		{Promotion{Key: "plan"}, "prop_plan", "properties_string['plan']", "String"},
+		{Promotion{Key: "Cart Value", Type: PropertyNumber}, "prop_cart_value", "properties_number['Cart Value']", "Float64"},
+		{Promotion{Key: "beta", Type: PropertyBool, Source: SourcePersonProperties}, "person_prop_beta", "person_properties_bool['beta']", "Bool"},
+		{Promotion{Key: "it's", Source: SourceGroupProperties}, "group_prop_it_s", `group_properties_string['it\'s']`, "String"},
+	} {
+		if got := tc.p.Column(); got != tc.column {
+			t.Errorf("%s: expected column %s, got %s", tc.p.Key, tc.column, got)
+		}
+		if got := tc.p.expr(); got != tc.expr {
+			t.Errorf("%s: expected expression %s, got %s", tc.p.Key, tc.expr, got)
+		}
+		if got := tc.p.columnType(); got != tc.typ {
+			t.Errorf("%s: expected type %s, got %s", tc.p.Key, tc.typ, got)
+		}
+		if err := tc.p.Validate(); err != nil {
+			t.Errorf("%s: %v", tc.p.Key, err)
+		}
+	}
+
+	for _, p := range []Promotion{{}, {Key: "a", Type: "date"}, {Key: "a", Source: "events"}} {
+		if p.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", p)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFileExporter_RecordsRequestSpans(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), &Config{File: file})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.POST("/event", func(c *gin.Context) {
+		_, span := Tracer().Start(c.Request.Context(), "enrich")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/event", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read spans: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{`"Name":"POST /event"`, `"Name":"enrich"`, "4bf92f3577b34da6a3ce929d0e0e4736"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected span output to contain %s", want)
+		}
+	}
+}
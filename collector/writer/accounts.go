@@ -0,0 +1,23 @@
+package writer
+
+// Account identifies a destination account in a third-party service, such
+// as a GA4 measurement ID and API secret or an ad pixel and access token.
+type Account struct {
+	ID     string
+	Secret string
+}
+
+// Accounts chooses the destination account for each organization.
+type Accounts struct {
+	Default        Account
+	ByOrganization map[string]Account
+}
+
+// For returns the account for orgID, falling back to the default. It
+// reports false when neither is configured.
+func (a *Accounts) For(orgID string) (Account, bool) {
+	if acct, ok := a.ByOrganization[orgID]; ok {
+		return acct, true
+	}
+	return a.Default, a.Default.ID != ""
+}
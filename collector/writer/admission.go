@@ -0,0 +1,173 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Admission errors. Callers should ask clients to retry after RetryAfter.
+var (
+	// ErrOverloaded means the write queue is above its high watermark.
+	ErrOverloaded = errors.New("writer is over capacity")
+	// ErrUnavailable means the writer is closed or the datastore is failing.
+	ErrUnavailable = errors.New("writer is unavailable")
+	// ErrTooLarge means a request has more events than the queue holds and
+	// can never be admitted; clients must split it rather than retry.
+	ErrTooLarge = errors.New("more events than the writer queue holds")
+)
+
+// unavailableAfter is the number of consecutive failed batches after which
+// the datastore is considered down until a ping succeeds.
+const unavailableAfter = 3
+
+// Admit reports whether n more events can be accepted. Once the queue
+// reaches the high watermark new events are refused until it drains to the
+// low watermark; requests that would not fit in the queue are refused too.
+// Requests larger than the whole queue are refused with ErrTooLarge.
+func (w *Writer) Admit(n int) error {
+	if n > cap(w.eventCh) {
+		return ErrTooLarge
+	}
+	w.mu.RLock()
+	closed := w.closed
+	w.mu.RUnlock()
+	if closed || w.failures.Load() >= unavailableAfter {
+		return ErrUnavailable
+	}
+
+	depth := len(w.eventCh)
+	w.admitMu.Lock()
+	defer w.admitMu.Unlock()
+	switch {
+	case depth >= w.highWatermark:
+		w.shedding = true
+	case w.shedding && depth <= w.lowWatermark:
+		w.shedding = false
+	}
+	if w.shedding {
+		metrics.Shedding.Set(1)
+		return ErrOverloaded
+	}
+	metrics.Shedding.Set(0)
+	if depth+n > cap(w.eventCh) {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// Capacity returns the most events a single request may carry.
+func (w *Writer) Capacity() int {
+	return cap(w.eventCh)
+}
+
+// Ready reports whether the writer is accepting events, and why not.
+func (w *Writer) Ready() (bool, string) {
+	switch err := w.Admit(0); {
+	case err == nil:
+		return true, ""
+	case errors.Is(err, ErrOverloaded):
+		return false, "queue above high watermark"
+	case w.failures.Load() >= unavailableAfter:
+		return false, "datastore unavailable"
+	default:
+		return false, "closed"
+	}
+}
+
+// RetryAfter estimates how long until the queue drains below the low
+// watermark, for Retry-After headers.
+func (w *Writer) RetryAfter() time.Duration {
+	w.limiter.mu.Lock()
+	limit := max(int(w.limiter.limit), 1)
+	w.limiter.mu.Unlock()
+
+	excess := len(w.eventCh) - w.lowWatermark
+	batches := (excess + w.config.BatchSize - 1) / w.config.BatchSize
+	d := time.Duration((batches+limit-1)/limit) * w.config.FlushInterval
+	if w.failures.Load() >= unavailableAfter {
+		d = max(d, 5*time.Second)
+	}
+	return min(max(d, time.Second), time.Minute)
+}
+
+// recordInsert tracks consecutive batch failures and starts probing the
+// datastore once it is considered down.
+func (w *Writer) recordInsert(err error) {
+	if err == nil {
+		w.failures.Store(0)
+		return
+	}
+	if w.failures.Add(1) == unavailableAfter {
+		w.logger.Error("datastore unavailable, refusing events until it recovers")
+		go w.probe()
+	}
+}
+
+// probe pings the datastore until it answers, then accepts events again.
+func (w *Writer) probe() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.mu.RLock()
+		closed := w.closed
+		w.mu.RUnlock()
+		if closed || w.failures.Load() < unavailableAfter {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := w.conn.Ping(ctx)
+		cancel()
+		if err == nil {
+			w.logger.Info("datastore reachable again")
+			w.failures.Store(0)
+			return
+		}
+	}
+}
+
+// insertLimiter adapts the number of concurrent datastore inserts: it
+// grows additively while batches succeed within the target latency and
+// halves on errors or slow batches.
+type insertLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	max      int
+	target   time.Duration
+	inFlight int
+}
+
+func newInsertLimiter(max int, target time.Duration) *insertLimiter {
+	l := &insertLimiter{limit: 1, max: max, target: target}
+	l.cond = sync.NewCond(&l.mu)
+	metrics.InsertConcurrency.Set(1)
+	return l
+}
+
+// acquire blocks until another insert may start.
+func (l *insertLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// release ends an insert that took latency and failed with err.
+func (l *insertLimiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if err != nil || latency > l.target {
+		l.limit = max(l.limit/2, 1)
+	} else {
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+	}
+	metrics.InsertConcurrency.Set(float64(int(l.limit)))
+	l.cond.Broadcast()
+}
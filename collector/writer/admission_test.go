@@ -0,0 +1,134 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/logging"
+)
+
+// newTestWriter builds a writer with no datastore and no batch processing,
+// so queued events stay queued.
+func newTestWriter(size int) *Writer {
+	w := &Writer{
+		config:        &Config{BatchSize: 2, FlushInterval: time.Second},
+		logger:        logging.Or(nil),
+		eventCh:       make(chan queued, size),
+		limiter:       newInsertLimiter(4, time.Second),
+		clicks:        NewClickMemory(1000, gclidTTL),
+		highWatermark: size * 8 / 10,
+		lowWatermark:  size / 2,
+	}
+	w.forwarders.Store(&[]Forwarder{})
+	w.SetCurrency(nil)
+	return w
+}
+
+func TestWriter_AdmissionWatermarks(t *testing.T) {
+	w := newTestWriter(10)
+	write := func() error {
+		return w.WriteContext(context.Background(), &collector.RawEvent{Event: "e"})
+	}
+
+	for i := range 8 {
+		if err := write(); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	// At the high watermark (8 of 10) events are refused, not written inline.
+	if err := write(); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+	if ready, _ := w.Ready(); ready {
+		t.Error("expected not ready above the high watermark")
+	}
+
+	// Draining to 6 is not enough; the low watermark is 5.
+	<-w.eventCh
+	<-w.eventCh
+	if err := write(); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected shedding until the low watermark, got %v", err)
+	}
+	<-w.eventCh
+	if err := write(); err != nil {
+		t.Fatalf("expected events accepted at the low watermark, got %v", err)
+	}
+
+	// A batch larger than the whole queue can never be admitted.
+	if err := w.Admit(11); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	// A batch that does not fit is refused whole.
+	if err := w.Admit(5); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("expected oversized batch to be refused, got %v", err)
+	}
+	if d := w.RetryAfter(); d < time.Second || d > time.Minute {
+		t.Errorf("retry after %v out of bounds", d)
+	}
+
+	w.failures.Store(unavailableAfter)
+	if err := write(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable while the datastore is down, got %v", err)
+	}
+}
+
+func TestInsertLimiter_AIMD(t *testing.T) {
+	l := newInsertLimiter(4, 100*time.Millisecond)
+	for range 20 {
+		l.acquire()
+		l.release(time.Millisecond, nil)
+	}
+	if l.limit != 4 {
+		t.Errorf("expected limit to grow to the max, got %v", l.limit)
+	}
+
+	l.acquire()
+	l.release(time.Second, nil) // slow
+	if l.limit != 2 {
+		t.Errorf("expected limit halved after a slow insert, got %v", l.limit)
+	}
+	l.acquire()
+	l.release(time.Millisecond, errors.New("boom"))
+	l.acquire()
+	l.release(time.Millisecond, errors.New("boom"))
+	if l.limit != 1 {
+		t.Errorf("expected limit floored at 1, got %v", l.limit)
+	}
+
+	// At the limit, a second insert waits for the first.
+	l.acquire()
+	started := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(started)
+		l.release(time.Millisecond, nil)
+	}()
+	select {
+	case <-started:
+		t.Fatal("second insert started above the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(time.Millisecond, nil)
+	<-started
+}
+
+func TestWriter_RefusedEventsAreNotForwarded(t *testing.T) {
+	w := newTestWriter(10)
+	fwd := &mockForwarder{}
+	w.SetForwarders([]Forwarder{fwd})
+
+	if err := w.WriteContext(context.Background(), &collector.RawEvent{Event: "e", EventID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	w.closed = true
+	if err := w.WriteContext(context.Background(), &collector.RawEvent{Event: "e", EventID: "b"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if n := fwd.count(); n != 1 {
+		t.Errorf("expected only the accepted event forwarded, got %d", n)
+	}
+}
@@ -0,0 +1,152 @@
+package writer
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// Event mapping shared by the ad platform forwarders (GA4, Meta and
+// Google Ads).
+
+// eventTime is the event timestamp, or now when the event has none.
+func eventTime(event *collector.RawEvent) time.Time {
+	if event.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return event.Timestamp
+}
+
+// eventID returns the client-supplied event ID, which platforms match
+// against their browser tags (Meta's pixel eventID, for example), or a
+// stable ID derived from the event so retries deduplicate.
+func eventID(event *collector.RawEvent) string {
+	if event.EventID != "" {
+		return event.EventID
+	}
+	for _, k := range []string{"event_id", "$insert_id", "messageId"} {
+		if id, ok := event.Properties[k].(string); ok && id != "" {
+			return id
+		}
+	}
+	sum := sha256.Sum256([]byte(event.OrganizationID + "\x00" + event.DistinctID + "\x00" +
+		event.Event + "\x00" + strconv.FormatInt(event.Timestamp.UnixNano(), 10)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// eventCurrency is the event's currency, or USD when it has none.
+func eventCurrency(event *collector.RawEvent) string {
+	if event.Currency != "" {
+		return event.Currency
+	}
+	if c, ok := event.Properties["currency"].(string); ok && c != "" {
+		return strings.ToUpper(c)
+	}
+	return "USD"
+}
+
+// eventItems lists the event's products in GA4's item shape (item_id,
+// item_name, price, quantity and so on), which the other ad platforms map
+// from too. Products come from the event's products, a "products" property
+// (a list of product objects) or, failing that, the event's own product
+// fields.
+func eventItems(event *collector.RawEvent) []map[string]interface{} {
+	var items []map[string]interface{}
+	if len(event.Products) > 0 {
+		for _, p := range event.Products {
+			item := map[string]interface{}{"price": p.Price, "quantity": p.Units()}
+			setIfNotEmpty(item, "item_id", cmp.Or(p.ProductID, p.SKU))
+			setIfNotEmpty(item, "item_name", p.Name)
+			setIfNotEmpty(item, "item_brand", p.Brand)
+			setIfNotEmpty(item, "item_category", p.Category)
+			setIfNotEmpty(item, "item_variant", p.Variant)
+			setIfNotEmpty(item, "coupon", p.Coupon)
+			if p.Discount != 0 {
+				item["discount"] = p.Discount / float64(p.Units())
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+	if products, ok := event.Properties["products"].([]interface{}); ok {
+		for _, p := range products {
+			product, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			item := make(map[string]interface{})
+			for _, k := range []string{"product_id", "sku", "id"} {
+				if v, ok := product[k]; ok {
+					item["item_id"] = fmt.Sprint(v)
+					break
+				}
+			}
+			copyParam(item, "item_name", product, "name")
+			copyParam(item, "item_brand", product, "brand")
+			copyParam(item, "item_category", product, "category")
+			copyParam(item, "item_variant", product, "variant")
+			copyParam(item, "price", product, "price")
+			copyParam(item, "quantity", product, "quantity")
+			copyParam(item, "coupon", product, "coupon")
+			if item["item_id"] != nil || item["item_name"] != nil {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+
+	if event.ProductID == "" {
+		return nil
+	}
+	item := map[string]interface{}{"item_id": event.ProductID}
+	copyParam(item, "item_name", event.Properties, "name")
+	copyParam(item, "item_category", event.Properties, "category")
+	copyParam(item, "price", event.Properties, "price")
+	if event.Quantity > 0 {
+		item["quantity"] = event.Quantity
+	}
+	return append(items, item)
+}
+
+// itemsValue is the total of price times quantity over items.
+func itemsValue(items []map[string]interface{}) float64 {
+	var total float64
+	for _, item := range items {
+		price, ok := toFloat(item["price"])
+		if !ok {
+			continue
+		}
+		qty, ok := toFloat(item["quantity"])
+		if !ok {
+			qty = 1
+		}
+		total += price * qty
+	}
+	return total
+}
+
+// copyParam copies src[srcKey] to dst[key] when it is set.
+func copyParam(dst map[string]interface{}, key string, src map[string]interface{}, srcKey string) {
+	if v, ok := src[srcKey]; ok && v != nil && v != "" {
+		dst[key] = v
+	}
+}
+
+// toFloat converts a decoded or Go numeric value to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,479 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/metrics"
+)
+
+// Overflow policies.
+const (
+	OverflowDropOldest  = "drop_oldest"
+	OverflowDropNewest  = "drop_newest"
+	OverflowSpillToDisk = "spill_to_disk"
+)
+
+// Buffer sizes the queue between the request path and a forwarder.
+type Buffer struct {
+	// Size is the number of events held in memory (default 10000).
+	Size int `yaml:"size" toml:"size"`
+	// Workers is the number of goroutines delivering events (default 1).
+	// More than one worker does not preserve event order, which click
+	// attribution forwarders rely on.
+	Workers int `yaml:"workers" toml:"workers"`
+	// Overflow is what happens when the buffer is full: drop_oldest
+	// (default), drop_newest or spill_to_disk.
+	Overflow string `yaml:"overflow" toml:"overflow"`
+	// SpillDir holds the spill files for spill_to_disk; events spilled
+	// before a restart or reload are replayed. MaxSpillSize caps each file
+	// (default 1GiB), beyond which new events are dropped.
+	SpillDir     string `yaml:"spill_dir" toml:"spill_dir"`
+	MaxSpillSize int64  `yaml:"max_spill_size" toml:"max_spill_size"`
+}
+
+// Validate reports an unknown policy or a missing spill directory.
+func (b *Buffer) Validate() error {
+	var errs []error
+	if b.Size < 0 || b.Workers < 0 || b.MaxSpillSize < 0 {
+		errs = append(errs, errors.New("size, workers and max_spill_size must not be negative"))
+	}
+	switch b.Overflow {
+	case "", OverflowDropOldest, OverflowDropNewest:
+	case OverflowSpillToDisk:
+		if b.SpillDir == "" {
+			errs = append(errs, errors.New("spill_dir is required for spill_to_disk"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown overflow policy %q", b.Overflow))
+	}
+	return errors.Join(errs...)
+}
+
+// BufferedForwarder decouples a forwarder from the request path. Forward
+// only enqueues; a pool of workers calls the wrapped forwarder, so a slow
+// or blocking destination fills its own buffer instead of delaying
+// requests or other forwarders.
+type BufferedForwarder struct {
+	name   string
+	next   Forwarder
+	config Buffer
+	logger *slog.Logger
+	queue  chan *collector.RawEvent
+	spill  *spillFile
+	wg     sync.WaitGroup
+	closed bool
+	mu     sync.RWMutex
+
+	stop      chan struct{}
+	replaying sync.WaitGroup
+}
+
+// NewBufferedForwarder wraps next with a bounded buffer and worker pool.
+// The name labels its metrics.
+func NewBufferedForwarder(name string, next Forwarder, config Buffer, logger *slog.Logger) (*BufferedForwarder, error) {
+	if config.Size == 0 {
+		config.Size = 10000
+	}
+	if config.Workers == 0 {
+		config.Workers = 1
+	}
+	if config.Overflow == "" {
+		config.Overflow = OverflowDropOldest
+	}
+	if config.MaxSpillSize == 0 {
+		config.MaxSpillSize = 1 << 30
+	}
+
+	f := &BufferedForwarder{
+		name:   name,
+		next:   next,
+		config: config,
+		logger: logging.Or(logger).With("subsystem", "writer", "forwarder", name),
+		queue:  make(chan *collector.RawEvent, config.Size),
+		stop:   make(chan struct{}),
+	}
+	if config.Overflow == OverflowSpillToDisk {
+		path := filepath.Join(config.SpillDir, fmt.Sprintf("%s.%d.spill.jsonl", name, time.Now().UnixNano()))
+		spill, err := openSpill(path)
+		if err != nil {
+			return nil, err
+		}
+		f.spill = spill
+		f.replaying.Add(1)
+		go f.replay()
+	}
+
+	for range config.Workers {
+		f.wg.Add(1)
+		go f.work()
+	}
+	return f, nil
+}
+
+// Forward enqueues the event without blocking.
+func (f *BufferedForwarder) Forward(event *collector.RawEvent) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		f.drop("closed")
+		return
+	}
+
+	// Spilled events go first, so while the spill is draining new events
+	// join it to keep their order.
+	if f.spill != nil && f.spill.pending() {
+		f.spillEvent(event)
+		return
+	}
+
+	select {
+	case f.queue <- event:
+		f.observe()
+		return
+	default:
+	}
+
+	switch f.config.Overflow {
+	case OverflowDropOldest:
+		select {
+		case <-f.queue:
+			f.drop(OverflowDropOldest)
+		default:
+		}
+		select {
+		case f.queue <- event:
+		default:
+			f.drop(OverflowDropNewest)
+		}
+	case OverflowSpillToDisk:
+		f.spillEvent(event)
+	default:
+		f.drop(OverflowDropNewest)
+	}
+	f.observe()
+}
+
+func (f *BufferedForwarder) spillEvent(event *collector.RawEvent) {
+	if err := f.spill.append(event, f.config.MaxSpillSize); err != nil {
+		f.logger.Warn("spill event", "error", err)
+		f.drop(OverflowDropNewest)
+		return
+	}
+	metrics.ForwarderOverflow.WithLabelValues(f.name, "spilled").Inc()
+}
+
+func (f *BufferedForwarder) drop(reason string) {
+	metrics.ForwarderOverflow.WithLabelValues(f.name, reason).Inc()
+}
+
+func (f *BufferedForwarder) observe() {
+	metrics.ForwarderBufferDepth.WithLabelValues(f.name).Set(float64(len(f.queue)))
+}
+
+func (f *BufferedForwarder) work() {
+	defer f.wg.Done()
+	for event := range f.queue {
+		f.next.Forward(event)
+	}
+}
+
+// spillScanInterval is how often replay looks for spill files left by
+// earlier instances of the forwarder.
+var spillScanInterval = 10 * time.Second
+
+// replay feeds spilled events back into the buffer as it drains, then
+// adopts spill files left by earlier instances (before a restart or
+// reload) once nothing else has them open.
+func (f *BufferedForwarder) replay() {
+	defer f.replaying.Done()
+	ticker := time.NewTicker(spillScanInterval)
+	defer ticker.Stop()
+	for {
+		if !f.drain(f.spill) || !f.adoptSpills() {
+			return
+		}
+		select {
+		case <-f.stop:
+			return
+		case <-f.spill.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain moves events from s into the buffer. It reports false if the
+// forwarder stopped first.
+func (f *BufferedForwarder) drain(s *spillFile) bool {
+	for {
+		events, err := s.next(256)
+		if err != nil {
+			f.logger.Error("read spill file", "path", s.path, "error", err)
+			return true
+		}
+		if len(events) == 0 {
+			return true
+		}
+		for _, e := range events {
+			select {
+			case f.queue <- e:
+				s.consumed()
+			case <-f.stop:
+				return false
+			}
+		}
+	}
+}
+
+func (f *BufferedForwarder) adoptSpills() bool {
+	paths, _ := filepath.Glob(filepath.Join(f.config.SpillDir, f.name+".*.spill.jsonl"))
+	for _, path := range paths {
+		if _, busy := spillsInUse.LoadOrStore(path, struct{}{}); busy {
+			continue
+		}
+		s, err := openSpill(path)
+		if err != nil {
+			spillsInUse.Delete(path)
+			f.logger.Error("adopt spill file", "path", path, "error", err)
+			continue
+		}
+		f.logger.Info("replaying spill file", "path", path)
+		done := f.drain(s)
+		if err := s.close(); err != nil {
+			f.logger.Error("close spill file", "path", path, "error", err)
+		}
+		if !done {
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops accepting events, delivers everything still buffered in
+// memory and closes the wrapped forwarder. Spilled events not yet replayed
+// stay on disk for the next start.
+func (f *BufferedForwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.stop)
+	f.mu.Unlock()
+
+	// Let replay exit before the queue it writes to is closed.
+	f.replaying.Wait()
+	close(f.queue)
+	f.wg.Wait()
+
+	var errs []error
+	if f.spill != nil {
+		errs = append(errs, f.spill.close())
+	}
+	errs = append(errs, f.next.Close())
+	return errors.Join(errs...)
+}
+
+// maxSpillLine bounds a spilled event so reads always hold a whole line.
+const maxSpillLine = 1 << 20
+
+// spillsInUse holds the paths of open spill files, so an instance does not
+// adopt a file another instance is still using.
+var spillsInUse sync.Map
+
+// spillFile is an append-only JSON lines queue on disk. Events are read
+// back from readOff; once everything is read the file is truncated.
+type spillFile struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	readOff  int64
+	writeOff int64
+	// unacked are the sizes of the lines returned by next and not yet
+	// consumed, including any unreadable lines before them.
+	unacked []int64
+	notify  chan struct{}
+}
+
+// openSpill opens or creates a spill file and marks it in use.
+func openSpill(path string) (*spillFile, error) {
+	spillsInUse.Store(path, struct{}{})
+	s, err := openSpillFile(path)
+	if err != nil {
+		spillsInUse.Delete(path)
+	}
+	return s, err
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create spill dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open spill file: %w", err)
+	}
+	size, err := completeLines(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("recover spill file: %w", err)
+	}
+	return &spillFile{path: path, file: file, writeOff: size, notify: make(chan struct{}, 1)}, nil
+}
+
+// completeLines truncates a partial last line left by a crash and returns
+// the remaining size.
+func completeLines(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			size = start + int64(i) + 1
+			break
+		}
+		end = start
+		if end == 0 {
+			size = 0
+		}
+	}
+	return size, file.Truncate(size)
+}
+
+func (s *spillFile) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeOff > s.readOff
+}
+
+func (s *spillFile) append(event *collector.RawEvent, max int64) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if len(line) > maxSpillLine {
+		return errors.New("event too large to spill")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writeOff-s.readOff+int64(len(line)) > max {
+		return errors.New("spill file is full")
+	}
+	if _, err := s.file.WriteAt(line, s.writeOff); err != nil {
+		return err
+	}
+	s.writeOff += int64(len(line))
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next reads up to n events after the last consumed one. Only the replay
+// goroutine reads, so readOff only moves here and in consumed.
+func (s *spillFile) next(n int) ([]*collector.RawEvent, error) {
+	s.mu.Lock()
+	off, end := s.readOff, s.writeOff
+	s.mu.Unlock()
+	if off >= end {
+		return nil, nil
+	}
+
+	buf := make([]byte, min(end-off, maxSpillLine))
+	read, err := s.file.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	buf = buf[:read]
+
+	var events []*collector.RawEvent
+	var skip int64
+	s.unacked = s.unacked[:0]
+	for len(events) < n {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		var e collector.RawEvent
+		if err := json.Unmarshal(buf[:i], &e); err != nil {
+			skip += int64(i + 1)
+		} else {
+			events = append(events, &e)
+			s.unacked = append(s.unacked, skip+int64(i+1))
+			skip = 0
+		}
+		buf = buf[i+1:]
+	}
+	if len(events) == 0 && skip > 0 {
+		s.mu.Lock()
+		s.readOff += skip
+		s.mu.Unlock()
+	}
+	return events, nil
+}
+
+// consumed advances past the next event returned by next, truncating the
+// file once it is fully read.
+func (s *spillFile) consumed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOff += s.unacked[0]
+	s.unacked = s.unacked[1:]
+	if s.readOff >= s.writeOff {
+		s.file.Truncate(0)
+		s.readOff, s.writeOff = 0, 0
+	}
+}
+
+// close removes a fully read file, or compacts unread events to its start
+// so a later instance replays them.
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer spillsInUse.Delete(s.path)
+
+	if s.readOff >= s.writeOff {
+		s.file.Close()
+		return os.Remove(s.path)
+	}
+	if s.readOff > 0 {
+		rest := make([]byte, s.writeOff-s.readOff)
+		if _, err := s.file.ReadAt(rest, s.readOff); err != nil && !errors.Is(err, io.EOF) {
+			s.file.Close()
+			return err
+		}
+		if _, err := s.file.WriteAt(rest, 0); err != nil {
+			s.file.Close()
+			return err
+		}
+		if err := s.file.Truncate(int64(len(rest))); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	return s.file.Close()
+}
+
+var _ Forwarder = (*BufferedForwarder)(nil)
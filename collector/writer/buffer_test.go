@@ -0,0 +1,160 @@
+package writer
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// blockingForwarder blocks in Forward until released.
+type blockingForwarder struct {
+	mockForwarder
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingForwarder() *blockingForwarder {
+	return &blockingForwarder{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (b *blockingForwarder) Forward(event *collector.RawEvent) {
+	b.started <- struct{}{}
+	<-b.release
+	b.mockForwarder.Forward(event)
+}
+
+func (b *blockingForwarder) unblock() { b.once.Do(func() { close(b.release) }) }
+
+func (b *blockingForwarder) names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for _, e := range b.events {
+		names = append(names, e.Event)
+	}
+	return names
+}
+
+func events(n int) []*collector.RawEvent {
+	out := make([]*collector.RawEvent, n)
+	for i := range out {
+		out[i] = &collector.RawEvent{Event: fmt.Sprintf("e%d", i)}
+	}
+	return out
+}
+
+func TestBufferedForwarder_Overflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   []string
+	}{
+		{OverflowDropOldest, []string{"e0", "e3", "e4"}},
+		{OverflowDropNewest, []string{"e0", "e1", "e2"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			next := newBlockingForwarder()
+			f, err := NewBufferedForwarder("test", next, Buffer{Size: 2, Overflow: tc.policy}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evs := events(5)
+			f.Forward(evs[0])
+			<-next.started // the worker holds e0 and is blocked
+
+			start := time.Now()
+			for _, e := range evs[1:] {
+				f.Forward(e)
+			}
+			if d := time.Since(start); d > 100*time.Millisecond {
+				t.Errorf("Forward blocked for %v", d)
+			}
+
+			next.unblock()
+			f.Close()
+			if got := next.names(); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("delivered %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBufferedForwarder_SpillToDisk(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Buffer{Size: 1, Overflow: OverflowSpillToDisk, SpillDir: dir}
+
+	next := newBlockingForwarder()
+	f, err := NewBufferedForwarder("test", next, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs := events(6)
+	f.Forward(evs[0])
+	<-next.started
+	for _, e := range evs[1:4] {
+		f.Forward(e)
+	}
+	// e1 is buffered, e2 and e3 spilled. Release once closing has begun so
+	// the spill is not replayed before shutdown.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		next.unblock()
+	}()
+	f.Close()
+	if got := next.names(); fmt.Sprint(got) != "[e0 e1]" {
+		t.Fatalf("delivered %v before restart", got)
+	}
+
+	// After a restart the spilled events are replayed.
+	next = newBlockingForwarder()
+	next.unblock()
+	f, err = NewBufferedForwarder("test", next, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Forward(evs[4])
+	f.Forward(evs[5])
+	deadline := time.Now().Add(2 * time.Second)
+	for next.count() < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	f.Close()
+	got := next.names()
+	slices.Sort(got)
+	if fmt.Sprint(got) != "[e2 e3 e4 e5]" {
+		t.Errorf("delivered %v after restart", got)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.spill.jsonl")); len(files) != 0 {
+		t.Errorf("expected replayed spill files to be removed, found %v", files)
+	}
+}
+
+func TestBufferedForwarder_RoutedEventsOnly(t *testing.T) {
+	next := newBlockingForwarder()
+	buffered, err := NewBufferedForwarder("test", next, Buffer{Size: 2, Overflow: OverflowDropNewest}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewRoutedForwarder(buffered, &Routing{Include: []Rule{{Events: []string{"ai.*"}}}})
+
+	f.Forward(&collector.RawEvent{Event: "ai.first"})
+	<-next.started
+	// Rejected events never reach the buffer, so they cannot crowd out
+	// the events the route exists for.
+	for range 10 {
+		f.Forward(&collector.RawEvent{Event: "$pageview"})
+	}
+	f.Forward(&collector.RawEvent{Event: "ai.second"})
+
+	next.unblock()
+	f.Close()
+	if got := next.names(); fmt.Sprint(got) != "[ai.first ai.second]" {
+		t.Errorf("delivered %v", got)
+	}
+}
@@ -0,0 +1,108 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hanzoai/analytics/collector/catalog"
+)
+
+// CatalogStore persists the event catalog in the catalog table of each
+// organization's database, next to its events.
+type CatalogStore struct {
+	w *Writer
+}
+
+var _ catalog.Store = (*CatalogStore)(nil)
+
+// CatalogStore returns a catalog store using the writer's connection and
+// tenant databases.
+func (w *Writer) CatalogStore() *CatalogStore {
+	return &CatalogStore{w: w}
+}
+
+// databases returns the default database followed by each tenant database.
+func (w *Writer) databases() []string {
+	out := []string{w.config.Names.Database}
+	seen := map[string]bool{w.config.Names.Database: true}
+	var tenants []string
+	for _, database := range w.config.Tenants {
+		if !seen[database] {
+			seen[database] = true
+			tenants = append(tenants, database)
+		}
+	}
+	sort.Strings(tenants)
+	return append(out, tenants...)
+}
+
+func (s *CatalogStore) table(database string) string {
+	return database + "." + s.w.config.Names.Catalog
+}
+
+// Load reads the latest saved entries from every database.
+func (s *CatalogStore) Load(ctx context.Context) ([]catalog.Entry, error) {
+	var out []catalog.Entry
+	for _, database := range s.w.databases() {
+		rows, err := s.w.conn.Query(ctx, `SELECT organization_id, event, property, types, first_seen, last_seen, count, samples, drift
+FROM `+s.table(database)+` FINAL`)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", database, err)
+		}
+		for rows.Next() {
+			var e catalog.Entry
+			if err := rows.Scan(&e.OrganizationID, &e.Event, &e.Property, &e.Types, &e.FirstSeen, &e.LastSeen, &e.Count, &e.Samples, &e.Drift); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("%s: %w", database, err)
+			}
+			out = append(out, e)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", database, err)
+		}
+	}
+	return out, nil
+}
+
+// Save writes entries to their organization's database.
+func (s *CatalogStore) Save(ctx context.Context, entries []catalog.Entry) error {
+	byDatabase := make(map[string][]catalog.Entry)
+	for _, e := range entries {
+		database := s.w.database(e.OrganizationID)
+		byDatabase[database] = append(byDatabase[database], e)
+	}
+
+	var errs []error
+	for database, entries := range byDatabase {
+		if err := s.save(ctx, database, entries); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", database, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *CatalogStore) save(ctx context.Context, database string, entries []catalog.Entry) error {
+	batch, err := s.w.conn.PrepareBatch(ctx, "INSERT INTO "+s.table(database)+
+		" (organization_id, event, property, types, first_seen, last_seen, count, samples, drift)")
+	if err != nil {
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+	for _, e := range entries {
+		types, samples := e.Types, e.Samples
+		if types == nil {
+			types = []string{}
+		}
+		if samples == nil {
+			samples = []string{}
+		}
+		if err := batch.Append(e.OrganizationID, e.Event, e.Property, types, e.FirstSeen, e.LastSeen, e.Count, samples, e.Drift); err != nil {
+			batch.Abort()
+			return fmt.Errorf("append: %w", err)
+		}
+	}
+	return batch.Send()
+}
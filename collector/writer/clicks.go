@@ -0,0 +1,187 @@
+package writer
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// Click is an ad click ID and when it was seen.
+type Click struct {
+	ID string
+	At time.Time
+}
+
+// ClickMemory remembers the latest ad click per key (for example an
+// organization and distinct ID) so conversions that happen after the
+// landing page can still be attributed. It holds at most max keys, evicting
+// the least recently used, and forgets clicks older than ttl.
+type ClickMemory struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type clickEntry struct {
+	key   string
+	click Click
+}
+
+// NewClickMemory creates a click memory.
+func NewClickMemory(max int, ttl time.Duration) *ClickMemory {
+	return &ClickMemory{
+		max:     max,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Remember records click for key unless a newer click is already known.
+func (m *ClickMemory) Remember(key string, click Click) {
+	if key == "" || click.ID == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*clickEntry)
+		if !click.At.Before(e.click.At) {
+			e.click = click
+		}
+		m.lru.MoveToFront(el)
+		return
+	}
+	m.entries[key] = m.lru.PushFront(&clickEntry{key: key, click: click})
+	for m.lru.Len() > m.max {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*clickEntry).key)
+	}
+}
+
+// Recall returns the click for key if it is no older than ttl at now.
+func (m *ClickMemory) Recall(key string, now time.Time) (Click, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return Click{}, false
+	}
+	e := el.Value.(*clickEntry)
+	if m.ttl > 0 && now.Sub(e.click.At) > m.ttl {
+		m.lru.Remove(el)
+		delete(m.entries, key)
+		return Click{}, false
+	}
+	m.lru.MoveToFront(el)
+	return e.click, true
+}
+
+// ClickSource finds a person's latest ad click in stored events, for
+// conversions whose click is no longer in a ClickMemory, for example after
+// a restart.
+type ClickSource interface {
+	// LatestGCLID returns the person's latest gclid seen in [since, until].
+	LatestGCLID(ctx context.Context, organizationID, distinctID string, since, until time.Time) (Click, bool, error)
+}
+
+// ClickStore looks up ad clicks in the events table of each organization's
+// database.
+type ClickStore struct {
+	w *Writer
+}
+
+var _ ClickSource = (*ClickStore)(nil)
+
+// ClickStore returns a click store using the writer's connection and tenant
+// databases.
+func (w *Writer) ClickStore() *ClickStore {
+	return &ClickStore{w: w}
+}
+
+// LatestGCLID reads the person's most recent event with a gclid.
+func (s *ClickStore) LatestGCLID(ctx context.Context, organizationID, distinctID string, since, until time.Time) (Click, bool, error) {
+	table := s.w.database(organizationID) + "." + s.w.config.Names.Events
+	rows, err := s.w.conn.Query(ctx, `SELECT gclid, timestamp FROM `+table+`
+WHERE organization_id = ? AND distinct_id = ? AND gclid != '' AND timestamp >= ? AND timestamp <= ?
+ORDER BY timestamp DESC LIMIT 1`, organizationID, distinctID, since, until)
+	if err != nil {
+		return Click{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return Click{}, false, rows.Err()
+	}
+	var click Click
+	if err := rows.Scan(&click.ID, &click.At); err != nil {
+		return Click{}, false, err
+	}
+	return click, true, nil
+}
+
+// gclidTTL is how long a gclid is attributed to later conversions,
+// matching the longest Google Ads click-through window.
+const gclidTTL = 90 * 24 * time.Hour
+
+// gclidLookupTimeout bounds a stored click lookup on the write path.
+const gclidLookupTimeout = 2 * time.Second
+
+// SetConversions sets the event names that are attributed to the person's
+// or session's latest gclid when they arrive without one.
+func (w *Writer) SetConversions(events []string) {
+	set := make(map[string]bool, len(events))
+	for _, e := range events {
+		set[e] = true
+	}
+	w.conversions.Store(&set)
+}
+
+// attributeClick remembers an event's gclid per person and session, and
+// sets a conversion's missing gclid to the latest click, so the stored row
+// and every forwarder see the click it is attributed to. Clicks not in
+// memory, after a restart for example, are looked up in stored events by
+// person.
+func (w *Writer) attributeClick(ctx context.Context, event *collector.RawEvent) {
+	person := event.OrganizationID + "\x00" + event.DistinctID
+	var session string
+	if event.SessionID != "" {
+		session = event.OrganizationID + "\x00session\x00" + event.SessionID
+	}
+	if event.GCLID != "" {
+		click := Click{ID: event.GCLID, At: event.Timestamp}
+		w.clicks.Remember(person, click)
+		w.clicks.Remember(session, click)
+		return
+	}
+	if conversions := w.conversions.Load(); conversions == nil || !(*conversions)[event.Event] {
+		return
+	}
+
+	click, ok := w.clicks.Recall(person, event.Timestamp)
+	if !ok && session != "" {
+		click, ok = w.clicks.Recall(session, event.Timestamp)
+	}
+	if !ok && w.clickSource != nil && event.DistinctID != "" {
+		ctx, cancel := context.WithTimeout(ctx, gclidLookupTimeout)
+		defer cancel()
+		var err error
+		click, ok, err = w.clickSource.LatestGCLID(ctx, event.OrganizationID, event.DistinctID, event.Timestamp.Add(-gclidTTL), event.Timestamp)
+		if err != nil {
+			w.logger.Warn("gclid lookup failed", "organization_id", event.OrganizationID, "error", err)
+		}
+		if ok {
+			w.clicks.Remember(person, click)
+		}
+	}
+	if ok {
+		event.GCLID = click.ID
+	}
+}
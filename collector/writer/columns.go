@@ -0,0 +1,280 @@
+package writer
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// eventColumn builds one events table column for a whole batch, as the
+// typed slice the driver encodes directly into a native block.
+type eventColumn struct {
+	name   string
+	values func(events []*collector.RawEvent, now time.Time) any
+}
+
+func stringColumn(name string, get func(*collector.RawEvent) string) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]string, len(events))
+		for i, e := range events {
+			out[i] = get(e)
+		}
+		return out
+	}}
+}
+
+func jsonColumn(name string, get func(*collector.RawEvent) map[string]interface{}) eventColumn {
+	return stringColumn(name, func(e *collector.RawEvent) string {
+		props := get(e)
+		if len(props) == 0 {
+			return "{}"
+		}
+		data, err := json.Marshal(props)
+		if err != nil {
+			return "{}"
+		}
+		return string(data)
+	})
+}
+
+func timeColumn(name string, get func(*collector.RawEvent) time.Time) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]time.Time, len(events))
+		for i, e := range events {
+			out[i] = get(e)
+		}
+		return out
+	}}
+}
+
+func decimalColumn(name string, get func(*collector.RawEvent) float64) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]decimal.Decimal, len(events))
+		for i, e := range events {
+			out[i] = decimal.NewFromFloat(get(e))
+		}
+		return out
+	}}
+}
+
+// Typed property columns split a property map by JSON type. Nested objects
+// and arrays are stored as JSON text with the strings.
+func stringPropsColumn(name string, get func(*collector.RawEvent) map[string]interface{}) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]map[string]string, len(events))
+		for i, e := range events {
+			out[i] = stringProps(get(e))
+		}
+		return out
+	}}
+}
+
+func numberPropsColumn(name string, get func(*collector.RawEvent) map[string]interface{}) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]map[string]float64, len(events))
+		for i, e := range events {
+			out[i] = numberProps(get(e))
+		}
+		return out
+	}}
+}
+
+func boolPropsColumn(name string, get func(*collector.RawEvent) map[string]interface{}) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]map[string]bool, len(events))
+		for i, e := range events {
+			out[i] = boolProps(get(e))
+		}
+		return out
+	}}
+}
+
+// stringProps returns the string properties; nested objects and arrays are
+// kept as JSON text.
+func stringProps(props map[string]interface{}) map[string]string {
+	out := map[string]string{}
+	for k, v := range props {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case map[string]interface{}, []interface{}:
+			if data, err := json.Marshal(v); err == nil {
+				out[k] = string(data)
+			}
+		}
+	}
+	return out
+}
+
+// numberProps returns the numeric properties.
+func numberProps(props map[string]interface{}) map[string]float64 {
+	out := map[string]float64{}
+	for k, v := range props {
+		if f, ok := number(v); ok {
+			out[k] = f
+		}
+	}
+	return out
+}
+
+// boolProps returns the boolean properties.
+func boolProps(props map[string]interface{}) map[string]bool {
+	out := map[string]bool{}
+	for k, v := range props {
+		if b, ok := v.(bool); ok {
+			out[k] = b
+		}
+	}
+	return out
+}
+
+// number converts decoded JSON and Go numeric property values to float64.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// uint32Column clamps counts into the column's range.
+func uint32Column(name string, get func(*collector.RawEvent) int) eventColumn {
+	return eventColumn{name, func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]uint32, len(events))
+		for i, e := range events {
+			out[i] = uint32(min(max(get(e), 0), math.MaxUint32))
+		}
+		return out
+	}}
+}
+
+// eventColumns are the events table columns the writer fills, in insert
+// order. Columns not listed (_partition_date) use their defaults.
+var eventColumns = []eventColumn{
+	{"event_id", func(events []*collector.RawEvent, _ time.Time) any {
+		out := make([]uuid.UUID, len(events))
+		for i, e := range events {
+			out[i] = EventUUID(e)
+		}
+		return out
+	}},
+	stringColumn("distinct_id", func(e *collector.RawEvent) string { return e.DistinctID }),
+	stringColumn("event", func(e *collector.RawEvent) string { return e.Event }),
+	timeColumn("timestamp", func(e *collector.RawEvent) time.Time { return e.Timestamp }),
+	timeColumn("sent_at", func(e *collector.RawEvent) time.Time { return e.SentAt }),
+	{"created_at", func(events []*collector.RawEvent, now time.Time) any {
+		out := make([]time.Time, len(events))
+		for i := range out {
+			out[i] = now
+		}
+		return out
+	}},
+	stringColumn("organization_id", func(e *collector.RawEvent) string { return e.OrganizationID }),
+	stringColumn("project_id", func(e *collector.RawEvent) string { return e.ProjectID }),
+	stringColumn("session_id", func(e *collector.RawEvent) string { return e.SessionID }),
+	stringColumn("visit_id", func(e *collector.RawEvent) string { return e.VisitID }),
+	jsonColumn("properties", func(e *collector.RawEvent) map[string]interface{} { return e.Properties }),
+	stringPropsColumn("properties_string", func(e *collector.RawEvent) map[string]interface{} { return e.Properties }),
+	numberPropsColumn("properties_number", func(e *collector.RawEvent) map[string]interface{} { return e.Properties }),
+	boolPropsColumn("properties_bool", func(e *collector.RawEvent) map[string]interface{} { return e.Properties }),
+	jsonColumn("person_properties", func(e *collector.RawEvent) map[string]interface{} { return e.PersonProperties }),
+	stringPropsColumn("person_properties_string", func(e *collector.RawEvent) map[string]interface{} { return e.PersonProperties }),
+	numberPropsColumn("person_properties_number", func(e *collector.RawEvent) map[string]interface{} { return e.PersonProperties }),
+	boolPropsColumn("person_properties_bool", func(e *collector.RawEvent) map[string]interface{} { return e.PersonProperties }),
+	stringColumn("group_type", func(e *collector.RawEvent) string { return e.GroupType }),
+	stringColumn("group_key", func(e *collector.RawEvent) string { return e.GroupKey }),
+	jsonColumn("group_properties", func(e *collector.RawEvent) map[string]interface{} { return e.GroupProperties }),
+	stringPropsColumn("group_properties_string", func(e *collector.RawEvent) map[string]interface{} { return e.GroupProperties }),
+	numberPropsColumn("group_properties_number", func(e *collector.RawEvent) map[string]interface{} { return e.GroupProperties }),
+	boolPropsColumn("group_properties_bool", func(e *collector.RawEvent) map[string]interface{} { return e.GroupProperties }),
+	stringColumn("url", func(e *collector.RawEvent) string { return e.URL }),
+	stringColumn("url_path", func(e *collector.RawEvent) string { return e.URLPath }),
+	stringColumn("referrer", func(e *collector.RawEvent) string { return e.Referrer }),
+	stringColumn("referrer_domain", func(e *collector.RawEvent) string { return e.ReferrerDomain }),
+	stringColumn("hostname", func(e *collector.RawEvent) string { return e.Hostname }),
+	stringColumn("browser", func(e *collector.RawEvent) string { return e.Browser }),
+	stringColumn("browser_version", func(e *collector.RawEvent) string { return e.BrowserVersion }),
+	stringColumn("os", func(e *collector.RawEvent) string { return e.OS }),
+	stringColumn("os_version", func(e *collector.RawEvent) string { return e.OSVersion }),
+	stringColumn("device", func(e *collector.RawEvent) string { return e.Device }),
+	stringColumn("device_type", func(e *collector.RawEvent) string { return e.DeviceType }),
+	stringColumn("screen", func(e *collector.RawEvent) string { return e.Screen }),
+	stringColumn("language", func(e *collector.RawEvent) string { return e.Language }),
+	stringColumn("country", func(e *collector.RawEvent) string { return e.Country }),
+	stringColumn("region", func(e *collector.RawEvent) string { return e.Region }),
+	stringColumn("city", func(e *collector.RawEvent) string { return e.City }),
+	stringColumn("utm_source", func(e *collector.RawEvent) string { return e.UTMSource }),
+	stringColumn("utm_medium", func(e *collector.RawEvent) string { return e.UTMMedium }),
+	stringColumn("utm_campaign", func(e *collector.RawEvent) string { return e.UTMCampaign }),
+	stringColumn("utm_content", func(e *collector.RawEvent) string { return e.UTMContent }),
+	stringColumn("utm_term", func(e *collector.RawEvent) string { return e.UTMTerm }),
+	stringColumn("gclid", func(e *collector.RawEvent) string { return e.GCLID }),
+	stringColumn("fbclid", func(e *collector.RawEvent) string { return e.FBCLID }),
+	stringColumn("msclkid", func(e *collector.RawEvent) string { return e.MSCLID }),
+	stringColumn("ip", func(e *collector.RawEvent) string { return e.IP }),
+	stringColumn("user_agent", func(e *collector.RawEvent) string { return e.UserAgent }),
+	stringColumn("order_id", func(e *collector.RawEvent) string { return e.OrderID }),
+	stringColumn("product_id", func(e *collector.RawEvent) string { return e.ProductID }),
+	stringColumn("cart_id", func(e *collector.RawEvent) string { return e.CartID }),
+	decimalColumn("revenue", func(e *collector.RawEvent) float64 { return e.Revenue }),
+	stringColumn("currency", func(e *collector.RawEvent) string { return e.Currency }),
+	stringColumn("base_currency", func(e *collector.RawEvent) string { return e.BaseCurrency }),
+	decimalColumn("revenue_base", func(e *collector.RawEvent) float64 { return e.BaseRevenue }),
+	uint32Column("quantity", func(e *collector.RawEvent) int { return e.Quantity }),
+	stringColumn("ast_context", func(e *collector.RawEvent) string { return e.ASTContext }),
+	stringColumn("ast_type", func(e *collector.RawEvent) string { return e.ASTType }),
+	stringColumn("page_title", func(e *collector.RawEvent) string { return e.PageTitle }),
+	stringColumn("page_description", func(e *collector.RawEvent) string { return e.PageDescription }),
+	stringColumn("page_type", func(e *collector.RawEvent) string { return e.PageType }),
+	stringColumn("element_id", func(e *collector.RawEvent) string { return e.ElementID }),
+	stringColumn("element_type", func(e *collector.RawEvent) string { return e.ElementType }),
+	stringColumn("element_selector", func(e *collector.RawEvent) string { return e.ElementSelector }),
+	stringColumn("element_text", func(e *collector.RawEvent) string { return e.ElementText }),
+	stringColumn("element_href", func(e *collector.RawEvent) string { return e.ElementHref }),
+	stringColumn("section_name", func(e *collector.RawEvent) string { return e.SectionName }),
+	stringColumn("section_type", func(e *collector.RawEvent) string { return e.SectionType }),
+	stringColumn("section_id", func(e *collector.RawEvent) string { return e.SectionID }),
+	stringColumn("component_path", func(e *collector.RawEvent) string { return e.ComponentPath }),
+	stringColumn("component_data", func(e *collector.RawEvent) string { return e.ComponentData }),
+	stringColumn("model_provider", func(e *collector.RawEvent) string { return e.ModelProvider }),
+	stringColumn("model_name", func(e *collector.RawEvent) string { return e.ModelName }),
+	uint32Column("token_count", func(e *collector.RawEvent) int { return e.TokenCount }),
+	decimalColumn("token_price", func(e *collector.RawEvent) float64 { return e.TokenPrice }),
+	uint32Column("prompt_tokens", func(e *collector.RawEvent) int { return e.PromptTokens }),
+	uint32Column("output_tokens", func(e *collector.RawEvent) int { return e.OutputTokens }),
+	stringColumn("lib", func(e *collector.RawEvent) string { return e.Lib }),
+	stringColumn("lib_version", func(e *collector.RawEvent) string { return e.LibVersion }),
+}
+
+// eventColumnList names every column in eventColumns, in order.
+var eventColumnList = func() string {
+	names := make([]string, len(eventColumns))
+	for i, c := range eventColumns {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}()
+
+// insertEventsQuery inserts eventColumns into the events table of database.
+func insertEventsQuery(database, table string) string {
+	return "INSERT INTO " + database + "." + table + " (" + eventColumnList + ")"
+}
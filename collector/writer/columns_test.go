@@ -0,0 +1,180 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+	"github.com/hanzoai/analytics/collector/migrate"
+)
+
+func TestEventColumns_MatchSchema(t *testing.T) {
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defined := map[string]bool{}
+	for _, m := range migrations {
+		sql, err := m.Render(migrate.DefaultNames())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range migrate.Statements(sql) {
+			if table, ok := strings.CutPrefix(stmt, "CREATE TABLE IF NOT EXISTS commerce.events ("); ok {
+				table = table[:strings.Index(table, "ENGINE")]
+				for _, c := range regexp.MustCompile(`(?m)^\s+(\w+) `).FindAllStringSubmatch(table, -1) {
+					defined[c[1]] = true
+				}
+			}
+			if strings.HasPrefix(stmt, "ALTER TABLE commerce.events\n") || strings.HasPrefix(stmt, "ALTER TABLE commerce.events ") {
+				for _, c := range regexp.MustCompile(`ADD COLUMN IF NOT EXISTS (\w+) `).FindAllStringSubmatch(stmt, -1) {
+					defined[c[1]] = true
+				}
+			}
+		}
+	}
+
+	events := []*collector.RawEvent{
+		{Event: "a", Revenue: 12.5, Quantity: -1, Properties: map[string]interface{}{"k": "v"}},
+		{Event: "b", TokenCount: 3},
+	}
+	for _, col := range eventColumns {
+		if !defined[col.name] {
+			t.Errorf("column %s is not in commerce.events", col.name)
+		}
+		v := reflect.ValueOf(col.values(events, time.Now()))
+		if v.Kind() != reflect.Slice || v.Len() != len(events) {
+			t.Errorf("column %s: expected a slice of %d values, got %T", col.name, len(events), v.Interface())
+		}
+	}
+
+	for _, col := range eventColumns {
+		if col.name != "properties" {
+			continue
+		}
+		if got := col.values(events, time.Time{}).([]string); got[0] != `{"k":"v"}` || got[1] != "{}" {
+			t.Errorf("unexpected properties column %v", got)
+		}
+	}
+}
+
+// BenchmarkInsert compares one async INSERT per event with a columnar
+// batch, with and without server-side async inserts. It needs a datastore:
+// COLLECTOR_BENCH_DSN=clickhouse://localhost:9000 go test -bench Insert ./writer
+func BenchmarkInsert(b *testing.B) {
+	dsn := os.Getenv("COLLECTOR_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("COLLECTOR_BENCH_DSN is not set")
+	}
+	w, err := New(&Config{DSN: dsn, BatchSize: 500, FlushInterval: time.Hour, BufferSize: 1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	ctx := context.Background()
+	m, err := migrate.New(w.conn, w.config.Names, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		b.Fatal(err)
+	}
+	defer w.conn.Exec(ctx, "DELETE FROM commerce.events WHERE organization_id = 'bench'")
+
+	events := make([]*collector.RawEvent, 500)
+	for i := range events {
+		events[i] = &collector.RawEvent{
+			Event: "$pageview", DistinctID: fmt.Sprintf("user-%d", i), OrganizationID: "bench",
+			Timestamp: time.Now(), SentAt: time.Now(), URL: "https://example.com/", Lib: "bench",
+			Properties: map[string]interface{}{"i": i},
+		}
+	}
+
+	b.Run("row_async", func(b *testing.B) {
+		query := insertEventsQuery("commerce", "events") + " VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(eventColumns)), ", ") + ")"
+		for b.Loop() {
+			for _, e := range events {
+				if err := w.conn.AsyncInsert(ctx, query, false, rowArgs(e)...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(b.N*len(events))/b.Elapsed().Seconds(), "events/s")
+	})
+
+	for _, async := range []bool{false, true} {
+		b.Run(fmt.Sprintf("columnar_async=%t", async), func(b *testing.B) {
+			w.config.AsyncInsert = async
+			for b.Loop() {
+				if _, err := w.insertBatch(ctx, events); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(events))/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
+
+// rowArgs flattens one event's column values into INSERT arguments.
+func rowArgs(e *collector.RawEvent) []any {
+	now := time.Now()
+	args := make([]any, len(eventColumns))
+	for i, col := range eventColumns {
+		args[i] = reflect.ValueOf(col.values([]*collector.RawEvent{e}, now)).Index(0).Interface()
+	}
+	return args
+}
+
+func TestPropertyColumns_SplitByType(t *testing.T) {
+	events := []*collector.RawEvent{{Properties: map[string]interface{}{
+		"plan": "pro", "seats": float64(3), "count": 2, "beta": true,
+		"tags": []interface{}{"a"}, "nested": map[string]interface{}{"x": 1.5}, "none": nil,
+	}}}
+	get := func(e *collector.RawEvent) map[string]interface{} { return e.Properties }
+
+	strs := stringPropsColumn("s", get).values(events, time.Time{}).([]map[string]string)[0]
+	if !reflect.DeepEqual(strs, map[string]string{"plan": "pro", "tags": `["a"]`, "nested": `{"x":1.5}`}) {
+		t.Errorf("unexpected string properties %v", strs)
+	}
+	nums := numberPropsColumn("n", get).values(events, time.Time{}).([]map[string]float64)[0]
+	if !reflect.DeepEqual(nums, map[string]float64{"seats": 3, "count": 2}) {
+		t.Errorf("unexpected number properties %v", nums)
+	}
+	bools := boolPropsColumn("b", get).values(events, time.Time{}).([]map[string]bool)[0]
+	if !reflect.DeepEqual(bools, map[string]bool{"beta": true}) {
+		t.Errorf("unexpected bool properties %v", bools)
+	}
+}
+
+// Archives must load back into the events table, so every archived field
+// is named after an events column and every column written is archived.
+func TestArchiveEvent_MatchesEventColumns(t *testing.T) {
+	columns := map[string]bool{}
+	for _, col := range eventColumns {
+		columns[col.name] = true
+	}
+	archived := map[string]bool{}
+	typ := reflect.TypeOf(forward.ArchiveEvent{})
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		for _, tag := range []string{"json", "parquet"} {
+			name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+			if !columns[name] {
+				t.Errorf("ArchiveEvent.%s: %s name %q is not an events column", f.Name, tag, name)
+			}
+			archived[name] = true
+		}
+	}
+	for _, col := range eventColumns {
+		if !archived[col.name] {
+			t.Errorf("events column %q is not archived", col.name)
+		}
+	}
+}
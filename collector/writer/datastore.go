@@ -2,9 +2,11 @@ package writer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// hanzoai/datastore uses the ClickHouse wire protocol; this driver is
@@ -12,10 +14,21 @@ import (
 	ds "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/currency"
+	"github.com/hanzoai/analytics/collector/logging"
+	"github.com/hanzoai/analytics/collector/metrics"
+	"github.com/hanzoai/analytics/collector/migrate"
+	"github.com/hanzoai/analytics/collector/tracing"
 )
 
 // Forwarder is an optional event forwarder called for every event written.
+// Forward runs on the request path and must not block; wrap forwarders that
+// do network or disk I/O in a BufferedForwarder.
 type Forwarder interface {
 	Forward(event *collector.RawEvent)
 	Close() error
@@ -28,7 +41,35 @@ type Config struct {
 	FlushInterval time.Duration
 	AsyncInsert   bool
 	BufferSize    int
-	Forwarders    []Forwarder
+	// HighWatermark and LowWatermark are fractions of BufferSize: events
+	// are refused once the queue reaches the high mark, until it drains to
+	// the low one.
+	HighWatermark float64
+	LowWatermark  float64
+	// MaxConcurrentInserts caps concurrent datastore inserts; the limit
+	// adapts between 1 and this while batches finish within
+	// TargetInsertLatency.
+	MaxConcurrentInserts int
+	TargetInsertLatency  time.Duration
+	// Names are the database and events table written to; empty names
+	// take their defaults.
+	Names migrate.Names
+	// DedupWindow is how long accepted client event IDs are remembered;
+	// retries within it are acknowledged without being written. DedupSize
+	// bounds how many IDs are remembered. A zero window disables this.
+	DedupWindow time.Duration
+	DedupSize   int
+	// Tenants maps organization IDs to a database of their own with the
+	// same tables. Other organizations are written to Names.Database.
+	Tenants map[string]string
+	// Currency normalizes revenue to each organization's base currency;
+	// nil takes USD as every organization's base.
+	Currency *currency.Normalizer
+	// Conversions are event names given the latest gclid of their person
+	// or session when they arrive without one.
+	Conversions []string
+	Forwarders  []Forwarder
+	Logger      *slog.Logger
 }
 
 // DefaultConfig returns sensible defaults.
@@ -38,6 +79,13 @@ func DefaultConfig() *Config {
 		FlushInterval: 5 * time.Second,
 		AsyncInsert:   true,
 		BufferSize:    10000,
+		HighWatermark: 0.8,
+		LowWatermark:  0.5,
+
+		MaxConcurrentInserts: 4,
+		TargetInsertLatency:  2 * time.Second,
+		DedupWindow:          10 * time.Minute,
+		DedupSize:            1000000,
 	}
 }
 
@@ -45,10 +93,37 @@ func DefaultConfig() *Config {
 type Writer struct {
 	conn    driver.Conn
 	config  *Config
-	eventCh chan *collector.RawEvent
-	wg      sync.WaitGroup
-	closed  bool
-	mu      sync.RWMutex
+	logger  *slog.Logger
+	eventCh chan queued
+	tuneCh  chan Tuning
+
+	forwarders atomic.Pointer[[]Forwarder]
+	currency   atomic.Pointer[currency.Normalizer]
+	dedup      *dedup
+
+	// Click attribution: see clicks.go.
+	clicks      *ClickMemory
+	clickSource ClickSource
+	conversions atomic.Pointer[map[string]bool]
+
+	// Admission control: see admission.go.
+	limiter       *insertLimiter
+	inserts       sync.WaitGroup
+	failures      atomic.Int32
+	highWatermark int
+	lowWatermark  int
+	shedding      bool
+	admitMu       sync.Mutex
+
+	wg     sync.WaitGroup
+	closed bool
+	mu     sync.RWMutex
+}
+
+// Tuning holds the batching parameters that can change while running.
+type Tuning struct {
+	BatchSize     int
+	FlushInterval time.Duration
 }
 
 // New creates a new datastore writer.
@@ -56,6 +131,28 @@ func New(config *Config) (*Writer, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	defaults := DefaultConfig()
+	if config.HighWatermark <= 0 || config.HighWatermark > 1 {
+		config.HighWatermark = defaults.HighWatermark
+	}
+	if config.LowWatermark <= 0 || config.LowWatermark > config.HighWatermark {
+		config.LowWatermark = min(defaults.LowWatermark, config.HighWatermark)
+	}
+	if config.MaxConcurrentInserts <= 0 {
+		config.MaxConcurrentInserts = defaults.MaxConcurrentInserts
+	}
+	if config.TargetInsertLatency <= 0 {
+		config.TargetInsertLatency = defaults.TargetInsertLatency
+	}
+	config.Names = config.Names.WithDefaults()
+	if err := config.Names.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid datastore names: %w", err)
+	}
+	for org, database := range config.Tenants {
+		if !migrate.ValidIdentifier(database) {
+			return nil, fmt.Errorf("tenant %s: invalid database %q", org, database)
+		}
+	}
 
 	opts, err := ds.ParseDSN(config.DSN)
 	if err != nil {
@@ -74,8 +171,21 @@ func New(config *Config) (*Writer, error) {
 	w := &Writer{
 		conn:    conn,
 		config:  config,
-		eventCh: make(chan *collector.RawEvent, config.BufferSize),
+		logger:  logging.Or(config.Logger).With("subsystem", "writer"),
+		eventCh: make(chan queued, config.BufferSize),
+		tuneCh:  make(chan Tuning, 1),
+		limiter: newInsertLimiter(config.MaxConcurrentInserts, config.TargetInsertLatency),
+		dedup:   newDedup(config.DedupWindow, config.DedupSize),
+		clicks:  NewClickMemory(100000, gclidTTL),
+
+		highWatermark: int(float64(config.BufferSize) * config.HighWatermark),
+		lowWatermark:  int(float64(config.BufferSize) * config.LowWatermark),
 	}
+	forwarders := append([]Forwarder(nil), config.Forwarders...)
+	w.forwarders.Store(&forwarders)
+	w.SetCurrency(config.Currency)
+	w.SetConversions(config.Conversions)
+	w.clickSource = w.ClickStore()
 
 	w.wg.Add(1)
 	go w.processEvents()
@@ -83,25 +193,50 @@ func New(config *Config) (*Writer, error) {
 	return w, nil
 }
 
-// EnsureSchema creates the required tables.
-func (w *Writer) EnsureSchema(ctx context.Context) error {
-	if err := w.conn.Exec(ctx, `CREATE DATABASE IF NOT EXISTS commerce`); err != nil {
-		return fmt.Errorf("create database: %w", err)
-	}
-	// Schema might already exist, that's OK
-	w.conn.Exec(ctx, Schema)
-	return nil
+// queued is an event waiting to be written, together with the span of the
+// request that accepted it so the batch flush can link back to it.
+type queued struct {
+	event *collector.RawEvent
+	span  trace.SpanContext
 }
 
 // Write queues an event for writing and forwards to all configured forwarders.
 func (w *Writer) Write(event *collector.RawEvent) error {
-	w.mu.RLock()
-	if w.closed {
-		w.mu.RUnlock()
-		return fmt.Errorf("writer is closed")
+	return w.WriteContext(context.Background(), event)
+}
+
+// WriteContext is like Write but records the span in ctx so the eventual
+// datastore flush is linked to the request trace. It never writes inline:
+// when the writer cannot take the event it returns ErrOverloaded or
+// ErrUnavailable.
+func (w *Writer) WriteContext(ctx context.Context, event *collector.RawEvent) error {
+	if err := w.Admit(1); err != nil {
+		reason := "overloaded"
+		if errors.Is(err, ErrUnavailable) {
+			reason = "unavailable"
+		}
+		metrics.EventsDropped.WithLabelValues(reason).Inc()
+		return err
+	}
+
+	prepareOrder(event)
+
+	// A retry of an event accepted within the window succeeds without
+	// being written or forwarded again.
+	var key string
+	if w.dedup != nil && event.EventID != "" {
+		key = dedupKey(event)
+		if w.dedup.seen(key, time.Now()) {
+			metrics.EventsDuplicate.Inc()
+			return nil
+		}
 	}
-	w.mu.RUnlock()
 
+	// Events without a client ID get a random one, so every row written
+	// for the event (and every forwarded copy) carries the same event_id.
+	if event.EventID == "" {
+		event.EventID = uuid.NewString()
+	}
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
@@ -111,155 +246,264 @@ func (w *Writer) Write(event *collector.RawEvent) error {
 	if event.Lib == "" {
 		event.Lib = "hanzo-analytics"
 	}
-
-	// Fan out to all configured forwarders (non-blocking, best-effort).
-	for _, f := range w.config.Forwarders {
-		f.Forward(event)
+	if err := w.currency.Load().Normalize(event); err != nil {
+		w.logger.Debug("revenue not normalized", "organization_id", event.OrganizationID, "error", err)
 	}
+	w.attributeClick(ctx, event)
 
-	select {
-	case w.eventCh <- event:
-		return nil
-	default:
-		return w.writeBatch([]*collector.RawEvent{event})
+	q := queued{event: event, span: trace.SpanContextFromContext(ctx)}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	err, reason := ErrUnavailable, "unavailable"
+	if !w.closed {
+		select {
+		case w.eventCh <- q:
+			metrics.EventAccepted(event.OrganizationID, event.Event)
+			// Fan out only once the event is accepted, so a refused event
+			// (and the client's retry of it) reaches destinations once.
+			// Each forwarder only enqueues into its own buffer, so a slow
+			// destination never delays the request.
+			for _, f := range *w.forwarders.Load() {
+				f.Forward(event)
+			}
+			return nil
+		default:
+			err, reason = ErrOverloaded, "overloaded"
+		}
 	}
+	// Not accepted: the client's retry must not count as a duplicate.
+	if key != "" {
+		w.dedup.forget(key)
+	}
+	metrics.EventsDropped.WithLabelValues(reason).Inc()
+	return err
 }
 
 func (w *Writer) processEvents() {
 	defer w.wg.Done()
+	defer w.inserts.Wait()
 
-	batch := make([]*collector.RawEvent, 0, w.config.BatchSize)
+	batchSize := w.config.BatchSize
+	batch := make([]queued, 0, batchSize)
 	ticker := time.NewTicker(w.config.FlushInterval)
 	defer ticker.Stop()
 
+	flush := func() {
+		if len(batch) > 0 {
+			w.insertAsync(batch)
+			batch = make([]queued, 0, batchSize)
+		}
+	}
+
 	for {
 		select {
-		case event, ok := <-w.eventCh:
+		case q, ok := <-w.eventCh:
 			if !ok {
-				if len(batch) > 0 {
-					w.writeBatch(batch)
-				}
+				flush()
 				return
 			}
-			batch = append(batch, event)
-			if len(batch) >= w.config.BatchSize {
-				w.writeBatch(batch)
-				batch = batch[:0]
+			batch = append(batch, q)
+			if len(batch) >= batchSize {
+				flush()
 			}
 		case <-ticker.C:
-			if len(batch) > 0 {
-				w.writeBatch(batch)
-				batch = batch[:0]
+			flush()
+		case t := <-w.tuneCh:
+			batchSize = t.BatchSize
+			ticker.Reset(t.FlushInterval)
+			if len(batch) >= batchSize {
+				flush()
 			}
 		}
 	}
 }
 
-func (w *Writer) writeBatch(events []*collector.RawEvent) error {
-	if len(events) == 0 {
-		return nil
+// insertAsync writes a batch once the concurrency limit allows. While the
+// limit is reached, events accumulate in the queue, which is what the
+// admission watermarks measure.
+func (w *Writer) insertAsync(batch []queued) {
+	w.limiter.acquire()
+	w.inserts.Add(1)
+	go func() {
+		defer w.inserts.Done()
+		start := time.Now()
+		err := w.writeBatch(batch)
+		w.limiter.release(time.Since(start), err)
+		w.recordInsert(err)
+	}()
+}
+
+// SetTuning changes batch size and flush interval without interrupting
+// writes. Queued events are kept.
+func (w *Writer) SetTuning(t Tuning) {
+	if t.BatchSize <= 0 || t.FlushInterval <= 0 {
+		return
+	}
+	// Replace any tuning not yet picked up by processEvents.
+	for {
+		select {
+		case w.tuneCh <- t:
+			return
+		default:
+			select {
+			case <-w.tuneCh:
+			default:
+			}
+		}
 	}
+}
 
-	ctx := context.Background()
+// SetForwarders replaces the forwarder set and returns the previous one.
+// Events already handed to the old forwarders stay queued there; callers
+// should Close them to flush.
+func (w *Writer) SetForwarders(forwarders []Forwarder) []Forwarder {
+	next := append([]Forwarder(nil), forwarders...)
+	return *w.forwarders.Swap(&next)
+}
 
-	if w.config.AsyncInsert {
-		for _, event := range events {
-			w.writeEventAsync(ctx, event)
-		}
+// SetCurrency replaces the revenue normalizer, for example after the
+// exchange rates are reloaded. A nil normalizer takes USD as every
+// organization's base currency.
+func (w *Writer) SetCurrency(n *currency.Normalizer) {
+	if n == nil {
+		n = &currency.Normalizer{Base: "USD"}
+	}
+	w.currency.Store(n)
+}
+
+// QueueDepth returns the number of events waiting to be written.
+func (w *Writer) QueueDepth() int {
+	return len(w.eventCh)
+}
+
+func (w *Writer) writeBatch(batch []queued) error {
+	if len(batch) == 0 {
 		return nil
 	}
 
-	batch, err := w.conn.PrepareBatch(ctx, `INSERT INTO commerce.events`)
+	events := make([]*collector.RawEvent, len(batch))
+	var links []trace.Link
+	for i, q := range batch {
+		events[i] = q.event
+		if q.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: q.span})
+		}
+	}
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "writer.writeBatch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(tracing.Count(len(events)), attribute.Bool("collector.async_insert", w.config.AsyncInsert)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	metrics.BatchSize.Observe(float64(len(events)))
+	defer func() { metrics.FlushDuration.Observe(time.Since(start).Seconds()) }()
+
+	failed, err := w.insertBatch(ctx, events)
+	if failed > 0 {
+		metrics.EventsDropped.WithLabelValues("insert_error").Add(float64(failed))
+		span.SetAttributes(attribute.Int("collector.failed_count", failed))
+	}
 	if err != nil {
-		return fmt.Errorf("prepare batch: %w", err)
-	}
-
-	for _, event := range events {
-		propsJSON, _ := json.Marshal(event.Properties)
-		personPropsJSON, _ := json.Marshal(event.PersonProperties)
-		groupPropsJSON, _ := json.Marshal(event.GroupProperties)
-
-		if err := batch.Append(
-			event.DistinctID, event.Event, event.Timestamp, event.SentAt, time.Now(),
-			event.OrganizationID, event.ProjectID, event.SessionID, event.VisitID,
-			string(propsJSON), string(personPropsJSON),
-			event.GroupType, event.GroupKey, string(groupPropsJSON),
-			event.URL, event.URLPath, event.Referrer, event.ReferrerDomain, event.Hostname,
-			event.Browser, event.BrowserVersion, event.OS, event.OSVersion,
-			event.Device, event.DeviceType, event.Screen, event.Language,
-			event.Country, event.Region, event.City,
-			event.UTMSource, event.UTMMedium, event.UTMCampaign, event.UTMContent, event.UTMTerm,
-			event.GCLID, event.FBCLID, event.MSCLID,
-			event.IP, event.UserAgent,
-			event.OrderID, event.ProductID, event.CartID, event.Revenue, event.Quantity,
-			event.ASTContext, event.ASTType, event.PageTitle, event.PageDescription, event.PageType,
-			event.ElementID, event.ElementType, event.ElementSelector, event.ElementText, event.ElementHref,
-			event.SectionName, event.SectionType, event.SectionID,
-			event.ComponentPath, event.ComponentData,
-			event.ModelProvider, event.ModelName, event.TokenCount, event.TokenPrice, event.PromptTokens, event.OutputTokens,
-			event.Lib, event.LibVersion,
-		); err != nil {
-			batch.Abort()
-			return fmt.Errorf("append to batch: %w", err)
+		w.logger.Error("datastore insert failed", "error", err, "batch_size", len(events), "failed", failed)
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+// asyncInsertSettings make the server buffer inserts and flush them
+// together, acknowledging once the data is written so failures are still
+// reported for the batch.
+var asyncInsertSettings = ds.Settings{
+	"async_insert":          1,
+	"wait_for_async_insert": 1,
+}
+
+// database returns the database events of an organization are written to.
+func (w *Writer) database(orgID string) string {
+	if database, ok := w.config.Tenants[orgID]; ok {
+		return database
+	}
+	return w.config.Names.Database
+}
+
+// insertBatch writes events, one native columnar block per database, and
+// returns how many of them failed to insert.
+func (w *Writer) insertBatch(ctx context.Context, events []*collector.RawEvent) (int, error) {
+	if len(w.config.Tenants) == 0 {
+		return w.insertBlock(ctx, w.config.Names.Database, events)
+	}
+
+	var order []string
+	byDatabase := make(map[string][]*collector.RawEvent)
+	for _, e := range events {
+		database := w.database(e.OrganizationID)
+		if _, ok := byDatabase[database]; !ok {
+			order = append(order, database)
 		}
+		byDatabase[database] = append(byDatabase[database], e)
 	}
 
-	return batch.Send()
+	failed := 0
+	var errs []error
+	for _, database := range order {
+		n, err := w.insertBlock(ctx, database, byDatabase[database])
+		failed += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", database, err))
+		}
+	}
+	return failed, errors.Join(errs...)
 }
 
-func (w *Writer) writeEventAsync(ctx context.Context, event *collector.RawEvent) error {
-	propsJSON, _ := json.Marshal(event.Properties)
-	personPropsJSON, _ := json.Marshal(event.PersonProperties)
-	groupPropsJSON, _ := json.Marshal(event.GroupProperties)
-
-	query := `INSERT INTO commerce.events (
-		distinct_id, event, timestamp, sent_at, created_at,
-		organization_id, project_id, session_id, visit_id,
-		properties, person_properties, group_type, group_key, group_properties,
-		url, url_path, referrer, referrer_domain, hostname,
-		browser, browser_version, os, os_version, device, device_type, screen, language,
-		country, region, city,
-		utm_source, utm_medium, utm_campaign, utm_content, utm_term,
-		gclid, fbclid, msclid,
-		ip, user_agent,
-		order_id, product_id, cart_id, revenue, quantity,
-		ast_context, ast_type, page_title, page_description, page_type,
-		element_id, element_type, element_selector, element_text, element_href,
-		section_name, section_type, section_id,
-		component_path, component_data,
-		model_provider, model_name, token_count, token_price, prompt_tokens, output_tokens,
-		lib, lib_version
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	return w.conn.AsyncInsert(ctx, query, false,
-		event.DistinctID, event.Event, event.Timestamp, event.SentAt, time.Now(),
-		event.OrganizationID, event.ProjectID, event.SessionID, event.VisitID,
-		string(propsJSON), string(personPropsJSON),
-		event.GroupType, event.GroupKey, string(groupPropsJSON),
-		event.URL, event.URLPath, event.Referrer, event.ReferrerDomain, event.Hostname,
-		event.Browser, event.BrowserVersion, event.OS, event.OSVersion,
-		event.Device, event.DeviceType, event.Screen, event.Language,
-		event.Country, event.Region, event.City,
-		event.UTMSource, event.UTMMedium, event.UTMCampaign, event.UTMContent, event.UTMTerm,
-		event.GCLID, event.FBCLID, event.MSCLID,
-		event.IP, event.UserAgent,
-		event.OrderID, event.ProductID, event.CartID, event.Revenue, event.Quantity,
-		event.ASTContext, event.ASTType, event.PageTitle, event.PageDescription, event.PageType,
-		event.ElementID, event.ElementType, event.ElementSelector, event.ElementText, event.ElementHref,
-		event.SectionName, event.SectionType, event.SectionID,
-		event.ComponentPath, event.ComponentData,
-		event.ModelProvider, event.ModelName, event.TokenCount, event.TokenPrice, event.PromptTokens, event.OutputTokens,
-		event.Lib, event.LibVersion,
+// insertBlock writes events to one database as a single native columnar
+// block and returns how many of them failed to insert. A block succeeds or
+// fails as a whole.
+func (w *Writer) insertBlock(ctx context.Context, database string, events []*collector.RawEvent) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "datastore.BatchInsert",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.name", database)),
 	)
+	defer span.End()
+
+	if w.config.AsyncInsert {
+		ctx = ds.Context(ctx, ds.WithSettings(asyncInsertSettings))
+	}
+
+	batch, err := w.conn.PrepareBatch(ctx, insertEventsQuery(database, w.config.Names.Events))
+	if err != nil {
+		metrics.InsertErrors.Inc()
+		tracing.RecordError(span, err)
+		return len(events), fmt.Errorf("prepare batch: %w", err)
+	}
+
+	now := time.Now()
+	for i, col := range eventColumns {
+		if err := batch.Column(i).Append(col.values(events, now)); err != nil {
+			batch.Abort()
+			metrics.InsertErrors.Inc()
+			tracing.RecordError(span, err)
+			return len(events), fmt.Errorf("append column %s: %w", col.name, err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		metrics.InsertErrors.Inc()
+		tracing.RecordError(span, err)
+		return len(events), fmt.Errorf("send batch of %d events: %w", len(events), err)
+	}
+	// The events are written; only their order rows are lost on failure.
+	return 0, w.insertCommerce(ctx, database, events)
 }
 
 // Flush writes all pending events.
 func (w *Writer) Flush() error {
-	batch := make([]*collector.RawEvent, 0, w.config.BatchSize)
+	batch := make([]queued, 0, w.config.BatchSize)
 	for {
 		select {
-		case event := <-w.eventCh:
-			batch = append(batch, event)
+		case q := <-w.eventCh:
+			batch = append(batch, q)
 		default:
 			if len(batch) > 0 {
 				return w.writeBatch(batch)
@@ -282,7 +526,7 @@ func (w *Writer) Close() error {
 	close(w.eventCh)
 	w.wg.Wait()
 
-	for _, f := range w.config.Forwarders {
+	for _, f := range *w.forwarders.Load() {
 		f.Close()
 	}
 
@@ -0,0 +1,24 @@
+package writer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hanzoai/analytics/collector/migrate"
+)
+
+func TestWriter_TenantDatabase(t *testing.T) {
+	w := newTestWriter(1)
+	w.config.Names = migrate.DefaultNames()
+	w.config.Tenants = map[string]string{"org_acme": "acme"}
+
+	if got := w.database("org_acme"); got != "acme" {
+		t.Errorf("expected the tenant database, got %s", got)
+	}
+	if got := w.database("org_other"); got != "commerce" {
+		t.Errorf("expected the default database, got %s", got)
+	}
+	if got := insertEventsQuery("acme", "raw_events"); !strings.HasPrefix(got, "INSERT INTO acme.raw_events (event_id, ") {
+		t.Errorf("unexpected query %s", got)
+	}
+}
@@ -0,0 +1,116 @@
+package writer
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// dedupShards spreads the dedup window over independently locked LRUs so
+// concurrent requests rarely contend.
+const dedupShards = 64
+
+// eventNamespace derives datastore event IDs from client IDs that are not
+// UUIDs.
+var eventNamespace = uuid.MustParse("7f1c2a9e-3b4d-4e5f-8a6b-9c0d1e2f3a4b")
+
+// EventUUID returns the datastore event_id for an event: its client ID when
+// that is a UUID, a UUID derived from the organization and client ID
+// otherwise, or a random UUID when the client sent none.
+func EventUUID(event *collector.RawEvent) uuid.UUID {
+	if event.EventID == "" {
+		return uuid.New()
+	}
+	if id, err := uuid.Parse(event.EventID); err == nil {
+		return id
+	}
+	return uuid.NewSHA1(eventNamespace, []byte(event.OrganizationID+"\x00"+event.EventID))
+}
+
+// dedup remembers recently accepted event IDs for a window, so client
+// retries are acknowledged without being written twice.
+type dedup struct {
+	window time.Duration
+	shards [dedupShards]dedupShard
+}
+
+type dedupShard struct {
+	mu    sync.Mutex
+	max   int
+	seen  map[string]*list.Element
+	order *list.List
+}
+
+type dedupEntry struct {
+	key string
+	at  time.Time
+}
+
+// newDedup remembers up to size IDs for window. A zero window disables it.
+func newDedup(window time.Duration, size int) *dedup {
+	if window <= 0 {
+		return nil
+	}
+	d := &dedup{window: window}
+	for i := range d.shards {
+		d.shards[i] = dedupShard{
+			max:   max(size/dedupShards, 1),
+			seen:  make(map[string]*list.Element),
+			order: list.New(),
+		}
+	}
+	return d
+}
+
+func dedupKey(event *collector.RawEvent) string {
+	return event.OrganizationID + "\x00" + event.EventID
+}
+
+func (d *dedup) shard(key string) *dedupShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &d.shards[h.Sum32()%dedupShards]
+}
+
+// seen records key and reports whether it was already seen within the
+// window.
+func (d *dedup) seen(key string, now time.Time) bool {
+	s := d.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.seen[key]; ok {
+		if now.Sub(el.Value.(*dedupEntry).at) < d.window {
+			return true
+		}
+		s.order.Remove(el)
+	}
+	s.seen[key] = s.order.PushFront(&dedupEntry{key: key, at: now})
+
+	// Evict expired entries, then the oldest while over capacity.
+	for s.order.Len() > 0 {
+		oldest := s.order.Back()
+		e := oldest.Value.(*dedupEntry)
+		if s.order.Len() <= s.max && now.Sub(e.at) < d.window {
+			break
+		}
+		s.order.Remove(oldest)
+		delete(s.seen, e.key)
+	}
+	return false
+}
+
+// forget lets an event that was not accepted be sent again.
+func (d *dedup) forget(key string) {
+	s := d.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.seen[key]; ok {
+		s.order.Remove(el)
+		delete(s.seen, key)
+	}
+}
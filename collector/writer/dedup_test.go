@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+func TestDedup_Window(t *testing.T) {
+	d := newDedup(time.Minute, dedupShards*2)
+	now := time.Now()
+	if d.seen("a", now) {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.seen("a", now.Add(30*time.Second)) {
+		t.Error("expected a retry within the window to be a duplicate")
+	}
+	if d.seen("a", now.Add(2*time.Minute)) {
+		t.Error("expected the ID to expire after the window")
+	}
+	d.forget("a")
+	if d.seen("a", now.Add(2*time.Minute)) {
+		t.Error("expected a forgotten ID to be accepted again")
+	}
+	if newDedup(0, 10) != nil {
+		t.Error("expected a zero window to disable dedup")
+	}
+}
+
+func TestDedup_Capacity(t *testing.T) {
+	d := newDedup(time.Hour, dedupShards)
+	now := time.Now()
+	s := d.shard("x")
+	s.max = 2
+	// Fill one shard past capacity; the oldest key is evicted.
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		key := string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if d.shard(key) == s {
+			keys = append(keys, key)
+			d.seen(key, now)
+		}
+	}
+	if len(s.seen) != 2 {
+		t.Fatalf("expected the shard capped at 2, got %d", len(s.seen))
+	}
+	if d.seen(keys[0], now) {
+		t.Error("expected the evicted key to be accepted again")
+	}
+}
+
+func TestWriter_DropsRetriedEvents(t *testing.T) {
+	w := newTestWriter(3)
+	w.dedup = newDedup(time.Minute, 100)
+	write := func(id string) error {
+		return w.WriteContext(context.Background(), &collector.RawEvent{Event: "purchase", OrganizationID: "org-1", EventID: id, Revenue: 10})
+	}
+
+	if err := write("order-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("order-1"); err != nil {
+		t.Fatalf("expected a retry to be acknowledged, got %v", err)
+	}
+	if err := write(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.QueueDepth(); got != 2 {
+		t.Errorf("expected the retry to be skipped, got %d queued", got)
+	}
+
+	// An event refused for capacity is not remembered, so its retry is
+	// written once there is room.
+	w.highWatermark = 10
+	write("fill")
+	if err := write("order-2"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+	<-w.eventCh
+	if err := write("order-2"); err != nil {
+		t.Errorf("expected the retry of a refused event to be accepted, got %v", err)
+	}
+}
+
+func TestEventUUID(t *testing.T) {
+	client := &collector.RawEvent{OrganizationID: "org-1", EventID: "3f2b8c1e-0d4a-4b6e-9c8d-7a6b5c4d3e2f"}
+	if got := EventUUID(client).String(); got != client.EventID {
+		t.Errorf("expected the client UUID, got %s", got)
+	}
+	insertID := &collector.RawEvent{OrganizationID: "org-1", EventID: "abc"}
+	if EventUUID(insertID) != EventUUID(insertID) {
+		t.Error("expected a stable UUID for a non-UUID client ID")
+	}
+	other := &collector.RawEvent{OrganizationID: "org-2", EventID: "abc"}
+	if EventUUID(insertID) == EventUUID(other) {
+		t.Error("expected organizations to get distinct UUIDs")
+	}
+	none := &collector.RawEvent{}
+	if EventUUID(none) == EventUUID(none) {
+		t.Error("expected random UUIDs without a client ID")
+	}
+}
@@ -2,6 +2,7 @@ package writer
 
 import (
 	"encoding/json"
+	"time"
 
 	collector "github.com/hanzoai/analytics/collector"
 	"github.com/hanzoai/analytics/collector/forward"
@@ -152,6 +153,10 @@ func (f *DatastoreAPIForwarder) Forward(event *collector.RawEvent) {
 	setIfNotEmpty(props, "product_id", event.ProductID)
 	if event.Revenue != 0 {
 		props["revenue"] = event.Revenue
+		setIfNotEmpty(props, "currency", event.Currency)
+	}
+	if len(event.Products) > 0 {
+		props["products"] = event.Products
 	}
 
 	setIfNotEmpty(props, "model_provider", event.ModelProvider)
@@ -218,6 +223,203 @@ func (f *AnalyticsForwarder) Close() error {
 	return f.client.Close()
 }
 
+type WebhookForwarder struct {
+	client *forward.WebhookClient
+}
+
+func NewWebhookForwarder(config *forward.WebhookConfig) *WebhookForwarder {
+	return &WebhookForwarder{
+		client: forward.NewWebhookClient(config),
+	}
+}
+
+// Forward sends the event in its RawEvent JSON form.
+func (f *WebhookForwarder) Forward(event *collector.RawEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	f.client.Send(body)
+}
+
+func (f *WebhookForwarder) Close() error {
+	return f.client.Close()
+}
+
+// Kafka partition keys.
+const (
+	PartitionByOrganization = "organization_id"
+	PartitionByDistinctID   = "distinct_id"
+)
+
+type KafkaForwarder struct {
+	client       *forward.KafkaClient
+	partitionKey string
+}
+
+// NewKafkaForwarder creates a forwarder that produces RawEvent JSON keyed
+// by partitionKey (organization_id by default) so related events stay ordered.
+func NewKafkaForwarder(config *forward.KafkaConfig, partitionKey string) (*KafkaForwarder, error) {
+	client, err := forward.NewKafkaClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if partitionKey == "" {
+		partitionKey = PartitionByOrganization
+	}
+	return &KafkaForwarder{client: client, partitionKey: partitionKey}, nil
+}
+
+func (f *KafkaForwarder) Forward(event *collector.RawEvent) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	key := event.OrganizationID
+	if f.partitionKey == PartitionByDistinctID {
+		key = event.DistinctID
+	}
+	f.client.Send(forward.KafkaMessage{
+		Topic: f.client.TopicFor(event.Event),
+		Key:   []byte(key),
+		Value: value,
+	})
+}
+
+func (f *KafkaForwarder) Close() error {
+	return f.client.Close()
+}
+
+type FileForwarder struct {
+	client *forward.FileClient
+}
+
+func NewFileForwarder(config *forward.FileConfig) (*FileForwarder, error) {
+	client, err := forward.NewFileClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &FileForwarder{client: client}, nil
+}
+
+// Forward appends the event in its RawEvent JSON form.
+func (f *FileForwarder) Forward(event *collector.RawEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	f.client.Send(line)
+}
+
+func (f *FileForwarder) Close() error {
+	return f.client.Close()
+}
+
+type ArchiveForwarder struct {
+	client *forward.ArchiveClient
+}
+
+func NewArchiveForwarder(config *forward.ArchiveConfig) *ArchiveForwarder {
+	return &ArchiveForwarder{
+		client: forward.NewArchiveClient(config),
+	}
+}
+
+// Forward archives the event as a commerce.events row.
+func (f *ArchiveForwarder) Forward(event *collector.RawEvent) {
+	propsJSON, _ := json.Marshal(event.Properties)
+	personPropsJSON, _ := json.Marshal(event.PersonProperties)
+	groupPropsJSON, _ := json.Marshal(event.GroupProperties)
+
+	f.client.Send(&forward.ArchiveEvent{
+		EventID:           EventUUID(event).String(),
+		DistinctID:        event.DistinctID,
+		Event:             event.Event,
+		Timestamp:         event.Timestamp,
+		SentAt:            event.SentAt,
+		CreatedAt:         time.Now(),
+		OrganizationID:    event.OrganizationID,
+		ProjectID:         event.ProjectID,
+		SessionID:         event.SessionID,
+		VisitID:           event.VisitID,
+		Properties:        string(propsJSON),
+		PropsString:       stringProps(event.Properties),
+		PropsNumber:       numberProps(event.Properties),
+		PropsBool:         boolProps(event.Properties),
+		PersonProps:       string(personPropsJSON),
+		PersonPropsString: stringProps(event.PersonProperties),
+		PersonPropsNumber: numberProps(event.PersonProperties),
+		PersonPropsBool:   boolProps(event.PersonProperties),
+		GroupType:         event.GroupType,
+		GroupKey:          event.GroupKey,
+		GroupProps:        string(groupPropsJSON),
+		GroupPropsString:  stringProps(event.GroupProperties),
+		GroupPropsNumber:  numberProps(event.GroupProperties),
+		GroupPropsBool:    boolProps(event.GroupProperties),
+		URL:               event.URL,
+		URLPath:           event.URLPath,
+		Referrer:          event.Referrer,
+		ReferrerDomain:    event.ReferrerDomain,
+		Hostname:          event.Hostname,
+		Browser:           event.Browser,
+		BrowserVersion:    event.BrowserVersion,
+		OS:                event.OS,
+		OSVersion:         event.OSVersion,
+		Device:            event.Device,
+		DeviceType:        event.DeviceType,
+		Screen:            event.Screen,
+		Language:          event.Language,
+		Country:           event.Country,
+		Region:            event.Region,
+		City:              event.City,
+		UTMSource:         event.UTMSource,
+		UTMMedium:         event.UTMMedium,
+		UTMCampaign:       event.UTMCampaign,
+		UTMContent:        event.UTMContent,
+		UTMTerm:           event.UTMTerm,
+		GCLID:             event.GCLID,
+		FBCLID:            event.FBCLID,
+		MSCLKID:           event.MSCLID,
+		IP:                event.IP,
+		UserAgent:         event.UserAgent,
+		OrderID:           event.OrderID,
+		ProductID:         event.ProductID,
+		CartID:            event.CartID,
+		Revenue:           event.Revenue,
+		Currency:          event.Currency,
+		BaseCurrency:      event.BaseCurrency,
+		BaseRevenue:       event.BaseRevenue,
+		Quantity:          uint32(event.Quantity),
+		ASTContext:        event.ASTContext,
+		ASTType:           event.ASTType,
+		PageTitle:         event.PageTitle,
+		PageDescription:   event.PageDescription,
+		PageType:          event.PageType,
+		ElementID:         event.ElementID,
+		ElementType:       event.ElementType,
+		ElementSelector:   event.ElementSelector,
+		ElementText:       event.ElementText,
+		ElementHref:       event.ElementHref,
+		SectionName:       event.SectionName,
+		SectionType:       event.SectionType,
+		SectionID:         event.SectionID,
+		ComponentPath:     event.ComponentPath,
+		ComponentData:     event.ComponentData,
+		ModelProvider:     event.ModelProvider,
+		ModelName:         event.ModelName,
+		TokenCount:        uint32(event.TokenCount),
+		TokenPrice:        event.TokenPrice,
+		PromptTokens:      uint32(event.PromptTokens),
+		OutputTokens:      uint32(event.OutputTokens),
+		Lib:               event.Lib,
+		LibVersion:        event.LibVersion,
+	})
+}
+
+func (f *ArchiveForwarder) Close() error {
+	return f.client.Close()
+}
+
 func setIfNotEmpty(m map[string]interface{}, key, val string) {
 	if val != "" {
 		m[key] = val
@@ -228,3 +430,7 @@ func setIfNotEmpty(m map[string]interface{}, key, val string) {
 var _ Forwarder = (*InsightsForwarder)(nil)
 var _ Forwarder = (*DatastoreAPIForwarder)(nil)
 var _ Forwarder = (*AnalyticsForwarder)(nil)
+var _ Forwarder = (*WebhookForwarder)(nil)
+var _ Forwarder = (*KafkaForwarder)(nil)
+var _ Forwarder = (*ArchiveForwarder)(nil)
+var _ Forwarder = (*FileForwarder)(nil)
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -230,6 +231,51 @@ func TestAnalyticsForwarder_PageView(t *testing.T) {
 	}
 }
 
+type kafkaStub struct {
+	mu   sync.Mutex
+	msgs []forward.KafkaMessage
+}
+
+func (p *kafkaStub) WriteMessages(ctx context.Context, msgs ...forward.KafkaMessage) error {
+	p.mu.Lock()
+	p.msgs = append(p.msgs, msgs...)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *kafkaStub) Close() error { return nil }
+
+func TestKafkaForwarder_PartitionKey(t *testing.T) {
+	for _, tt := range []struct{ partitionKey, want string }{
+		{"", "org1"},
+		{PartitionByDistinctID, "u1"},
+	} {
+		stub := &kafkaStub{}
+		fwd, err := NewKafkaForwarder(&forward.KafkaConfig{
+			Topic:    "events",
+			Topics:   []forward.KafkaTopicRoute{{Events: []string{"ai.*"}, Topic: "ai"}},
+			Producer: stub,
+		}, tt.partitionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fwd.Forward(&collector.RawEvent{Event: "ai.completion", DistinctID: "u1", OrganizationID: "org1"})
+		fwd.Close()
+
+		if len(stub.msgs) != 1 {
+			t.Fatalf("expected 1 message, got %d", len(stub.msgs))
+		}
+		msg := stub.msgs[0]
+		if msg.Topic != "ai" || string(msg.Key) != tt.want {
+			t.Errorf("partition_key %q: got topic %q key %q", tt.partitionKey, msg.Topic, msg.Key)
+		}
+		var event collector.RawEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil || event.Event != "ai.completion" {
+			t.Errorf("value is not the RawEvent JSON: %s", msg.Value)
+		}
+	}
+}
+
 // mockForwarder records all forwarded events for testing.
 type mockForwarder struct {
 	mu     sync.Mutex
@@ -0,0 +1,188 @@
+package writer
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+)
+
+// ga4EventNames maps collector events to GA4 recommended events.
+var ga4EventNames = map[string]string{
+	collector.StandardEvents.PageView:        "page_view",
+	collector.StandardEvents.ScreenView:      "screen_view",
+	collector.StandardEvents.ProductViewed:   "view_item",
+	collector.StandardEvents.ProductAdded:    "add_to_cart",
+	collector.StandardEvents.ProductRemoved:  "remove_from_cart",
+	collector.StandardEvents.CartViewed:      "view_cart",
+	collector.StandardEvents.CheckoutStarted: "begin_checkout",
+	collector.StandardEvents.OrderCompleted:  "purchase",
+	collector.StandardEvents.OrderRefunded:   "refund",
+	collector.StandardEvents.SignedUp:        "sign_up",
+	collector.StandardEvents.SignedIn:        "login",
+	collector.StandardEvents.SearchQuery:     "search",
+}
+
+// ga4Skipped are identity events with no GA4 equivalent.
+var ga4Skipped = map[string]bool{
+	collector.StandardEvents.Identify:      true,
+	collector.StandardEvents.Alias:         true,
+	collector.StandardEvents.GroupIdentify: true,
+}
+
+// GA4 Measurement Protocol limits.
+const (
+	ga4MaxParams      = 25
+	ga4MaxNameLength  = 40
+	ga4MaxValueLength = 100
+	// ga4EngagementMsec is reported when the event carries no engagement
+	// time; GA4 ignores events without it in engagement reports.
+	ga4EngagementMsec = 100
+)
+
+type GA4Forwarder struct {
+	client   *forward.GA4Client
+	accounts *Accounts
+}
+
+// NewGA4Forwarder creates a forwarder that sends events to the GA4
+// property (measurement ID and API secret) configured for their organization.
+func NewGA4Forwarder(config *forward.GA4Config, accounts *Accounts) *GA4Forwarder {
+	return &GA4Forwarder{
+		client:   forward.NewGA4Client(config),
+		accounts: accounts,
+	}
+}
+
+func (f *GA4Forwarder) Forward(event *collector.RawEvent) {
+	if ga4Skipped[event.Event] || event.DistinctID == "" {
+		return
+	}
+	acct, ok := f.accounts.For(event.OrganizationID)
+	if !ok {
+		return
+	}
+
+	msg := &forward.GA4Message{
+		MeasurementID: acct.ID,
+		APISecret:     acct.Secret,
+		ClientID:      event.DistinctID,
+		Event:         ga4Event(event),
+	}
+	if userID, ok := event.Properties["user_id"].(string); ok {
+		msg.UserID = userID
+	}
+	f.client.Send(msg)
+}
+
+func (f *GA4Forwarder) Close() error {
+	return f.client.Close()
+}
+
+// ga4Event maps a RawEvent onto a GA4 event and its parameters.
+func ga4Event(event *collector.RawEvent) forward.GA4Event {
+	name, ok := ga4EventNames[event.Event]
+	if !ok {
+		name = ga4Name(event.Event)
+	}
+
+	params := make(map[string]interface{})
+	setIfNotEmpty(params, "session_id", event.SessionID)
+	setIfNotEmpty(params, "page_location", event.URL)
+	setIfNotEmpty(params, "page_title", event.PageTitle)
+	setIfNotEmpty(params, "page_referrer", event.Referrer)
+	setIfNotEmpty(params, "language", event.Language)
+	setIfNotEmpty(params, "screen_resolution", event.Screen)
+	setIfNotEmpty(params, "campaign_source", event.UTMSource)
+	setIfNotEmpty(params, "campaign_medium", event.UTMMedium)
+	setIfNotEmpty(params, "campaign", event.UTMCampaign)
+
+	engagement := interface{}(ga4EngagementMsec)
+	if v, ok := event.Properties["engagement_time_msec"]; ok {
+		engagement = v
+	}
+	params["engagement_time_msec"] = engagement
+
+	switch name {
+	case "purchase", "refund", "add_to_cart", "remove_from_cart", "view_item", "view_cart", "begin_checkout":
+		setIfNotEmpty(params, "transaction_id", event.OrderID)
+		params["currency"] = eventCurrency(event)
+		items := eventItems(event)
+		if len(items) > 0 {
+			params["items"] = items
+		}
+		// Refunds carry negative revenue; GA4 takes the refunded amount.
+		if event.Revenue != 0 {
+			params["value"] = math.Abs(event.Revenue)
+		} else if v := itemsValue(items); v != 0 {
+			params["value"] = v
+		}
+	case "search":
+		if q, ok := event.Properties["query"]; ok {
+			params["search_term"] = q
+		}
+	}
+
+	// Remaining scalar properties become custom parameters, in key order
+	// so the same ones survive the parameter limit every time.
+	keys := make([]string, 0, len(event.Properties))
+	for k := range event.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(params) >= ga4MaxParams {
+			break
+		}
+		v := event.Properties[k]
+		key := ga4Name(k)
+		if _, exists := params[key]; exists || key == "" {
+			continue
+		}
+		switch val := v.(type) {
+		case string:
+			if len(val) > ga4MaxValueLength {
+				val = val[:ga4MaxValueLength]
+			}
+			params[key] = val
+		case float64, int, int64, bool:
+			params[key] = val
+		}
+	}
+
+	ga := forward.GA4Event{Name: name, Params: params}
+	if !event.Timestamp.IsZero() {
+		ga.TimestampMicros = event.Timestamp.UnixMicro()
+	}
+	return ga
+}
+
+// ga4Name makes a GA4-compatible event or parameter name: letters, digits
+// and underscores, starting with a letter, at most 40 characters.
+func ga4Name(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' || r == ' ':
+			if b.Len() == 0 {
+				continue
+			}
+			if r >= '0' && r <= '9' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+	}
+	name := b.String()
+	if len(name) > ga4MaxNameLength {
+		name = name[:ga4MaxNameLength]
+	}
+	return name
+}
+
+var _ Forwarder = (*GA4Forwarder)(nil)
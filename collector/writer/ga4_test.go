@@ -0,0 +1,81 @@
+package writer
+
+import (
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+func TestGA4Event_Purchase(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ga := ga4Event(&collector.RawEvent{
+		Event:     "order_completed",
+		OrderID:   "o-1",
+		SessionID: "s-1",
+		Timestamp: ts,
+		Properties: map[string]interface{}{
+			"currency": "eur",
+			"coupon":   "WELCOME",
+			"products": []interface{}{
+				map[string]interface{}{"product_id": "p1", "name": "Shirt", "price": 20.0, "quantity": 2.0},
+				map[string]interface{}{"sku": "p2", "price": 5.0},
+			},
+		},
+	})
+
+	if ga.Name != "purchase" {
+		t.Errorf("name = %q", ga.Name)
+	}
+	if ga.TimestampMicros != ts.UnixMicro() {
+		t.Errorf("timestamp_micros = %d", ga.TimestampMicros)
+	}
+	p := ga.Params
+	if p["transaction_id"] != "o-1" || p["currency"] != "EUR" || p["session_id"] != "s-1" {
+		t.Errorf("unexpected params %v", p)
+	}
+	if p["value"] != 45.0 {
+		t.Errorf("value = %v, want 45", p["value"])
+	}
+	items := p["items"].([]map[string]interface{})
+	if len(items) != 2 || items[0]["item_id"] != "p1" || items[1]["item_id"] != "p2" {
+		t.Errorf("unexpected items %v", items)
+	}
+	if p["coupon"] != "WELCOME" {
+		t.Errorf("custom param coupon missing: %v", p)
+	}
+	if _, ok := p["engagement_time_msec"]; !ok {
+		t.Error("engagement_time_msec missing")
+	}
+}
+
+func TestGA4Event_Names(t *testing.T) {
+	tests := map[string]string{
+		"$pageview":      "page_view",
+		"product_added":  "add_to_cart",
+		"ai.completion":  "ai_completion",
+		"$api_request":   "api_request",
+		"Signup Clicked": "Signup_Clicked",
+	}
+	for in, want := range tests {
+		if got := ga4Event(&collector.RawEvent{Event: in}).Name; got != want {
+			t.Errorf("%q -> %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestAccounts_For(t *testing.T) {
+	accounts := &Accounts{
+		Default:        Account{ID: "G-DEFAULT", Secret: "d"},
+		ByOrganization: map[string]Account{"acme": {ID: "G-ACME", Secret: "a"}},
+	}
+	if a, _ := accounts.For("acme"); a.ID != "G-ACME" {
+		t.Errorf("acme -> %q", a.ID)
+	}
+	if a, ok := accounts.For("other"); !ok || a.ID != "G-DEFAULT" {
+		t.Errorf("other -> %q, %v", a.ID, ok)
+	}
+	if _, ok := (&Accounts{}).For("other"); ok {
+		t.Error("expected no account")
+	}
+}
@@ -0,0 +1,89 @@
+package writer
+
+import (
+	"encoding/json"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+)
+
+type GoogleAdsForwarder struct {
+	client   *forward.GoogleAdsClient
+	results  *forward.FileClient
+	accounts *Accounts
+	actions  map[string]string
+}
+
+// NewGoogleAdsForwarder creates a forwarder that uploads the events named in
+// actions (event name to conversion action ID) as offline click conversions
+// to the Google Ads customer configured for their organization. An account's
+// secret, if set, is that customer's OAuth refresh token. When results is
+// set, the outcome of every upload is appended to it as JSON lines. The
+// writer attributes conversions to their click (see Config.Conversions), so
+// only events with a gclid are uploaded.
+func NewGoogleAdsForwarder(config *forward.GoogleAdsConfig, accounts *Accounts, actions map[string]string, results *forward.FileConfig) (*GoogleAdsForwarder, error) {
+	f := &GoogleAdsForwarder{
+		accounts: accounts,
+		actions:  actions,
+	}
+	if results != nil {
+		rc, err := forward.NewFileClient(results)
+		if err != nil {
+			return nil, err
+		}
+		f.results = rc
+		onResult := config.OnResult
+		config.OnResult = func(r forward.GoogleAdsResult) {
+			if data, err := json.Marshal(r); err == nil {
+				rc.Send(data)
+			}
+			if onResult != nil {
+				onResult(r)
+			}
+		}
+	}
+	f.client = forward.NewGoogleAdsClient(config)
+	return f, nil
+}
+
+// Forward uploads configured conversions attributed to a click.
+func (f *GoogleAdsForwarder) Forward(event *collector.RawEvent) {
+	action, ok := f.actions[event.Event]
+	if !ok || event.GCLID == "" {
+		return
+	}
+	acct, ok := f.accounts.For(event.OrganizationID)
+	if !ok {
+		return
+	}
+
+	conv := &forward.GoogleAdsConversion{
+		CustomerID:       acct.ID,
+		RefreshToken:     acct.Secret,
+		ConversionAction: action,
+		GCLID:            event.GCLID,
+		DateTime:         eventTime(event),
+		Value:            event.Revenue,
+		OrderID:          event.OrderID,
+	}
+	if conv.Value == 0 {
+		conv.Value = itemsValue(eventItems(event))
+	}
+	if conv.Value != 0 {
+		conv.Currency = eventCurrency(event)
+	}
+	if conv.OrderID == "" {
+		conv.OrderID = eventID(event)
+	}
+	f.client.Send(conv)
+}
+
+func (f *GoogleAdsForwarder) Close() error {
+	err := f.client.Close()
+	if f.results != nil {
+		f.results.Close()
+	}
+	return err
+}
+
+var _ Forwarder = (*GoogleAdsForwarder)(nil)
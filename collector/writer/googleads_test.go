@@ -0,0 +1,150 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+)
+
+func TestGoogleAdsForwarder_UploadsAttributedConversions(t *testing.T) {
+	var mu sync.Mutex
+	var conversions []map[string]interface{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token":"access","expires_in":3600}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Conversions []map[string]interface{} `json:"conversions"`
+		}
+		json.Unmarshal(body, &req)
+		mu.Lock()
+		conversions = append(conversions, req.Conversions...)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	resultsPath := filepath.Join(t.TempDir(), "results.jsonl")
+	fwd, err := NewGoogleAdsForwarder(&forward.GoogleAdsConfig{
+		Endpoint:      srv.URL,
+		TokenURL:      srv.URL + "/token",
+		RefreshToken:  "refresh",
+		FlushInterval: time.Hour,
+	}, &Accounts{
+		ByOrganization: map[string]Account{"acme": {ID: "123"}},
+	}, map[string]string{"order_completed": "42"}, &forward.FileConfig{Path: resultsPath, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	landed := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	fwd.Forward(&collector.RawEvent{
+		Event: "order_completed", OrganizationID: "acme", DistinctID: "user-1", SessionID: "s1",
+		GCLID: "Cj0KCQ", OrderID: "o-1", Revenue: 42.5, Timestamp: landed.Add(time.Hour),
+		Properties: map[string]interface{}{"currency": "eur"},
+	})
+	// Not attributed to a click by the writer.
+	fwd.Forward(&collector.RawEvent{Event: "order_completed", OrganizationID: "acme", DistinctID: "user-2", OrderID: "o-2"})
+	fwd.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conversions) != 1 {
+		t.Fatalf("expected 1 conversion, got %d", len(conversions))
+	}
+	c := conversions[0]
+	if c["gclid"] != "Cj0KCQ" || c["orderId"] != "o-1" || c["conversionValue"] != 42.5 ||
+		c["currencyCode"] != "EUR" || c["conversionDateTime"] != "2026-05-01 13:00:00+00:00" {
+		t.Errorf("unexpected conversion %v", c)
+	}
+
+	data, err := os.ReadFile(resultsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"status":"uploaded"`) {
+		t.Errorf("expected an upload result, got %s", data)
+	}
+}
+
+func TestWriter_AttributesConversionClick(t *testing.T) {
+	w := newTestWriter(10)
+	w.SetConversions([]string{"order_completed"})
+	write := func(event *collector.RawEvent) *collector.RawEvent {
+		t.Helper()
+		if err := w.WriteContext(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		return (<-w.eventCh).event
+	}
+
+	landed := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	// The click lands anonymously; the order is placed after login, so
+	// only the session links them.
+	write(&collector.RawEvent{
+		Event: "$pageview", OrganizationID: "acme", DistinctID: "anon", SessionID: "s1",
+		GCLID: "Cj0KCQ", Timestamp: landed,
+	})
+	order := write(&collector.RawEvent{
+		Event: "order_completed", OrganizationID: "acme", DistinctID: "user-1", SessionID: "s1",
+		OrderID: "o-1", Timestamp: landed.Add(time.Hour),
+	})
+	if order.GCLID != "Cj0KCQ" {
+		t.Errorf("expected the stored order to carry the session's gclid, got %q", order.GCLID)
+	}
+	if view := write(&collector.RawEvent{Event: "$pageview", OrganizationID: "acme", SessionID: "s1", Timestamp: landed.Add(time.Hour)}); view.GCLID != "" {
+		t.Errorf("expected only conversions to be attributed, got %q", view.GCLID)
+	}
+	if other := write(&collector.RawEvent{Event: "order_completed", OrganizationID: "acme", DistinctID: "user-2", OrderID: "o-2"}); other.GCLID != "" {
+		t.Errorf("expected no click for an unknown person, got %q", other.GCLID)
+	}
+}
+
+type clickSourceStub struct {
+	click Click
+	calls int
+}
+
+func (s *clickSourceStub) LatestGCLID(ctx context.Context, organizationID, distinctID string, since, until time.Time) (Click, bool, error) {
+	s.calls++
+	if organizationID != "acme" || distinctID != "user-1" || s.click.At.Before(since) || s.click.At.After(until) {
+		return Click{}, false, nil
+	}
+	return s.click, true, nil
+}
+
+func TestWriter_AttributesStoredClick(t *testing.T) {
+	w := newTestWriter(10)
+	w.SetConversions([]string{"order_completed"})
+	// The click was seen before a restart, so only the datastore has it.
+	ordered := time.Date(2026, 5, 2, 12, 0, 0, 0, time.UTC)
+	source := &clickSourceStub{click: Click{ID: "stored", At: ordered.Add(-24 * time.Hour)}}
+	w.clickSource = source
+
+	for _, orderID := range []string{"o-1", "o-2"} {
+		event := &collector.RawEvent{Event: "order_completed", OrganizationID: "acme", DistinctID: "user-1", OrderID: orderID, Timestamp: ordered}
+		if err := w.WriteContext(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		if event.GCLID != "stored" {
+			t.Errorf("%s: expected the stored click, got %q", orderID, event.GCLID)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("expected the stored click to be remembered after one lookup, got %d lookups", source.calls)
+	}
+}
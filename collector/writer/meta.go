@@ -0,0 +1,187 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+)
+
+// metaEventNames maps collector events to Meta standard events. Other
+// events are not sent.
+var metaEventNames = map[string]string{
+	collector.StandardEvents.OrderCompleted:  "Purchase",
+	collector.StandardEvents.ProductAdded:    "AddToCart",
+	collector.StandardEvents.CheckoutStarted: "InitiateCheckout",
+	collector.StandardEvents.ProductViewed:   "ViewContent",
+	collector.StandardEvents.SignedUp:        "CompleteRegistration",
+	collector.StandardEvents.SearchQuery:     "Search",
+}
+
+// metaClickTTL is how long an fbclid is attributed to later conversions,
+// matching Meta's default 7-day click attribution window.
+const metaClickTTL = 7 * 24 * time.Hour
+
+type MetaForwarder struct {
+	client   *forward.MetaClient
+	accounts *Accounts
+	clicks   *ClickMemory
+}
+
+// NewMetaForwarder creates a forwarder that sends conversions to the Meta
+// pixel (pixel ID and access token) configured for their organization.
+func NewMetaForwarder(config *forward.MetaConfig, accounts *Accounts) *MetaForwarder {
+	return &MetaForwarder{
+		client:   forward.NewMetaClient(config),
+		accounts: accounts,
+		clicks:   NewClickMemory(100000, metaClickTTL),
+	}
+}
+
+// Forward remembers fbclids from every event and sends mapped conversions.
+func (f *MetaForwarder) Forward(event *collector.RawEvent) {
+	key := event.OrganizationID + "\x00" + event.DistinctID
+	at := eventTime(event)
+	if event.FBCLID != "" {
+		f.clicks.Remember(key, Click{ID: event.FBCLID, At: at})
+	}
+
+	name, ok := metaEventNames[event.Event]
+	if !ok {
+		return
+	}
+	acct, ok := f.accounts.For(event.OrganizationID)
+	if !ok {
+		return
+	}
+
+	m := forward.MetaEvent{
+		EventName:      name,
+		EventTime:      at.Unix(),
+		EventID:        eventID(event),
+		EventSourceURL: event.URL,
+		ActionSource:   "website",
+		UserData:       metaUserData(event),
+	}
+	if fbc, ok := event.Properties["fbc"].(string); ok && fbc != "" {
+		m.UserData.FBC = fbc
+	} else if click, ok := f.clicks.Recall(key, at); ok {
+		m.UserData.FBC = fmt.Sprintf("fb.1.%d.%s", click.At.UnixMilli(), click.ID)
+	}
+	if fbp, ok := event.Properties["fbp"].(string); ok {
+		m.UserData.FBP = fbp
+	}
+	m.CustomData = metaCustomData(name, event)
+
+	f.client.Send(&forward.MetaMessage{PixelID: acct.ID, AccessToken: acct.Secret, Event: m})
+}
+
+func (f *MetaForwarder) Close() error {
+	return f.client.Close()
+}
+
+// metaUserData builds hashed user data from person properties, falling
+// back to event properties and the event's own geo fields.
+func metaUserData(event *collector.RawEvent) forward.MetaUserData {
+	prop := func(keys ...string) string {
+		for _, props := range []map[string]interface{}{event.PersonProperties, event.Properties} {
+			for _, k := range keys {
+				if v, ok := props[k].(string); ok && v != "" {
+					return v
+				}
+			}
+		}
+		return ""
+	}
+
+	return forward.MetaUserData{
+		Email:           hashed(normalizeLower(prop("email", "$email"))),
+		Phone:           hashed(digitsOnly(prop("phone", "$phone"))),
+		FirstName:       hashed(normalizeLower(prop("first_name", "$first_name"))),
+		LastName:        hashed(normalizeLower(prop("last_name", "$last_name"))),
+		City:            hashed(strings.ReplaceAll(normalizeLower(firstOf(prop("city"), event.City)), " ", "")),
+		State:           hashed(normalizeLower(firstOf(prop("state"), event.Region))),
+		Zip:             hashed(strings.ReplaceAll(normalizeLower(prop("zip", "postal_code")), " ", "")),
+		Country:         hashed(normalizeLower(firstOf(prop("country"), event.Country))),
+		ExternalID:      hashed(event.DistinctID),
+		ClientIPAddress: event.IP,
+		ClientUserAgent: event.UserAgent,
+	}
+}
+
+func metaCustomData(name string, event *collector.RawEvent) *forward.MetaCustomData {
+	switch name {
+	case "CompleteRegistration":
+		return nil
+	case "Search":
+		q, _ := event.Properties["query"].(string)
+		return &forward.MetaCustomData{SearchQuery: q}
+	}
+
+	data := &forward.MetaCustomData{
+		Currency:    eventCurrency(event),
+		Value:       event.Revenue,
+		OrderID:     event.OrderID,
+		ContentType: "product",
+	}
+	items := eventItems(event)
+	for _, item := range items {
+		id, _ := item["item_id"].(string)
+		if id == "" {
+			continue
+		}
+		c := forward.MetaContent{ID: id, Quantity: 1}
+		if q, ok := toFloat(item["quantity"]); ok {
+			c.Quantity = int(q)
+		}
+		if p, ok := toFloat(item["price"]); ok {
+			c.ItemPrice = p
+		}
+		data.ContentIDs = append(data.ContentIDs, id)
+		data.Contents = append(data.Contents, c)
+		data.NumItems += c.Quantity
+	}
+	if data.Value == 0 {
+		data.Value = itemsValue(items)
+	}
+	return data
+}
+
+// hashed returns the SHA-256 hex digest of a normalized value, or nil for
+// an empty one.
+func hashed(v string) []string {
+	if v == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(v))
+	return []string{hex.EncodeToString(sum[:])}
+}
+
+func normalizeLower(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var _ Forwarder = (*MetaForwarder)(nil)
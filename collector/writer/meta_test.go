@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/forward"
+)
+
+func TestMetaForwarder_Purchase(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var events []forward.MetaEvent
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct {
+			Data []forward.MetaEvent `json:"data"`
+		}
+		json.Unmarshal(body, &payload)
+		mu.Lock()
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		events = append(events, payload.Data...)
+		mu.Unlock()
+		w.Write([]byte(`{"events_received":1}`))
+	}))
+	defer srv.Close()
+
+	fwd := NewMetaForwarder(&forward.MetaConfig{Endpoint: srv.URL, FlushInterval: time.Hour}, &Accounts{
+		ByOrganization: map[string]Account{"acme": {ID: "px1", Secret: "tok"}},
+	})
+
+	landed := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	// The landing pageview carries the fbclid but is not itself sent.
+	fwd.Forward(&collector.RawEvent{
+		Event: "$pageview", OrganizationID: "acme", DistinctID: "u1",
+		FBCLID: "AbC123", Timestamp: landed,
+	})
+	fwd.Forward(&collector.RawEvent{
+		Event: "order_completed", OrganizationID: "acme", DistinctID: "u1",
+		OrderID: "o-9", Revenue: 30, Timestamp: landed.Add(time.Hour),
+		PersonProperties: map[string]interface{}{"email": " Jane@Example.com "},
+		Properties: map[string]interface{}{
+			"event_id": "pixel-evt-1",
+			"currency": "usd",
+			"products": []interface{}{map[string]interface{}{"product_id": "p1", "price": 15.0, "quantity": 2.0}},
+		},
+	})
+	// Organizations without a pixel are skipped.
+	fwd.Forward(&collector.RawEvent{Event: "order_completed", OrganizationID: "other", DistinctID: "u2"})
+	fwd.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if !strings.HasPrefix(paths[0], "/v21.0/px1/events?access_token=tok") {
+		t.Errorf("unexpected request %q", paths[0])
+	}
+
+	e := events[0]
+	if e.EventName != "Purchase" || e.EventID != "pixel-evt-1" || e.ActionSource != "website" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if want := "fb.1.1777636800000.AbC123"; e.UserData.FBC != want {
+		t.Errorf("fbc = %q, want %q", e.UserData.FBC, want)
+	}
+	sum := sha256.Sum256([]byte("jane@example.com"))
+	if len(e.UserData.Email) != 1 || e.UserData.Email[0] != hex.EncodeToString(sum[:]) {
+		t.Errorf("email not normalized and hashed: %v", e.UserData.Email)
+	}
+	if e.CustomData == nil || e.CustomData.Currency != "USD" || e.CustomData.Value != 30 ||
+		e.CustomData.NumItems != 2 || e.CustomData.ContentIDs[0] != "p1" {
+		t.Errorf("unexpected custom data %+v", e.CustomData)
+	}
+}
+
+func TestClickMemory(t *testing.T) {
+	m := NewClickMemory(2, time.Hour)
+	now := time.Now()
+	m.Remember("a", Click{ID: "1", At: now})
+	m.Remember("a", Click{ID: "old", At: now.Add(-time.Minute)})
+	m.Remember("b", Click{ID: "2", At: now})
+	m.Remember("c", Click{ID: "3", At: now})
+
+	if c, ok := m.Recall("a", now); ok {
+		t.Errorf("expected a to be evicted, got %v", c)
+	}
+	if c, ok := m.Recall("c", now); !ok || c.ID != "3" {
+		t.Errorf("c = %v, %v", c, ok)
+	}
+	if _, ok := m.Recall("b", now.Add(2*time.Hour)); ok {
+		t.Error("expected b to expire")
+	}
+}
@@ -0,0 +1,227 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/metrics"
+	"github.com/hanzoai/analytics/collector/tracing"
+)
+
+// Order ledger entry kinds.
+const (
+	ledgerCompleted = "completed"
+	ledgerRefunded  = "refunded"
+	ledgerCancelled = "cancelled"
+)
+
+// ledgerKind returns the ledger entry an event records, if any.
+func ledgerKind(event *collector.RawEvent) string {
+	switch event.Event {
+	case collector.StandardEvents.OrderCompleted:
+		return ledgerCompleted
+	case collector.StandardEvents.OrderRefunded:
+		return ledgerRefunded
+	case collector.StandardEvents.OrderCancelled:
+		return ledgerCancelled
+	}
+	return ""
+}
+
+// prepareOrder applies the order lifecycle to an event before it is
+// deduplicated. A completed order sent without a client ID is identified
+// by its order ID, so a repeated order_completed is acknowledged without
+// being counted again within the dedup window; past it, the order ledger
+// still collapses the repeat but the hourly rollup does not. Refunds and
+// cancellations carry negative revenue; without an amount, a refund is the
+// total of its products, and a cancellation is reversed in the orders view.
+func prepareOrder(event *collector.RawEvent) {
+	switch ledgerKind(event) {
+	case ledgerCompleted:
+		if event.EventID == "" && event.OrderID != "" {
+			event.EventID = "order_completed:" + event.OrderID
+		}
+	case ledgerRefunded, ledgerCancelled:
+		if event.Revenue == 0 {
+			event.Revenue = collector.Subtotal(event.Products)
+		}
+		event.Revenue = -math.Abs(event.Revenue)
+	}
+}
+
+// orderItem is one product line of an order_completed event.
+type orderItem struct {
+	event   *collector.RawEvent
+	id      uuid.UUID
+	line    int
+	product *collector.Product
+}
+
+// orderItems expands the products of order_completed events with an
+// order ID into lines, numbered from 1 within each order.
+func orderItems(events []*collector.RawEvent) []orderItem {
+	var items []orderItem
+	for _, e := range events {
+		if ledgerKind(e) != ledgerCompleted || e.OrderID == "" || len(e.Products) == 0 {
+			continue
+		}
+		id := EventUUID(e)
+		for i := range e.Products {
+			items = append(items, orderItem{event: e, id: id, line: i + 1, product: &e.Products[i]})
+		}
+	}
+	return items
+}
+
+// ledgerEntry is the order ledger row of an order lifecycle event.
+type ledgerEntry struct {
+	event *collector.RawEvent
+	id    uuid.UUID
+	kind  string
+}
+
+// ledgerEntries returns the ledger entries of events with an order ID.
+func ledgerEntries(events []*collector.RawEvent) []ledgerEntry {
+	var entries []ledgerEntry
+	for _, e := range events {
+		if kind := ledgerKind(e); kind != "" && e.OrderID != "" {
+			entries = append(entries, ledgerEntry{event: e, id: EventUUID(e), kind: kind})
+		}
+	}
+	return entries
+}
+
+// entryID keys an entry within its order: an order has one completion and
+// one cancellation, which later entries replace, but any number of refunds.
+func (e *ledgerEntry) entryID() string {
+	if e.kind == ledgerRefunded {
+		return e.id.String()
+	}
+	return ""
+}
+
+// rowColumn builds one column of a table for a whole batch of rows.
+type rowColumn[R any] struct {
+	name   string
+	values func(rows []R) any
+}
+
+func column[R, T any](name string, get func(*R) T) rowColumn[R] {
+	return rowColumn[R]{name, func(rows []R) any {
+		out := make([]T, len(rows))
+		for i := range rows {
+			out[i] = get(&rows[i])
+		}
+		return out
+	}}
+}
+
+func decimalRowColumn[R any](name string, get func(*R) float64) rowColumn[R] {
+	return column(name, func(r *R) decimal.Decimal { return decimal.NewFromFloat(get(r)) })
+}
+
+// orderItemColumns are the order items table columns the writer fills, in
+// insert order.
+var orderItemColumns = []rowColumn[orderItem]{
+	column("organization_id", func(it *orderItem) string { return it.event.OrganizationID }),
+	column("order_id", func(it *orderItem) string { return it.event.OrderID }),
+	column("line", func(it *orderItem) uint16 { return uint16(min(it.line, math.MaxUint16)) }),
+	column("event_id", func(it *orderItem) uuid.UUID { return it.id }),
+	column("distinct_id", func(it *orderItem) string { return it.event.DistinctID }),
+	column("session_id", func(it *orderItem) string { return it.event.SessionID }),
+	column("timestamp", func(it *orderItem) time.Time { return it.event.Timestamp }),
+	column("product_id", func(it *orderItem) string { return it.product.ProductID }),
+	column("sku", func(it *orderItem) string { return it.product.SKU }),
+	column("name", func(it *orderItem) string { return it.product.Name }),
+	column("brand", func(it *orderItem) string { return it.product.Brand }),
+	column("category", func(it *orderItem) string { return it.product.Category }),
+	column("variant", func(it *orderItem) string { return it.product.Variant }),
+	decimalRowColumn("price", func(it *orderItem) float64 { return it.product.Price }),
+	column("quantity", func(it *orderItem) uint32 { return uint32(min(it.product.Units(), math.MaxUint32)) }),
+	decimalRowColumn("discount", func(it *orderItem) float64 { return it.product.Discount }),
+	column("coupon", func(it *orderItem) string { return it.product.Coupon }),
+	decimalRowColumn("revenue", func(it *orderItem) float64 { return it.product.Revenue() }),
+	column("currency", func(it *orderItem) string { return it.event.Currency }),
+	column("base_currency", func(it *orderItem) string { return it.event.BaseCurrency }),
+	decimalRowColumn("revenue_base", func(it *orderItem) float64 { return it.product.BaseRevenue }),
+}
+
+// ledgerColumns are the order ledger columns the writer fills, in insert
+// order.
+var ledgerColumns = []rowColumn[ledgerEntry]{
+	column("organization_id", func(e *ledgerEntry) string { return e.event.OrganizationID }),
+	column("order_id", func(e *ledgerEntry) string { return e.event.OrderID }),
+	column("kind", func(e *ledgerEntry) string { return e.kind }),
+	column("entry_id", (*ledgerEntry).entryID),
+	column("event_id", func(e *ledgerEntry) uuid.UUID { return e.id }),
+	column("distinct_id", func(e *ledgerEntry) string { return e.event.DistinctID }),
+	column("timestamp", func(e *ledgerEntry) time.Time { return e.event.Timestamp }),
+	column("currency", func(e *ledgerEntry) string { return e.event.Currency }),
+	column("base_currency", func(e *ledgerEntry) string { return e.event.BaseCurrency }),
+	decimalRowColumn("amount", func(e *ledgerEntry) float64 { return e.event.Revenue }),
+	decimalRowColumn("amount_base", func(e *ledgerEntry) float64 { return e.event.BaseRevenue }),
+}
+
+// insertRowsQuery inserts columns into table of database.
+func insertRowsQuery[R any](database, table string, columns []rowColumn[R]) string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return "INSERT INTO " + database + "." + table + " (" + strings.Join(names, ", ") + ")"
+}
+
+// insertCommerce writes the order lines and ledger entries of events to
+// database. It is called after the events themselves were written.
+func (w *Writer) insertCommerce(ctx context.Context, database string, events []*collector.RawEvent) error {
+	if err := insertRows(ctx, w, database, w.config.Names.OrderItems, orderItems(events), orderItemColumns); err != nil {
+		return fmt.Errorf("order items: %w", err)
+	}
+	if err := insertRows(ctx, w, database, w.config.Names.OrderLedger, ledgerEntries(events), ledgerColumns); err != nil {
+		return fmt.Errorf("order ledger: %w", err)
+	}
+	return nil
+}
+
+// insertRows writes rows to table of database as one block.
+func insertRows[R any](ctx context.Context, w *Writer, database, table string, rows []R, columns []rowColumn[R]) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "datastore.Insert",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.name", database), attribute.String("db.sql.table", table), tracing.Count(len(rows))),
+	)
+	defer span.End()
+
+	batch, err := w.conn.PrepareBatch(ctx, insertRowsQuery(database, table, columns))
+	if err != nil {
+		metrics.InsertErrors.Inc()
+		tracing.RecordError(span, err)
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+	for i, col := range columns {
+		if err := batch.Column(i).Append(col.values(rows)); err != nil {
+			batch.Abort()
+			metrics.InsertErrors.Inc()
+			tracing.RecordError(span, err)
+			return fmt.Errorf("append column %s: %w", col.name, err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		metrics.InsertErrors.Inc()
+		tracing.RecordError(span, err)
+		return fmt.Errorf("send batch of %d rows: %w", len(rows), err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package writer
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	collector "github.com/hanzoai/analytics/collector"
+	"github.com/hanzoai/analytics/collector/migrate"
+)
+
+func TestOrderItems(t *testing.T) {
+	order := &collector.RawEvent{
+		Event:   collector.StandardEvents.OrderCompleted,
+		EventID: "retry-key",
+		OrderID: "o1",
+		Products: []collector.Product{
+			{SKU: "A", Price: 10, Quantity: 2, Discount: 5},
+			{SKU: "B", Price: 3},
+		},
+	}
+	events := []*collector.RawEvent{
+		order,
+		{Event: collector.StandardEvents.OrderCompleted, Products: order.Products}, // no order ID
+		{Event: collector.StandardEvents.CartViewed, OrderID: "o2", Products: order.Products},
+	}
+
+	items := orderItems(events)
+	if len(items) != 2 || items[0].line != 1 || items[1].line != 2 || items[0].event != order {
+		t.Fatalf("expected the two lines of o1, got %+v", items)
+	}
+	if items[0].id != items[1].id || items[0].id != EventUUID(order) {
+		t.Error("expected lines to carry the order event's ID")
+	}
+
+	for _, col := range orderItemColumns {
+		v := reflect.ValueOf(col.values(items))
+		if v.Kind() != reflect.Slice || v.Len() != len(items) {
+			t.Errorf("column %s: expected a slice of %d values, got %T", col.name, len(items), v.Interface())
+		}
+		switch col.name {
+		case "revenue":
+			got := col.values(items).([]decimal.Decimal)
+			if !got[0].Equal(decimal.NewFromInt(15)) || !got[1].Equal(decimal.NewFromInt(3)) {
+				t.Errorf("unexpected line revenue %v", got)
+			}
+		case "quantity":
+			if got := col.values(items).([]uint32); got[0] != 2 || got[1] != 1 {
+				t.Errorf("unexpected quantities %v", got)
+			}
+		}
+	}
+}
+
+func TestPrepareOrder(t *testing.T) {
+	completed := &collector.RawEvent{Event: collector.StandardEvents.OrderCompleted, OrderID: "o1", Revenue: 20}
+	prepareOrder(completed)
+	if completed.EventID != "order_completed:o1" || completed.Revenue != 20 {
+		t.Errorf("unexpected completed order %q %v", completed.EventID, completed.Revenue)
+	}
+	again := &collector.RawEvent{Event: collector.StandardEvents.OrderCompleted, OrderID: "o1", OrganizationID: completed.OrganizationID}
+	prepareOrder(again)
+	if EventUUID(again) != EventUUID(completed) {
+		t.Error("expected a repeated order to get the same event ID")
+	}
+
+	refund := &collector.RawEvent{Event: collector.StandardEvents.OrderRefunded, OrderID: "o1", Revenue: 5}
+	prepareOrder(refund)
+	if refund.Revenue != -5 || refund.EventID != "" {
+		t.Errorf("unexpected refund %q %v", refund.EventID, refund.Revenue)
+	}
+	partial := &collector.RawEvent{Event: collector.StandardEvents.OrderRefunded, OrderID: "o1",
+		Products: []collector.Product{{Price: 4, Quantity: 2}}}
+	prepareOrder(partial)
+	if partial.Revenue != -8 {
+		t.Errorf("expected the refunded products' total, got %v", partial.Revenue)
+	}
+}
+
+func TestLedgerEntries(t *testing.T) {
+	events := []*collector.RawEvent{
+		{Event: collector.StandardEvents.OrderCompleted, OrderID: "o1", EventID: "a", Revenue: 20},
+		{Event: collector.StandardEvents.OrderRefunded, OrderID: "o1", EventID: "b", Revenue: -5},
+		{Event: collector.StandardEvents.OrderCancelled, OrderID: "o1", EventID: "c"},
+		{Event: collector.StandardEvents.OrderRefunded, EventID: "d", Revenue: -1}, // no order ID
+		{Event: collector.StandardEvents.PageView, OrderID: "o1"},
+	}
+	entries := ledgerEntries(events)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	var kinds []string
+	for i := range entries {
+		kinds = append(kinds, entries[i].kind)
+	}
+	if !reflect.DeepEqual(kinds, []string{ledgerCompleted, ledgerRefunded, ledgerCancelled}) {
+		t.Errorf("unexpected kinds %v", kinds)
+	}
+	if entries[0].entryID() != "" || entries[2].entryID() != "" || entries[1].entryID() != EventUUID(events[1]).String() {
+		t.Error("expected only refunds to be keyed by event")
+	}
+	for _, col := range ledgerColumns {
+		if col.name != "amount" {
+			continue
+		}
+		if amounts := col.values(entries).([]decimal.Decimal); !amounts[1].Equal(decimal.NewFromInt(-5)) {
+			t.Errorf("expected a negative refund amount, got %v", amounts[1])
+		}
+	}
+}
+
+func TestOrderColumns_MatchSchema(t *testing.T) {
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defined := map[string]map[string]bool{}
+	for _, m := range migrations {
+		sql, err := m.Render(migrate.DefaultNames())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range migrate.Statements(sql) {
+			for _, table := range []string{"order_items", "order_ledger"} {
+				body, ok := strings.CutPrefix(stmt, "CREATE TABLE IF NOT EXISTS commerce."+table+" (")
+				if !ok {
+					continue
+				}
+				defined[table] = map[string]bool{}
+				body = body[:strings.Index(body, "ENGINE")]
+				for _, c := range regexp.MustCompile(`(?m)^\s+(\w+) `).FindAllStringSubmatch(body, -1) {
+					defined[table][c[1]] = true
+				}
+			}
+		}
+	}
+
+	check := func(table string, names []string) {
+		if defined[table] == nil {
+			t.Fatalf("commerce.%s is not created by any migration", table)
+		}
+		for _, name := range names {
+			if !defined[table][name] {
+				t.Errorf("column %s is not in commerce.%s", name, table)
+			}
+		}
+	}
+	var names []string
+	for _, c := range orderItemColumns {
+		names = append(names, c.name)
+	}
+	check("order_items", names)
+	names = nil
+	for _, c := range ledgerColumns {
+		names = append(names, c.name)
+	}
+	check("order_ledger", names)
+}
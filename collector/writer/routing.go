@@ -0,0 +1,290 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+// Routing decides which events a forwarder receives and what they contain.
+// An event is forwarded when it matches any Include rule (or there are
+// none), matches no Exclude rule, and falls inside the sample.
+type Routing struct {
+	Include []Rule `yaml:"include" toml:"include"`
+	Exclude []Rule `yaml:"exclude" toml:"exclude"`
+
+	// SampleRate keeps this fraction of distinct IDs, deterministically, so a
+	// sampled user's events are all forwarded together. 0 keeps everything.
+	SampleRate float64 `yaml:"sample_rate" toml:"sample_rate"`
+
+	// Fields projects events onto these top-level fields (by JSON name).
+	// Identity and timing fields are always kept. Empty keeps every field.
+	Fields []string `yaml:"fields" toml:"fields"`
+	// Properties keeps only these property keys. Empty keeps every key.
+	Properties []string `yaml:"properties" toml:"properties"`
+}
+
+// Rule matches events. Every non-empty criterion must match.
+type Rule struct {
+	Organizations []string `yaml:"organizations" toml:"organizations"`
+	// Events are event names or glob patterns such as "ai.*".
+	Events     []string    `yaml:"events" toml:"events"`
+	Libs       []string    `yaml:"libs" toml:"libs"`
+	Properties []Predicate `yaml:"properties" toml:"properties"`
+}
+
+// Predicate operators.
+const (
+	OpEq        = "eq"
+	OpNe        = "ne"
+	OpIn        = "in"
+	OpExists    = "exists"
+	OpNotExists = "not_exists"
+	OpContains  = "contains"
+	OpGt        = "gt"
+	OpLt        = "lt"
+)
+
+// Predicate tests a single event property.
+type Predicate struct {
+	Key    string   `yaml:"key" toml:"key"`
+	Op     string   `yaml:"op" toml:"op"`
+	Value  string   `yaml:"value" toml:"value"`
+	Values []string `yaml:"values" toml:"values"`
+}
+
+// IsZero reports whether the routing forwards every event unchanged.
+func (r *Routing) IsZero() bool {
+	return r == nil || (len(r.Include) == 0 && len(r.Exclude) == 0 &&
+		(r.SampleRate == 0 || r.SampleRate == 1) && len(r.Fields) == 0 && len(r.Properties) == 0)
+}
+
+// Validate reports invalid patterns, operators and rates.
+func (r *Routing) Validate() error {
+	var errs []error
+	if r.SampleRate < 0 || r.SampleRate > 1 {
+		errs = append(errs, errors.New("sample_rate must be between 0 and 1"))
+	}
+	for _, rules := range [][]Rule{r.Include, r.Exclude} {
+		for _, rule := range rules {
+			for _, pattern := range rule.Events {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errs = append(errs, fmt.Errorf("event pattern %q: %w", pattern, err))
+				}
+			}
+			for _, p := range rule.Properties {
+				if p.Key == "" {
+					errs = append(errs, errors.New("property predicate key is required"))
+				}
+				switch p.Op {
+				case OpEq, OpNe, OpIn, OpExists, OpNotExists, OpContains:
+				case OpGt, OpLt:
+					if _, err := strconv.ParseFloat(p.Value, 64); err != nil {
+						errs = append(errs, fmt.Errorf("property %q: %s needs a numeric value", p.Key, p.Op))
+					}
+				default:
+					errs = append(errs, fmt.Errorf("property %q: unknown op %q", p.Key, p.Op))
+				}
+			}
+		}
+	}
+	for _, f := range r.Fields {
+		if _, ok := rawEventFields[f]; !ok {
+			errs = append(errs, fmt.Errorf("unknown field %q", f))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Match reports whether the event should be forwarded.
+func (r *Routing) Match(event *collector.RawEvent) bool {
+	if len(r.Include) > 0 && !matchAny(r.Include, event) {
+		return false
+	}
+	if matchAny(r.Exclude, event) {
+		return false
+	}
+	if r.SampleRate > 0 && r.SampleRate < 1 && !sampled(event.DistinctID, r.SampleRate) {
+		return false
+	}
+	return true
+}
+
+func matchAny(rules []Rule, event *collector.RawEvent) bool {
+	for i := range rules {
+		if rules[i].Match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the event satisfies every criterion of the rule.
+func (r *Rule) Match(event *collector.RawEvent) bool {
+	if len(r.Organizations) > 0 && !contains(r.Organizations, event.OrganizationID) {
+		return false
+	}
+	if len(r.Libs) > 0 && !contains(r.Libs, event.Lib) {
+		return false
+	}
+	if len(r.Events) > 0 && !matchEvent(r.Events, event.Event) {
+		return false
+	}
+	for i := range r.Properties {
+		if !r.Properties[i].Match(event.Properties) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match evaluates the predicate against event properties.
+func (p *Predicate) Match(props map[string]interface{}) bool {
+	v, ok := props[p.Key]
+	switch p.Op {
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+	if !ok {
+		return p.Op == OpNe
+	}
+
+	s := fmt.Sprint(v)
+	switch p.Op {
+	case OpEq:
+		return s == p.Value
+	case OpNe:
+		return s != p.Value
+	case OpIn:
+		return contains(p.Values, s)
+	case OpContains:
+		return strings.Contains(s, p.Value)
+	case OpGt, OpLt:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false
+		}
+		want, _ := strconv.ParseFloat(p.Value, 64)
+		if p.Op == OpGt {
+			return n > want
+		}
+		return n < want
+	}
+	return false
+}
+
+func matchEvent(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sampled deterministically keeps rate of all distinct IDs.
+func sampled(distinctID string, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(distinctID))
+	return float64(h.Sum64()%10000) < rate*10000
+}
+
+// Project returns a copy of the event reduced to the configured fields and
+// properties. The original event is shared with other forwarders and is
+// never modified.
+func (r *Routing) Project(event *collector.RawEvent) *collector.RawEvent {
+	if len(r.Fields) == 0 && len(r.Properties) == 0 {
+		return event
+	}
+
+	var out collector.RawEvent
+	if len(r.Fields) == 0 {
+		out = *event
+	} else {
+		src := reflect.ValueOf(event).Elem()
+		dst := reflect.ValueOf(&out).Elem()
+		for _, name := range alwaysProjected {
+			i := rawEventFields[name]
+			dst.Field(i).Set(src.Field(i))
+		}
+		for _, name := range r.Fields {
+			if i, ok := rawEventFields[name]; ok {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+	}
+
+	if len(r.Properties) > 0 && out.Properties != nil {
+		props := make(map[string]interface{}, len(r.Properties))
+		for _, k := range r.Properties {
+			if v, ok := event.Properties[k]; ok {
+				props[k] = v
+			}
+		}
+		out.Properties = props
+	}
+	return &out
+}
+
+// alwaysProjected are kept by every projection so events stay attributable,
+// and deduplicable downstream by their event ID.
+var alwaysProjected = []string{"event", "event_id", "distinct_id", "organization_id", "timestamp", "sent_at"}
+
+// rawEventFields maps RawEvent JSON field names to struct field indexes.
+var rawEventFields = func() map[string]int {
+	t := reflect.TypeOf(collector.RawEvent{})
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// RoutedForwarder applies routing rules in front of another forwarder. It
+// runs on the caller's goroutine, so put it in front of a BufferedForwarder
+// to keep rejected events out of the buffer.
+type RoutedForwarder struct {
+	next    Forwarder
+	routing *Routing
+}
+
+// NewRoutedForwarder wraps next so it only receives events selected by routing.
+func NewRoutedForwarder(next Forwarder, routing *Routing) *RoutedForwarder {
+	return &RoutedForwarder{next: next, routing: routing}
+}
+
+func (f *RoutedForwarder) Forward(event *collector.RawEvent) {
+	if !f.routing.Match(event) {
+		return
+	}
+	f.next.Forward(f.routing.Project(event))
+}
+
+func (f *RoutedForwarder) Close() error {
+	return f.next.Close()
+}
+
+var _ Forwarder = (*RoutedForwarder)(nil)
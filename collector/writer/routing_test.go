@@ -0,0 +1,117 @@
+package writer
+
+import (
+	"fmt"
+	"testing"
+
+	collector "github.com/hanzoai/analytics/collector"
+)
+
+func TestRoutedForwarder_IncludeExclude(t *testing.T) {
+	mock := &mockForwarder{}
+	fwd := NewRoutedForwarder(mock, &Routing{
+		Include: []Rule{{Events: []string{"ai.*"}}},
+		Exclude: []Rule{{Organizations: []string{"org-internal"}}},
+	})
+
+	fwd.Forward(&collector.RawEvent{Event: "ai.completion", OrganizationID: "org-1"})
+	fwd.Forward(&collector.RawEvent{Event: "ai.completion", OrganizationID: "org-internal"})
+	fwd.Forward(&collector.RawEvent{Event: "$pageview", OrganizationID: "org-1"})
+
+	if got := mock.count(); got != 1 {
+		t.Fatalf("expected 1 forwarded event, got %d", got)
+	}
+}
+
+func TestRule_PropertyPredicates(t *testing.T) {
+	rule := Rule{
+		Libs: []string{"hanzo-cloud"},
+		Properties: []Predicate{
+			{Key: "plan", Op: OpIn, Values: []string{"pro", "team"}},
+			{Key: "duration_ms", Op: OpGt, Value: "1000"},
+			{Key: "internal", Op: OpNotExists},
+		},
+	}
+
+	match := &collector.RawEvent{
+		Lib:        "hanzo-cloud",
+		Properties: map[string]interface{}{"plan": "pro", "duration_ms": 2500.0},
+	}
+	if !rule.Match(match) {
+		t.Error("expected event to match")
+	}
+
+	for name, event := range map[string]*collector.RawEvent{
+		"wrong lib":   {Lib: "astley.js", Properties: match.Properties},
+		"wrong plan":  {Lib: "hanzo-cloud", Properties: map[string]interface{}{"plan": "free", "duration_ms": 2500}},
+		"too fast":    {Lib: "hanzo-cloud", Properties: map[string]interface{}{"plan": "pro", "duration_ms": 10}},
+		"is internal": {Lib: "hanzo-cloud", Properties: map[string]interface{}{"plan": "pro", "duration_ms": 2500, "internal": true}},
+	} {
+		if rule.Match(event) {
+			t.Errorf("%s: expected no match", name)
+		}
+	}
+}
+
+func TestRouting_SampleIsDeterministic(t *testing.T) {
+	r := &Routing{SampleRate: 0.25}
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		event := &collector.RawEvent{DistinctID: fmt.Sprintf("user-%d", i)}
+		first := r.Match(event)
+		if r.Match(event) != first {
+			t.Fatalf("sampling for %s is not deterministic", event.DistinctID)
+		}
+		if first {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("expected about 1000 of 4000 sampled, got %d", kept)
+	}
+}
+
+func TestRouting_Project(t *testing.T) {
+	r := &Routing{
+		Fields:     []string{"url", "properties"},
+		Properties: []string{"plan"},
+	}
+	event := &collector.RawEvent{
+		Event:          "$pageview",
+		EventID:        "evt-1",
+		DistinctID:     "u1",
+		OrganizationID: "org-1",
+		URL:            "https://example.com",
+		IP:             "10.0.0.1",
+		Properties:     map[string]interface{}{"plan": "pro", "email": "a@b.c"},
+	}
+
+	out := r.Project(event)
+	if out.URL != event.URL || out.Event != "$pageview" || out.DistinctID != "u1" {
+		t.Errorf("expected kept fields to be copied: %+v", out)
+	}
+	if out.EventID != "evt-1" {
+		t.Errorf("expected the event ID to survive projection, got %q", out.EventID)
+	}
+	if out.IP != "" {
+		t.Errorf("expected ip to be projected away, got %q", out.IP)
+	}
+	if _, ok := out.Properties["email"]; ok || out.Properties["plan"] != "pro" {
+		t.Errorf("unexpected properties: %v", out.Properties)
+	}
+	if event.IP == "" || len(event.Properties) != 2 {
+		t.Error("expected original event to be unchanged")
+	}
+}
+
+func TestRouting_Validate(t *testing.T) {
+	r := &Routing{
+		SampleRate: 2,
+		Include:    []Rule{{Events: []string{"["}, Properties: []Predicate{{Key: "n", Op: OpGt, Value: "x"}}}},
+		Fields:     []string{"nope"},
+	}
+	if err := r.Validate(); err == nil {
+		t.Error("expected validation errors")
+	}
+}